@@ -1,18 +1,34 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/c-bata/go-prompt"
-	"github.com/go-resty/resty/v2"
+	"github.com/naveen246/kvdb/client"
+	"strconv"
 	"strings"
 )
 
+// namespace is the namespace of the keys of the kv commands, selected with use. Empty for the default namespace.
+var namespace string
+
 func completer(d prompt.Document) []prompt.Suggest {
 	s := []prompt.Suggest{
 		{Text: "kv set k1=v1 addr=localhost:11001", Description: "Set key k1 to value v1"},
 		{Text: "kv get k1 addr=localhost:11001", Description: "Get the value for key k1"},
 		{Text: "kv list keys addr=localhost:11001", Description: "List the keys"},
 		{Text: "kv delete k1 addr=localhost:11001", Description: "Delete the key k1"},
+		{Text: "kv incr k1 addr=localhost:11001", Description: "Increment the integer value of key k1"},
+		{Text: "kv incr k1=-5 addr=localhost:11001", Description: "Add -5 to the integer value of key k1"},
+
+		{Text: "use tenant1", Description: "Send the kv commands to the keys of namespace tenant1"},
+		{Text: "use default", Description: "Send the kv commands to the keys of the default namespace"},
+		{Text: "ns list addr=localhost:11001", Description: "List the namespaces"},
+		{Text: "ns stats tenant1 addr=localhost:11001", Description: "Get the quota and usage of namespace tenant1"},
+		{Text: "ns create tenant1 addr=localhost:11001", Description: "Create namespace tenant1 without quota"},
+		{Text: "ns delete tenant1 addr=localhost:11001", Description: "Delete namespace tenant1 and its keys"},
 
 		{Text: "raft leader addr=localhost:11001", Description: "Get the raft leader"},
 		{Text: "raft servers addr=localhost:11001", Description: "Get all raft servers"},
@@ -24,7 +40,7 @@ func completer(d prompt.Document) []prompt.Suggest {
 
 func main() {
 	for {
-		input := prompt.Input("> ", completer)
+		input := prompt.Input(namespace+"> ", completer)
 		fields := strings.Fields(input)
 		if fields != nil && len(fields) > 0 {
 			if strings.ToLower(fields[0]) == "kv" {
@@ -33,6 +49,22 @@ func main() {
 				} else {
 					fmt.Println("Invalid command")
 				}
+			} else if strings.ToLower(fields[0]) == "ns" {
+				if len(fields) == 3 {
+					handleNamespaceCmd(fields[1], "", fields[2])
+				} else if len(fields) == 4 {
+					handleNamespaceCmd(fields[1], fields[2], fields[3])
+				} else {
+					fmt.Println("Invalid command")
+				}
+			} else if strings.ToLower(fields[0]) == "use" {
+				if len(fields) == 2 && fields[1] == "default" {
+					namespace = ""
+				} else if len(fields) == 2 {
+					namespace = fields[1]
+				} else {
+					fmt.Println("Invalid command")
+				}
 			} else if strings.ToLower(fields[0]) == "raft" {
 				if len(fields) == 3 {
 					handleRaftCmd(fields[1], fields[2])
@@ -48,95 +80,183 @@ func main() {
 
 func handleKVCmd(cmd string, param string, addr string) {
 	cmd = strings.ToLower(cmd)
-	addr = strings.Split(addr, "=")[1]
-	addr = fmt.Sprintf("http://%s", addr)
+	c := client.New(strings.Split(addr, "=")[1])
+	c.Namespace = namespace
 	if cmd == "set" {
 		p := strings.Split(param, "=")
 		if len(p) != 2 {
 			fmt.Println("Invalid command")
 			return
 		}
-		kvSet(p[0], p[1], addr)
+		kvSet(c, p[0], p[1])
 	} else if cmd == "get" {
-		kvGet(param, addr)
+		kvGet(c, param)
 	} else if cmd == "list" {
-		kvList(addr)
+		kvList(c)
 	} else if cmd == "delete" {
-		kvDelete(param, addr)
+		kvDelete(c, param)
+	} else if cmd == "incr" {
+		key, delta, found := strings.Cut(param, "=")
+		if !found {
+			delta = "1"
+		}
+		n, err := strconv.ParseInt(delta, 10, 64)
+		if err != nil {
+			fmt.Println("Invalid command")
+			return
+		}
+		kvIncr(c, key, n)
+	}
+}
+
+func handleNamespaceCmd(cmd string, name string, addr string) {
+	cmd = strings.ToLower(cmd)
+	c := client.New(strings.Split(addr, "=")[1])
+	if cmd == "list" {
+		nsList(c)
+	} else if cmd == "stats" {
+		nsStats(c, name)
+	} else if cmd == "create" {
+		nsCreate(c, name)
+	} else if cmd == "delete" {
+		nsDelete(c, name)
 	}
 }
 
 func handleRaftCmd(cmd string, addr string) {
 	cmd = strings.ToLower(cmd)
-	addr = strings.Split(addr, "=")[1]
-	addr = fmt.Sprintf("http://%s", addr)
+	c := client.New(strings.Split(addr, "=")[1])
 	if cmd == "leader" {
-		raftLeader(addr)
+		raftLeader(c)
 	} else if cmd == "servers" {
-		raftServers(addr)
+		raftServers(c)
 	}
 }
 
-func kvSet(key string, value string, addr string) {
-	resp, err := resty.New().R().
-		SetBody(map[string]string{key: value}).
-		Post(fmt.Sprintf("%s/keys", addr))
+func kvSet(c *client.Client, key string, value string) {
+	err := c.Set(context.Background(), key, value)
 	if err != nil {
 		fmt.Println("Failed to set key", err)
+		return
 	}
 
-	fmt.Println(resp)
+	printJSON(map[string]string{key: value})
 }
 
-func kvGet(key string, addr string) {
-	resp, err := resty.New().R().
-		Get(fmt.Sprintf("%s/keys/%s", addr, key))
+func kvGet(c *client.Client, key string) {
+	value, err := c.Get(context.Background(), key)
+	if errors.Is(err, client.ErrNotFound) {
+		fmt.Println("Key", key, "is not set")
+		return
+	}
 	if err != nil {
 		fmt.Println("Failed to get key", err)
+		return
 	}
 
-	fmt.Println(resp)
+	printJSON(map[string]string{key: value})
 }
 
-func kvList(addr string) {
-	resp, err := resty.New().R().
-		Get(fmt.Sprintf("%s/keys", addr))
+func kvList(c *client.Client) {
+	keys, err := c.Keys(context.Background())
 	if err != nil {
 		fmt.Println("Failed to list keys", err)
+		return
 	}
 
-	fmt.Println(resp)
+	printJSON(keys)
 }
 
-func kvDelete(key string, addr string) {
-	resp, err := resty.New().R().
-		SetHeader("Accept", "application/json").
-		Delete(fmt.Sprintf("%s/keys/%s", addr, key))
+func kvDelete(c *client.Client, key string) {
+	err := c.Delete(context.Background(), key)
 	if err != nil {
 		fmt.Println("Failed to delete key", err)
+		return
+	}
+
+	fmt.Println(key)
+}
+
+func kvIncr(c *client.Client, key string, delta int64) {
+	value, err := c.Incr(context.Background(), key, delta)
+	if err != nil {
+		fmt.Println("Failed to increment key", err)
+		return
+	}
+
+	printJSON(map[string]int64{key: value})
+}
+
+func nsList(c *client.Client) {
+	namespaces, err := c.Namespaces(context.Background())
+	if err != nil {
+		fmt.Println("Failed to list namespaces", err)
+		return
 	}
 
-	fmt.Println(resp)
+	printJSON(namespaces)
 }
 
-func raftLeader(addr string) {
-	url := fmt.Sprintf("%s/raft/leader", addr)
-	resp, err := resty.New().R().
-		Get(url)
+func nsStats(c *client.Client, name string) {
+	stats, err := c.NamespaceStats(context.Background(), name)
+	if errors.Is(err, client.ErrNotFound) {
+		fmt.Println("Namespace", name, "does not exist")
+		return
+	}
+	if err != nil {
+		fmt.Println("Failed to get namespace", err)
+		return
+	}
+
+	printJSON(stats)
+}
+
+func nsCreate(c *client.Client, name string) {
+	stats, err := c.SetNamespace(context.Background(), name, client.Quota{})
+	if err != nil {
+		fmt.Println("Failed to create namespace", err)
+		return
+	}
+
+	printJSON(stats)
+}
+
+func nsDelete(c *client.Client, name string) {
+	err := c.DeleteNamespace(context.Background(), name)
+	if err != nil {
+		fmt.Println("Failed to delete namespace", err)
+		return
+	}
+
+	fmt.Println(name)
+}
+
+func raftLeader(c *client.Client) {
+	leader, err := c.Leader(context.Background())
 	if err != nil {
 		fmt.Println("Failed to get leader", err)
+		return
 	}
 
-	fmt.Println(resp)
+	printJSON(leader)
 }
 
-func raftServers(addr string) {
-	url := fmt.Sprintf("%s/raft/servers", addr)
-	resp, err := resty.New().R().
-		Get(url)
+func raftServers(c *client.Client) {
+	servers, err := c.Servers(context.Background())
 	if err != nil {
 		fmt.Println("Failed to get servers", err)
+		return
+	}
+
+	printJSON(servers)
+}
+
+func printJSON(v any) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		fmt.Println("Failed to format result", err)
+		return
 	}
 
-	fmt.Println(resp)
+	fmt.Println(string(out))
 }
@@ -0,0 +1,460 @@
+// Package client is a Go client for the HTTP API of a kvdb cluster.
+//
+// A Client is created with the HTTP addresses of one or more cluster members. Reads are served by any member,
+// writes are sent to the leader, which the client discovers through /v1/raft/leader and the not_leader errors of
+// followers.
+// Requests failing because a member is unreachable or not the leader are retried on the other members. Writes that
+// are not idempotent, such as increments, are only retried if they provably never reached a member: a failed
+// connection or a not_leader error. Otherwise their error is returned, the write may or may not have been applied.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of a new Client
+const (
+	DefaultRetries    = 3
+	DefaultTimeout    = 10 * time.Second
+	initialBackoff    = 100 * time.Millisecond
+	maxBackoff        = 2 * time.Second
+	maxLeaderRedirect = 3
+)
+
+// requestKind tells how a request is routed and retried
+type requestKind int
+
+const (
+	// read requests are served by any member and retried on any failure
+	read requestKind = iota
+	// write requests are sent to the leader and retried on any failure, applying them twice is harmless
+	write
+	// writeOnce requests are sent to the leader and only retried if they never reached a member or were not proposed
+	writeOnce
+)
+
+// ErrNoMembers is returned by requests when the client knows no cluster member
+var ErrNoMembers = errors.New("no cluster members")
+
+// ErrNotFound matches with errors.Is the errors of requests for keys that are not set and sessions that have expired
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict matches with errors.Is the errors of writes conflicting with the current state of the store,
+// such as increments of values that are not integers or locks held by another session
+var ErrConflict = errors.New("conflict")
+
+// ErrQuotaExceeded matches with errors.Is the errors of writes that would take a namespace over its quota
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// Node is a member of the cluster
+type Node struct {
+	NodeID   string
+	RaftAddr string
+	HTTPAddr string
+	// RESPAddr is the address of the Redis protocol of the node, empty unless the node serves it
+	RESPAddr string
+	// Version is the version of the commands the node applies, 0 if unknown
+	Version int
+}
+
+// Error is returned for requests answered with an error status. Code is the machine-readable error code
+// sent by the server, such as not_found or not_leader.
+type Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is reports whether target is ErrNotFound and the error is a not_found error, target is ErrConflict
+// and the error is a conflict error, or target is ErrQuotaExceeded and the error is a quota_exceeded error
+func (e *Error) Is(target error) bool {
+	return (target == ErrNotFound && e.Code == "not_found") || (target == ErrConflict && e.Code == "conflict") ||
+		(target == ErrQuotaExceeded && e.Code == "quota_exceeded")
+}
+
+// errorResponse is the body of error responses
+type errorResponse struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Leader  *Node  `json:"leader"`
+	} `json:"error"`
+}
+
+// Client sends requests to a kvdb cluster. It is safe for concurrent use.
+type Client struct {
+	http   *resty.Client
+	scheme string
+
+	mu      sync.Mutex
+	members []string
+	next    int
+	leader  string
+
+	// Retries is the number of times a request is retried on all the members, with exponential backoff,
+	// before giving up. A negative value retries until the request context is done.
+	Retries int
+
+	// Namespace, when set, scopes the keys read and written by the client to the namespace
+	Namespace string
+}
+
+// New returns a client of the cluster with members at the HTTP addresses addrs
+func New(addrs ...string) *Client {
+	return &Client{
+		http: resty.New().
+			SetTimeout(DefaultTimeout).
+			SetRedirectPolicy(resty.RedirectPolicyFunc(func(*http.Request, []*http.Request) error {
+				// redirects are followed by do, so that they update the leader and keep the request body
+				return http.ErrUseLastResponse
+			})),
+		scheme:  "http",
+		members: slices.Clone(addrs),
+		Retries: DefaultRetries,
+	}
+}
+
+// SetToken sends token as bearer token with every request
+func (c *Client) SetToken(token string) {
+	c.http.SetAuthToken(token)
+}
+
+// SetTLS sends requests over HTTPS, verifying the certificates of the members with the CA at caFile
+// if it is not empty
+func (c *Client) SetTLS(caFile string) {
+	c.scheme = "https"
+	if caFile != "" {
+		c.http.SetRootCertificate(caFile)
+	}
+}
+
+// SetTimeout bounds the time spent on each HTTP request
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.http.SetTimeout(timeout)
+}
+
+// Members returns the HTTP addresses of the cluster members known to the client
+func (c *Client) Members() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.members)
+}
+
+// Sync replaces the known members by the members of the cluster with a known HTTP address
+func (c *Client) Sync(ctx context.Context) error {
+	nodes, err := c.Servers(ctx)
+	if err != nil {
+		return err
+	}
+
+	var members []string
+	for _, node := range nodes {
+		if node.HTTPAddr != "" {
+			members = append(members, node.HTTPAddr)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.members = members
+	c.next = 0
+	return nil
+}
+
+// Get returns the value of key, or an error matching ErrNotFound if the key is not set
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	var result map[string]string
+	err := c.do(ctx, http.MethodGet, c.namespacePath("/keys")+"/"+url.PathEscape(key), nil, &result, read)
+	return result[key], err
+}
+
+// Set sets key to value
+func (c *Client) Set(ctx context.Context, key, value string) error {
+	return c.do(ctx, http.MethodPost, c.namespacePath("/keys"), map[string]string{key: value}, nil, write)
+}
+
+// Delete removes key
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, http.MethodDelete, c.namespacePath("/keys")+"/"+url.PathEscape(key), nil, nil, write)
+}
+
+// Incr adds delta to the integer value of key, 0 if it is not set, and returns the new value. It fails with
+// an error matching ErrConflict if the value is not an integer or the increment overflows.
+func (c *Client) Incr(ctx context.Context, key string, delta int64) (int64, error) {
+	return c.incr(ctx, key, map[string]int64{"delta": delta})
+}
+
+// IncrBounded is Incr failing with an error matching ErrConflict if the new value would be below min or above max
+func (c *Client) IncrBounded(ctx context.Context, key string, delta, min, max int64) (int64, error) {
+	return c.incr(ctx, key, map[string]int64{"delta": delta, "min": min, "max": max})
+}
+
+func (c *Client) incr(ctx context.Context, key string, body map[string]int64) (int64, error) {
+	var result map[string]int64
+	err := c.do(ctx, http.MethodPost, c.namespacePath("/keys")+"/"+url.PathEscape(key)+"/incr", body, &result, writeOnce)
+	return result[key], err
+}
+
+// NextSequence hands out count IDs of the sequence name and returns the first and last of them. IDs of a sequence
+// start at 1, increase with every range and are never handed out twice.
+func (c *Client) NextSequence(ctx context.Context, name string, count uint64) (uint64, uint64, error) {
+	var result struct {
+		First uint64 `json:"first"`
+		Last  uint64 `json:"last"`
+	}
+	path := "/v1/sequences/" + url.PathEscape(name) + "/next?count=" + strconv.FormatUint(count, 10)
+	err := c.do(ctx, http.MethodPost, path, nil, &result, writeOnce)
+	return result.First, result.Last, err
+}
+
+// Keys returns the keys set in the store
+func (c *Client) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	err := c.do(ctx, http.MethodGet, c.namespacePath("/keys"), nil, &keys, read)
+	return keys, err
+}
+
+// namespacePath returns the path of the API route at path scoped to c.Namespace
+func (c *Client) namespacePath(path string) string {
+	if c.Namespace == "" {
+		return "/v1" + path
+	}
+	return "/v1/namespaces/" + url.PathEscape(c.Namespace) + path
+}
+
+// Leader returns the leader of the cluster, with an empty NodeID if there is none
+func (c *Client) Leader(ctx context.Context) (Node, error) {
+	var leader Node
+	err := c.do(ctx, http.MethodGet, "/v1/raft/leader", nil, &leader, read)
+	return leader, err
+}
+
+// Servers returns the members of the cluster
+func (c *Client) Servers(ctx context.Context) ([]Node, error) {
+	var nodes []Node
+	err := c.do(ctx, http.MethodGet, "/v1/raft/servers", nil, &nodes, read)
+	return nodes, err
+}
+
+// Node returns the member the request is sent to, the first reachable one
+func (c *Client) Node(ctx context.Context) (Node, error) {
+	var node Node
+	err := c.do(ctx, http.MethodGet, "/v1/raft/node", nil, &node, read)
+	return node, err
+}
+
+// Join adds the node with ID nodeID to the cluster, its raft transport reachable at raftAddr
+// and its HTTP API at httpAddr
+func (c *Client) Join(ctx context.Context, nodeID, raftAddr, httpAddr string) error {
+	return c.JoinNode(ctx, Node{NodeID: nodeID, RaftAddr: raftAddr, HTTPAddr: httpAddr})
+}
+
+// JoinNode adds node to the cluster, advertising its HTTP and Redis protocol addresses and its version
+func (c *Client) JoinNode(ctx context.Context, node Node) error {
+	body := map[string]any{
+		"nodeID":   node.NodeID,
+		"addr":     node.RaftAddr,
+		"httpAddr": node.HTTPAddr,
+		"respAddr": node.RESPAddr,
+		"version":  node.Version,
+	}
+	return c.do(ctx, http.MethodPost, "/v1/raft/join", body, nil, write)
+}
+
+// Compact reclaims the disk space of the raft log store of the member the request is sent to
+func (c *Client) Compact(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/raft/compact", nil, nil, read)
+}
+
+// do sends the request to a member, or to the leader for writes, decoding the response into result.
+// Unreachable members and members answering 503 Service Unavailable are skipped, requests sent to followers
+// are sent again to the leader they name. Once every member has been tried, do backs off and starts over until c.Retries is exhausted.
+// writeOnce requests are only sent again while they never reached a member or were answered not_leader, by
+// a member that never proposed them. They are not sent again after leadership is lost with unknown_outcome.
+func (c *Client) do(ctx context.Context, method, path string, body, result any, kind requestKind) error {
+	members := c.Members()
+	if len(members) == 0 {
+		return ErrNoMembers
+	}
+
+	backoff := initialBackoff
+	var err error
+	for round := 0; c.Retries < 0 || round <= c.Retries; round++ {
+		if round > 0 {
+			select {
+			case <-ctx.Done():
+				return contextError(ctx, err)
+			case <-time.After(backoff):
+			}
+			backoff = min(2*backoff, maxBackoff)
+		}
+		if kind != read {
+			c.discoverLeader(ctx)
+		}
+
+		for range members {
+			addr := c.target(kind != read)
+			var retry bool
+			retry, err = c.send(ctx, addr, method, path, body, result, kind)
+			if !retry {
+				return err
+			}
+			if ctx.Err() != nil {
+				return contextError(ctx, err)
+			}
+		}
+	}
+	return err
+}
+
+// send sends the request to the member at addr, following it to the leader when addr answers with
+// 421 Misdirected Request or a redirect. It returns whether the request should be retried on another member.
+func (c *Client) send(ctx context.Context, addr, method, path string, body, result any, kind requestKind) (bool, error) {
+	for redirects := 0; redirects <= maxLeaderRedirect; redirects++ {
+		req := c.http.R().SetContext(ctx)
+		if body != nil {
+			req.SetBody(body)
+		}
+		if result != nil {
+			req.SetResult(result)
+		}
+
+		resp, err := req.Execute(method, fmt.Sprintf("%s://%s%s", c.scheme, addr, path))
+		if err != nil {
+			c.failed(addr)
+			return kind != writeOnce || unsent(err), err
+		}
+
+		switch code := resp.StatusCode(); {
+		case code == http.StatusMisdirectedRequest || code == http.StatusTemporaryRedirect || code == http.StatusPermanentRedirect:
+			// Only followers answering not_leader checked that they were not the leader before proposing
+			if kind == writeOnce && responseError(resp).Code != "not_leader" {
+				return false, responseError(resp)
+			}
+			leader := leaderAddr(resp)
+			if leader == "" {
+				return false, fmt.Errorf("invalid redirect from %s to %q", addr, resp.Header().Get("Location"))
+			}
+			addr = leader
+			c.setLeader(addr)
+		case code == http.StatusServiceUnavailable:
+			c.failed(addr)
+			err := responseError(resp)
+			return kind != writeOnce || err.Code == "not_leader", err
+		case resp.IsError():
+			return false, responseError(resp)
+		default:
+			if kind != read {
+				c.setLeader(addr)
+			}
+			return false, nil
+		}
+	}
+	return true, fmt.Errorf("more than %d redirects", maxLeaderRedirect)
+}
+
+// discoverLeader asks the members for the leader if it is not known yet
+func (c *Client) discoverLeader(ctx context.Context) {
+	c.mu.Lock()
+	known := c.leader != ""
+	c.mu.Unlock()
+	if known {
+		return
+	}
+
+	for _, addr := range c.Members() {
+		var leader Node
+		_, err := c.send(ctx, addr, http.MethodGet, "/v1/raft/leader", nil, &leader, read)
+		if err == nil && leader.HTTPAddr != "" {
+			c.setLeader(leader.HTTPAddr)
+			return
+		}
+	}
+}
+
+// target returns the member to send the next request to
+func (c *Client) target(write bool) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if write && c.leader != "" {
+		return c.leader
+	}
+	addr := c.members[c.next%len(c.members)]
+	c.next++
+	return addr
+}
+
+func (c *Client) setLeader(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leader = addr
+	if !slices.Contains(c.members, addr) {
+		c.members = append(c.members, addr)
+	}
+}
+
+// failed forgets addr as leader after a failed request
+func (c *Client) failed(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leader == addr {
+		c.leader = ""
+	}
+}
+
+// unsent reports whether err, returned for a request, means that the request never reached the server
+func unsent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// contextError returns the error of the done ctx, wrapping the last request error
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return ctx.Err()
+	}
+	return fmt.Errorf("%w, last error: %w", ctx.Err(), err)
+}
+
+// responseError returns the error sent in an error response
+func responseError(resp *resty.Response) *Error {
+	var body errorResponse
+	err := json.Unmarshal(resp.Body(), &body)
+	if err != nil || body.Error.Code == "" {
+		return &Error{StatusCode: resp.StatusCode(), Message: strings.TrimSpace(resp.String())}
+	}
+	return &Error{StatusCode: resp.StatusCode(), Code: body.Error.Code, Message: body.Error.Message}
+}
+
+// leaderAddr returns the HTTP address of the leader a request was sent to by a follower, named in the
+// error response or in the Location header
+func leaderAddr(resp *resty.Response) string {
+	var body errorResponse
+	if json.Unmarshal(resp.Body(), &body) == nil && body.Error.Leader != nil && body.Error.Leader.HTTPAddr != "" {
+		return body.Error.Leader.HTTPAddr
+	}
+	location, err := url.Parse(resp.Header().Get("Location"))
+	if err != nil {
+		return ""
+	}
+	return location.Host
+}
@@ -0,0 +1,302 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCluster is a cluster of fake members sharing one key-value map, only the leader accepts writes.
+// Followers answer writes with not_leader errors naming the leader.
+type testCluster struct {
+	mu        sync.Mutex
+	kv        map[string]string
+	sequences map[string]uint64
+	members   []*httptest.Server
+	leader    int
+	// unavailable is the number of writes followers answer with 503 before redirecting to the leader
+	unavailable int
+	// leadershipLost is the number of increments the leader applies and answers with unknown_outcome, as if it
+	// lost its leadership to the next member before answering
+	leadershipLost int
+}
+
+func newTestCluster(t *testing.T, size int) *testCluster {
+	cluster := &testCluster{kv: make(map[string]string), sequences: make(map[string]uint64)}
+	for i := 0; i < size; i++ {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cluster.serve(i, w, r)
+		}))
+		t.Cleanup(server.Close)
+		cluster.members = append(cluster.members, server)
+	}
+	return cluster
+}
+
+func (tc *testCluster) addr(i int) string {
+	return strings.TrimPrefix(tc.members[i].URL, "http://")
+}
+
+func (tc *testCluster) serve(i int, w http.ResponseWriter, r *http.Request) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	write := r.Method != http.MethodGet
+	if write && i != tc.leader {
+		if tc.unavailable > 0 {
+			tc.unavailable--
+			writeError(w, http.StatusServiceUnavailable, "not_leader", "not leader")
+			return
+		}
+		w.Header().Set("Location", "http://"+tc.addr(tc.leader)+r.URL.Path)
+		writeJSON(w, http.StatusMisdirectedRequest, map[string]any{"error": map[string]any{
+			"code": "not_leader", "message": "not leader", "leader": Node{NodeID: "leader", HTTPAddr: tc.addr(tc.leader)},
+		}})
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/v1/raft/leader":
+		writeJSON(w, http.StatusOK, Node{NodeID: "leader", HTTPAddr: tc.addr(tc.leader)})
+	case r.URL.Path == "/v1/keys" && r.Method == http.MethodGet:
+		keys := []string{}
+		for k := range tc.kv {
+			keys = append(keys, k)
+		}
+		writeJSON(w, http.StatusOK, keys)
+	case r.URL.Path == "/v1/keys" && r.Method == http.MethodPost:
+		m := map[string]string{}
+		err := json.NewDecoder(r.Body).Decode(&m)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid", err.Error())
+			return
+		}
+		for k, v := range m {
+			tc.kv[k] = v
+		}
+		writeJSON(w, http.StatusCreated, m)
+	case strings.HasPrefix(r.URL.Path, "/v1/sequences/") && r.Method == http.MethodPost:
+		name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/sequences/"), "/next")
+		count, _ := strconv.ParseUint(r.URL.Query().Get("count"), 10, 64)
+		first := tc.sequences[name] + 1
+		tc.sequences[name] += count
+		writeJSON(w, http.StatusOK, map[string]any{"name": name, "first": first, "last": first + count - 1})
+	case strings.HasSuffix(r.URL.Path, "/incr") && r.Method == http.MethodPost:
+		key := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/keys/"), "/incr")
+		body := struct{ Delta, Min, Max *int64 }{}
+		json.NewDecoder(r.Body).Decode(&body)
+		n, err := strconv.ParseInt(tc.kv[key], 10, 64)
+		if err != nil && tc.kv[key] != "" {
+			writeError(w, http.StatusConflict, "conflict", "value is not an integer")
+			return
+		}
+		n += *body.Delta
+		if (body.Min != nil && n < *body.Min) || (body.Max != nil && n > *body.Max) {
+			writeError(w, http.StatusConflict, "conflict", "increment out of range")
+			return
+		}
+		tc.kv[key] = strconv.FormatInt(n, 10)
+		if tc.leadershipLost > 0 {
+			tc.leadershipLost--
+			tc.leader = (tc.leader + 1) % len(tc.members)
+			writeError(w, http.StatusServiceUnavailable, "unknown_outcome", "leadership lost while committing log")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int64{key: n})
+	case strings.HasPrefix(r.URL.Path, "/v1/keys/") && r.Method == http.MethodGet:
+		key := strings.TrimPrefix(r.URL.Path, "/v1/keys/")
+		value, ok := tc.kv[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "not_found", "key "+key+" not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{key: value})
+	case strings.HasPrefix(r.URL.Path, "/v1/keys/") && r.Method == http.MethodDelete:
+		delete(tc.kv, strings.TrimPrefix(r.URL.Path, "/v1/keys/"))
+		w.WriteHeader(http.StatusOK)
+	default:
+		writeError(w, http.StatusNotFound, "not_found", "no route")
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, errorCode, message string) {
+	writeJSON(w, code, map[string]any{"error": map[string]string{"code": errorCode, "message": message}})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Test_ClientKV tests that writes sent to a follower reach the leader and reads are served by any member.
+func Test_ClientKV(t *testing.T) {
+	cluster := newTestCluster(t, 3)
+	c := New(cluster.addr(1), cluster.addr(2))
+	ctx := context.Background()
+
+	err := c.Set(ctx, "k1", "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", cluster.kv["k1"])
+	assert.Contains(t, c.Members(), cluster.addr(0))
+
+	value, err := c.Get(ctx, "k1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", value)
+
+	keys, err := c.Keys(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"k1"}, keys)
+
+	err = c.Delete(ctx, "k1")
+	assert.NoError(t, err)
+	_, err = c.Get(ctx, "k1")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	n, err := c.Incr(ctx, "n", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	_, err = c.IncrBounded(ctx, "n", 2, 0, 3)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, "2", cluster.kv["n"])
+
+	first, last, err := c.NextSequence(ctx, "orders", 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{1, 100}, []uint64{first, last})
+	first, last, err = c.NextSequence(ctx, "orders", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{101, 101}, []uint64{first, last})
+}
+
+// Test_ClientFailover tests that requests are retried on other members when a member is down
+// or has no leader, and that a new leader is found after a failover.
+func Test_ClientFailover(t *testing.T) {
+	cluster := newTestCluster(t, 3)
+	c := New(cluster.addr(0), cluster.addr(1), cluster.addr(2))
+	ctx := context.Background()
+
+	err := c.Set(ctx, "k1", "v1")
+	assert.NoError(t, err)
+
+	cluster.members[0].Close()
+	cluster.mu.Lock()
+	cluster.leader = 2
+	cluster.unavailable = 1
+	cluster.mu.Unlock()
+
+	err = c.Set(ctx, "k2", "v2")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", cluster.kv["k2"])
+
+	keys, err := c.Keys(ctx)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"k1", "k2"}, keys)
+}
+
+// Test_ClientErrors tests that error responses are returned without retries and that requests stop
+// once the context is done.
+func Test_ClientErrors(t *testing.T) {
+	cluster := newTestCluster(t, 1)
+	c := New(cluster.addr(0))
+
+	err := c.Compact(context.Background())
+	var apiErr *Error
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.StatusCode)
+	assert.Equal(t, "not_found", apiErr.Code)
+	assert.Equal(t, "no route", apiErr.Message)
+
+	cluster.members[0].Close()
+	c.Retries = -1
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = c.Keys(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	_, err = New().Keys(context.Background())
+	assert.ErrorIs(t, err, ErrNoMembers)
+}
+
+// Test_ClientWriteOnce tests that writes which are not idempotent are not retried once they reached a member,
+// while idempotent writes are.
+func Test_ClientWriteOnce(t *testing.T) {
+	var mu sync.Mutex
+	requests := map[string]int{}
+	count := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests[path]
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/raft/leader" {
+			writeJSON(w, http.StatusOK, Node{NodeID: "leader", HTTPAddr: r.Host})
+			return
+		}
+		// The write is applied, but the connection is lost before the response is sent
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		assert.NoError(t, err)
+		conn.Close()
+	}))
+	defer server.Close()
+
+	c := New(strings.TrimPrefix(server.URL, "http://"))
+	_, err := c.Incr(context.Background(), "n", 1)
+	assert.Error(t, err)
+	assert.Equal(t, 1, count("/v1/keys/n/incr"))
+
+	err = c.Set(context.Background(), "k", "v")
+	assert.Error(t, err)
+	assert.Equal(t, DefaultRetries+1, count("/v1/keys"))
+}
+
+// Test_ClientWriteOnceFailover tests that writes applied at most once are not sent again when the leader loses
+// its leadership before answering, nor on redirects that don't say the write was not proposed.
+func Test_ClientWriteOnceFailover(t *testing.T) {
+	cluster := newTestCluster(t, 2)
+	c := New(cluster.addr(0), cluster.addr(1))
+	ctx := context.Background()
+
+	cluster.leadershipLost = 1
+	_, err := c.Incr(ctx, "n", 1)
+	var apiErr *Error
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "unknown_outcome", apiErr.Code)
+	assert.Equal(t, "1", cluster.kv["n"])
+
+	// The new leader applies the next increment, followers saying they are not the leader are followed
+	n, err := c.Incr(ctx, "n", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/raft/leader" {
+			writeJSON(w, http.StatusOK, Node{NodeID: "leader", HTTPAddr: r.Host})
+			return
+		}
+		requests.Add(1)
+		w.Header().Set("Location", cluster.members[1].URL+r.URL.Path)
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	c = New(strings.TrimPrefix(server.URL, "http://"))
+	_, err = c.Incr(ctx, "n", 1)
+	assert.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusTemporaryRedirect, apiErr.StatusCode)
+	assert.Equal(t, int32(1), requests.Load())
+	assert.Equal(t, "2", cluster.kv["n"])
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Quota limits the number of keys of a namespace and the length of their keys and values. Zero values don't limit.
+type Quota struct {
+	MaxKeys  int64 `json:"maxKeys"`
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// NamespaceStats is the quota and usage of a namespace
+type NamespaceStats struct {
+	Name  string `json:"name"`
+	Quota Quota  `json:"quota"`
+	Keys  int64  `json:"keys"`
+	Bytes int64  `json:"bytes"`
+}
+
+// SetNamespace creates the namespace name, or updates its quota if it exists, and returns it
+func (c *Client) SetNamespace(ctx context.Context, name string, quota Quota) (NamespaceStats, error) {
+	var stats NamespaceStats
+	err := c.do(ctx, http.MethodPut, "/v1/namespaces/"+url.PathEscape(name), quota, &stats, write)
+	return stats, err
+}
+
+// DeleteNamespace deletes the namespace name and its keys
+func (c *Client) DeleteNamespace(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/namespaces/"+url.PathEscape(name), nil, nil, write)
+}
+
+// NamespaceStats returns the quota and usage of the namespace name, or an error matching ErrNotFound if it doesn't exist
+func (c *Client) NamespaceStats(ctx context.Context, name string) (NamespaceStats, error) {
+	var stats NamespaceStats
+	err := c.do(ctx, http.MethodGet, "/v1/namespaces/"+url.PathEscape(name), nil, &stats, read)
+	return stats, err
+}
+
+// Namespaces returns the namespaces with their quota and usage
+func (c *Client) Namespaces(ctx context.Context) ([]NamespaceStats, error) {
+	var namespaces []NamespaceStats
+	err := c.do(ctx, http.MethodGet, "/v1/namespaces", nil, &namespaces, read)
+	return namespaces, err
+}
@@ -0,0 +1,70 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test_ClientNamespaces tests that the keys of a client with a namespace are sent to the routes of the namespace.
+func Test_ClientNamespaces(t *testing.T) {
+	kv := map[string]string{}
+	quota := Quota{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/raft/leader", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Node{NodeID: "leader", HTTPAddr: r.Host})
+	})
+	mux.HandleFunc("/v1/namespaces/tenant1", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			json.NewDecoder(r.Body).Decode(&quota)
+		}
+		writeJSON(w, http.StatusOK, NamespaceStats{Name: "tenant1", Quota: quota, Keys: int64(len(kv))})
+	})
+	mux.HandleFunc("/v1/namespaces/tenant1/keys", func(w http.ResponseWriter, r *http.Request) {
+		m := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&m)
+		if quota.MaxKeys > 0 && int64(len(kv)+len(m)) > quota.MaxKeys {
+			writeError(w, http.StatusConflict, "quota_exceeded", "quota exceeded for namespace tenant1")
+			return
+		}
+		for k, v := range m {
+			kv[k] = v
+		}
+		writeJSON(w, http.StatusCreated, m)
+	})
+	mux.HandleFunc("/v1/namespaces/tenant1/keys/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/v1/namespaces/tenant1/keys/")
+		writeJSON(w, http.StatusOK, map[string]string{key: kv[key]})
+	})
+	mux.HandleFunc("/v1/namespaces/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "not_found", "namespace not found")
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	c := New(strings.TrimPrefix(server.URL, "http://"))
+	c.Namespace = "tenant1"
+	ctx := context.Background()
+
+	stats, err := c.SetNamespace(ctx, "tenant1", Quota{MaxKeys: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, NamespaceStats{Name: "tenant1", Quota: Quota{MaxKeys: 1}}, stats)
+
+	err = c.Set(ctx, "k1", "v1")
+	assert.NoError(t, err)
+	value, err := c.Get(ctx, "k1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", value)
+	err = c.Set(ctx, "k2", "v2")
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	stats, err = c.NamespaceStats(ctx, "tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Keys)
+	_, err = c.NamespaceStats(ctx, "tenant2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Session is created by CreateSession and kept alive by renewing it before ExpiresAt, with RenewSession
+// or KeepAlive. Its locks are released when it expires.
+type Session struct {
+	ID        string
+	TTL       time.Duration
+	ExpiresAt time.Time
+}
+
+// sessionResponse is a session as sent by the server
+type sessionResponse struct {
+	ID        string    `json:"id"`
+	TTL       string    `json:"ttl"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (r sessionResponse) session() (Session, error) {
+	ttl, err := time.ParseDuration(r.TTL)
+	if err != nil {
+		return Session{}, err
+	}
+	return Session{ID: r.ID, TTL: ttl, ExpiresAt: r.ExpiresAt}, nil
+}
+
+// CreateSession creates a session expiring after ttl unless it is renewed
+func (c *Client) CreateSession(ctx context.Context, ttl time.Duration) (Session, error) {
+	var resp sessionResponse
+	err := c.do(ctx, http.MethodPost, "/v1/sessions", map[string]string{"ttl": ttl.String()}, &resp, writeOnce)
+	if err != nil {
+		return Session{}, err
+	}
+	return resp.session()
+}
+
+// RenewSession extends the session with ID id to its TTL from now. It fails with an error matching ErrNotFound
+// if the session has expired.
+func (c *Client) RenewSession(ctx context.Context, id string) (Session, error) {
+	var resp sessionResponse
+	err := c.do(ctx, http.MethodPost, "/v1/sessions/"+url.PathEscape(id)+"/renew", nil, &resp, write)
+	if err != nil {
+		return Session{}, err
+	}
+	return resp.session()
+}
+
+// DestroySession destroys the session with ID id, releasing its locks
+func (c *Client) DestroySession(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/v1/sessions/"+url.PathEscape(id), nil, nil, write)
+}
+
+// KeepAlive renews session every third of its TTL until ctx is done or a renewal fails, and returns
+// the error of the context or of the renewal
+func (c *Client) KeepAlive(ctx context.Context, session Session) error {
+	ticker := time.NewTicker(session.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		_, err := c.RenewSession(ctx, session.ID)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Lock locks key for the session with ID sessionID and sets it to value, and returns the fencing token
+// of the lock. It fails with an error matching ErrConflict if another session holds the lock.
+// The key is deleted when it is unlocked or the session expires.
+func (c *Client) Lock(ctx context.Context, key, value, sessionID string) (uint64, error) {
+	var lock struct {
+		Token uint64 `json:"token"`
+	}
+	body := map[string]string{"session": sessionID, "value": value}
+	err := c.do(ctx, http.MethodPost, "/v1/locks/"+url.PathEscape(key), body, &lock, writeOnce)
+	return lock.Token, err
+}
+
+// Unlock releases the lock on key held by the session with ID sessionID and deletes the key
+func (c *Client) Unlock(ctx context.Context, key, sessionID string) error {
+	body := map[string]string{"session": sessionID}
+	return c.do(ctx, http.MethodPost, "/v1/locks/"+url.PathEscape(key)+"/unlock", body, nil, writeOnce)
+}
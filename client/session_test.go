@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test_ClientSessions tests that sessions are kept alive until they expire and that locks return their token.
+func Test_ClientSessions(t *testing.T) {
+	var mu sync.Mutex
+	renewals := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/raft/leader", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Node{NodeID: "leader", HTTPAddr: r.Host})
+	})
+	mux.HandleFunc("/v1/sessions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusCreated, map[string]string{"id": "s1", "ttl": "300ms", "expiresAt": "2024-07-01T10:00:00Z"})
+	})
+	mux.HandleFunc("/v1/sessions/s1/renew", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		renewals++
+		if renewals == 3 {
+			writeError(w, http.StatusNotFound, "not_found", "session not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id": "s1", "ttl": "300ms", "expiresAt": "2024-07-01T10:00:00Z"})
+	})
+	mux.HandleFunc("/v1/locks/leader", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"key": "leader", "session": "s1", "token": 42})
+	})
+	mux.HandleFunc("/v1/locks/taken", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusConflict, "conflict", "key is locked by another session")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := New(strings.TrimPrefix(server.URL, "http://"))
+	ctx := context.Background()
+
+	session, err := c.CreateSession(ctx, 300*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, Session{ID: "s1", TTL: 300 * time.Millisecond, ExpiresAt: time.Date(2024, 7, 1, 10, 0, 0, 0, time.UTC)}, session)
+
+	token, err := c.Lock(ctx, "leader", "worker1", session.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(42), token)
+	_, err = c.Lock(ctx, "taken", "worker1", session.ID)
+	assert.ErrorIs(t, err, ErrConflict)
+
+	err = c.KeepAlive(ctx, session)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 3, renewals)
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = c.KeepAlive(timeout, session)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Compare is a condition of a transaction on the value of Key. With Exists set, it holds if the key is set
+// to Value, otherwise it holds if the key is not set. If Revision is not zero, it holds if the key is set and
+// was last changed at that raft index instead.
+type Compare struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Exists   bool   `json:"exists,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// Op is an operation of a transaction, created by SetOp or DeleteOp
+type Op struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+// SetOp returns the operation setting key to value
+func SetOp(key, value string) Op {
+	return Op{Op: "set", Key: key, Value: value}
+}
+
+// DeleteOp returns the operation deleting key
+func DeleteOp(key string) Op {
+	return Op{Op: "delete", Key: key}
+}
+
+// Txn is a transaction. If all of Compares hold, the Success operations are applied, otherwise the Failure
+// operations are.
+type Txn struct {
+	Compares []Compare `json:"compares,omitempty"`
+	Success  []Op      `json:"success,omitempty"`
+	Failure  []Op      `json:"failure,omitempty"`
+}
+
+// Event is a change to a watched key, Type is set or delete. Index is the raft log index of the change,
+// the changes of a transaction share the same index.
+type Event struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Index uint64 `json:"index"`
+}
+
+// Txn applies txn atomically and reports whether its compares held
+func (c *Client) Txn(ctx context.Context, txn Txn) (bool, error) {
+	var result struct {
+		Succeeded bool `json:"succeeded"`
+	}
+	err := c.do(ctx, http.MethodPost, c.namespacePath("/txn"), txn, &result, writeOnce)
+	return result.Succeeded, err
+}
+
+// Watch calls fn with the changes to the keys starting with prefix, as they are applied on the member the watch
+// is sent to, until ctx is done or fn returns an error, which Watch returns. Watch returns an error matching
+// ErrConflict if the member drops the watch because it fell behind, and the error of the connection if the member
+// goes away. The caller then reads the keys again and restarts the watch.
+func (c *Client) Watch(ctx context.Context, prefix string, fn func(Event) error) error {
+	members := c.Members()
+	if len(members) == 0 {
+		return ErrNoMembers
+	}
+
+	path := c.namespacePath("/watch") + "?prefix=" + url.QueryEscape(prefix)
+	var resp *http.Response
+	var err error
+	for range members {
+		resp, err = c.openStream(ctx, c.target(false), path)
+		var apiErr *Error
+		if err == nil || ctx.Err() != nil || (errors.As(err, &apiErr) && apiErr.StatusCode != http.StatusServiceUnavailable) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var line struct {
+			Event
+			Error *struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		err := decoder.Decode(&line)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("watch ended: %w", err)
+		}
+		if line.Error != nil {
+			return &Error{StatusCode: http.StatusConflict, Code: line.Error.Code, Message: line.Error.Message}
+		}
+
+		err = fn(line.Event)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// openStream sends a GET request for path to the member at addr and returns the response once its headers are
+// received, leaving the body to the caller. Unlike requests sent by do, the body is read without timeout.
+func (c *Client) openStream(ctx context.Context, addr, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s", c.scheme, addr, path), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.http.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.http.Token)
+	}
+
+	httpClient := *c.http.GetClient()
+	httpClient.Timeout = 0
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var body errorResponse
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		if err != nil || body.Error.Code == "" {
+			return nil, &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return nil, &Error{StatusCode: resp.StatusCode, Code: body.Error.Code, Message: body.Error.Message}
+	}
+	return resp, nil
+}
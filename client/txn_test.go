@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test_ClientTxn tests that transactions are sent to the transaction route of the namespace.
+func Test_ClientTxn(t *testing.T) {
+	var txns []Txn
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/raft/leader", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Node{NodeID: "leader", HTTPAddr: r.Host})
+	})
+	mux.HandleFunc("/v1/namespaces/tenant1/txn", func(w http.ResponseWriter, r *http.Request) {
+		var txn Txn
+		json.NewDecoder(r.Body).Decode(&txn)
+		txns = append(txns, txn)
+		writeJSON(w, http.StatusOK, map[string]bool{"succeeded": len(txns) == 1})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := New(strings.TrimPrefix(server.URL, "http://"))
+	c.Namespace = "tenant1"
+	txn := Txn{
+		Compares: []Compare{{Key: "a", Exists: true, Value: "1"}},
+		Success:  []Op{SetOp("b", "2"), DeleteOp("a")},
+		Failure:  []Op{SetOp("c", "3")},
+	}
+	succeeded, err := c.Txn(context.Background(), txn)
+	assert.NoError(t, err)
+	assert.True(t, succeeded)
+	assert.Equal(t, txn, txns[0])
+
+	succeeded, err = c.Txn(context.Background(), txn)
+	assert.NoError(t, err)
+	assert.False(t, succeeded)
+}
+
+// Test_ClientWatch tests that the streamed changes are passed to the callback until the stream ends.
+func Test_ClientWatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/watch", r.URL.Path)
+		assert.Equal(t, "a/", r.URL.Query().Get("prefix"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		encoder.Encode(Event{Type: "set", Key: "a/1", Value: "v1", Index: 5})
+		encoder.Encode(Event{Type: "delete", Key: "a/2", Index: 6})
+		writeError(w, http.StatusOK, "conflict", "watcher fell behind, read the keys again and restart the watch")
+	}))
+	defer server.Close()
+
+	c := New(strings.TrimPrefix(server.URL, "http://"))
+	c.SetToken("secret")
+	var events []Event
+	err := c.Watch(context.Background(), "a/", func(event Event) error {
+		events = append(events, event)
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, []Event{{Type: "set", Key: "a/1", Value: "v1", Index: 5}, {Type: "delete", Key: "a/2", Index: 6}}, events)
+
+	// The error of the callback ends the watch
+	errStop := errors.New("stop")
+	err = c.Watch(context.Background(), "a/", func(Event) error { return errStop })
+	assert.ErrorIs(t, err, errStop)
+}
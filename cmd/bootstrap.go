@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/naveen246/kvdb/client"
+	"github.com/naveen246/kvdb/config"
+	"github.com/naveen246/kvdb/store"
+	"log"
+	"slices"
+	"time"
+)
+
+// bootstrapExpect forms a cluster from the nodes listed in cfg.Peers, all started with the same settings.
+// The initial voters are the cfg.BootstrapExpect nodes with the lowest HTTP addresses in the peer list, and
+// the first of them bootstraps the cluster once it has reached all the others, while the remaining nodes wait
+// until the cluster is formed. Every node then joins the cluster, which records its HTTP address, or adds it
+// if it is not an initial voter. If a peer already belongs to a cluster, the node joins that cluster instead.
+func bootstrapExpect(cfg *config.Config, stor *store.Store) error {
+	member, err := stor.IsMember()
+	if err != nil {
+		return err
+	}
+	if member {
+		log.Printf("already a member of the cluster, not bootstrapping")
+		return nil
+	}
+
+	deadline := time.Now().Add(cfg.JoinTimeout)
+	err = formCluster(cfg, stor, deadline)
+	if err != nil {
+		return err
+	}
+
+	joinCfg := *cfg
+	joinCfg.Join = cfg.Peers
+	// The initial voters are known by the address of their transport, joining with another spelling of it,
+	// such as localhost for 127.0.0.1, would have the leader replace the node
+	joinCfg.RaftAddr = stor.LocalNode().RaftAddr
+	joinCfg.JoinTimeout = time.Until(deadline)
+	return join(&joinCfg)
+}
+
+// formCluster waits until this node is a member of a cluster bootstrapped from the peers, or until
+// it finds an existing cluster among the peers
+func formCluster(cfg *config.Config, stor *store.Store, deadline time.Time) error {
+	voters := initialVoters(cfg)
+	bootstrapper := voters[0]
+	var peers []string
+	for _, addr := range splitAddrs(cfg.Peers) {
+		if addr != cfg.HTTPAddr && !slices.Contains(peers, addr) {
+			peers = append(peers, addr)
+		}
+	}
+
+	// known holds the nodes found at each address, kept once found so that a peer unreachable for a moment
+	// doesn't hold up the bootstrap
+	known := map[string]client.Node{cfg.HTTPAddr: client.Node(stor.LocalNode())}
+	backoff := joinInitialBackoff
+	for {
+		if discoverPeers(cfg, peers, known) {
+			log.Printf("found an existing cluster among peers, joining it")
+			return nil
+		}
+
+		if bootstrapper == cfg.HTTPAddr {
+			nodes, err := voterNodes(voters, known)
+			if err != nil {
+				return err
+			}
+			if len(nodes) == len(voters) {
+				return stor.BootstrapCluster(storeNodes(nodes))
+			}
+			log.Printf("found %d of %d initial voters", len(nodes), len(voters))
+		} else {
+			member, err := stor.IsMember()
+			if err != nil {
+				return err
+			}
+			if member {
+				log.Printf("cluster bootstrapped by %s", bootstrapper)
+				return nil
+			}
+			log.Printf("waiting for %s to bootstrap the cluster", bootstrapper)
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			if bootstrapper != cfg.HTTPAddr {
+				return fmt.Errorf("%s did not bootstrap the cluster within %s", bootstrapper, cfg.JoinTimeout)
+			}
+			return fmt.Errorf("expected %d initial voters, not found within %s", len(voters), cfg.JoinTimeout)
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, joinMaxBackoff)
+	}
+}
+
+// initialVoters returns the HTTP addresses of the nodes the cluster is bootstrapped with, the
+// cfg.BootstrapExpect lowest addresses among the peers and this node. Every node derives the same list
+// from the shared peer list, whichever peers it can reach, so they all agree on the first as bootstrapper.
+func initialVoters(cfg *config.Config) []string {
+	addrs := append(splitAddrs(cfg.Peers), cfg.HTTPAddr)
+	slices.Sort(addrs)
+	addrs = slices.Compact(addrs)
+	return addrs[:min(cfg.BootstrapExpect, len(addrs))]
+}
+
+// voterNodes returns the nodes found at the addresses of voters, failing if two addresses lead to the same node
+func voterNodes(voters []string, known map[string]client.Node) ([]client.Node, error) {
+	var nodes []client.Node
+	var addrs []string
+	for _, addr := range voters {
+		node, ok := known[addr]
+		if !ok {
+			continue
+		}
+		i := slices.IndexFunc(nodes, func(n client.Node) bool { return n.NodeID == node.NodeID })
+		if i >= 0 {
+			return nil, fmt.Errorf("peers %s and %s are the same node %s", addrs[i], addr, node.NodeID)
+		}
+		nodes = append(nodes, node)
+		addrs = append(addrs, addr)
+	}
+	return nodes, nil
+}
+
+// discoverPeers records in known the nodes found at addrs and returns whether one of them knows a leader,
+// meaning the cluster was already formed.
+func discoverPeers(cfg *config.Config, addrs []string, known map[string]client.Node) bool {
+	for _, addr := range addrs {
+		peer := newClient(cfg, addr)
+		peer.Retries = 0
+
+		node, err := peer.Node(context.Background())
+		var leader client.Node
+		if err == nil {
+			leader, err = peer.Leader(context.Background())
+		}
+		if err != nil {
+			log.Printf("peer %s not reachable: %s", addr, err.Error())
+			continue
+		}
+
+		if leader.NodeID != "" {
+			return true
+		}
+		known[addr] = node
+	}
+	return false
+}
+
+func storeNodes(nodes []client.Node) []store.Node {
+	var result []store.Node
+	for _, node := range nodes {
+		result = append(result, store.Node(node))
+	}
+	return result
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"cmp"
+	"context"
 	"flag"
 	"fmt"
-	"github.com/go-resty/resty/v2"
+	"github.com/naveen246/kvdb/client"
+	"github.com/naveen246/kvdb/config"
+	"github.com/naveen246/kvdb/memcache"
+	"github.com/naveen246/kvdb/resp"
 	"github.com/naveen246/kvdb/service"
 	"github.com/naveen246/kvdb/store"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 )
 
 // Command line defaults
@@ -17,66 +25,346 @@ const (
 	DefaultRaftAddr = "localhost:12001"
 )
 
-// Command line parameters
+// DefaultJoinTimeout is the time spent retrying to join or form a cluster before giving up
+const DefaultJoinTimeout = time.Minute
+
+// shutdownTimeout bounds the time spent draining HTTP connections and shutting raft down on exit
+const shutdownTimeout = 30 * time.Second
+
+// Peers are polled with exponential backoff from joinInitialBackoff to joinMaxBackoff while forming a cluster.
+// joinRequestTimeout bounds each request to another node.
+const (
+	joinInitialBackoff = 500 * time.Millisecond
+	joinMaxBackoff     = 5 * time.Second
+	joinRequestTimeout = 10 * time.Second
+)
+
+// Command line parameters. Flags set on the command line override the configuration file and environment.
+var configPath string
 var httpAddr string
+var grpcAddr string
+var respAddr string
+var memcacheAddr string
 var raftAddr string
 var joinAddr string
+var joinTimeout time.Duration
+var bootstrapExpectCount int
+var peers string
 var nodeID string
+var logBackend string
+var dataDir string
+
+// subcommands maps the name of a kvdb subcommand to the function running it.
+// The function receives the arguments following the subcommand name and returns the process exit code.
+var subcommands = map[string]func(args []string) int{
+	"verify":  runVerify,
+	"inspect": runInspect,
+	"recover": runRecover,
+}
 
 func init() {
+	flag.StringVar(&configPath, "config", os.Getenv("KVDB_CONFIG"), "Path to a YAML configuration file, settings can also be set by KVDB_* environment variables")
 	flag.StringVar(&httpAddr, "httpaddr", DefaultHTTPAddr, "Set the HTTP bind address")
+	flag.StringVar(&grpcAddr, "grpcaddr", "", "Set the gRPC bind address, the gRPC API is disabled if not set")
+	flag.StringVar(&respAddr, "respaddr", "", "Set the Redis protocol bind address, the Redis protocol is disabled if not set")
+	flag.StringVar(&memcacheAddr, "memcacheaddr", "", "Set the memcached protocol bind address, the memcached protocol is disabled if not set")
 	flag.StringVar(&raftAddr, "raftaddr", DefaultRaftAddr, "Set Raft bind address")
-	flag.StringVar(&joinAddr, "join", "", "Set join address, if any")
+	flag.StringVar(&joinAddr, "join", "", "Set comma-separated join addresses, if any")
+	flag.DurationVar(&joinTimeout, "jointimeout", DefaultJoinTimeout, "Give up joining the cluster after this long")
+	flag.IntVar(&bootstrapExpectCount, "bootstrap-expect", 0, "Bootstrap the cluster with this many nodes of -peers, the lowest addresses, as voters")
+	flag.StringVar(&peers, "peers", "", "Comma-separated HTTP addresses of all the nodes of the cluster, for -bootstrap-expect")
 	flag.StringVar(&nodeID, "id", "", "Node ID. If not set, same as Raft bind address")
+	flag.StringVar(&logBackend, "logbackend", string(store.LogBackendBolt), "Raft log storage backend, bolt or wal")
+	flag.StringVar(&dataDir, "datadir", "", "Raft data directory, may also be given as <raft-data-path>. If not set, data/<raftaddr>")
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] <raft-data-path> \n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [<raft-data-path>]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s verify|inspect|recover [options]\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(run(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
-	if nodeID == "" {
-		nodeID = raftAddr
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("invalid configuration: %s", err.Error())
 	}
 
 	stor := store.NewStore()
-	stor.RaftAddr = raftAddr
-	stor.RaftDir = stor.DataDir(raftAddr)
+	if cfg.DataDir == "" {
+		cfg.DataDir = stor.DataDir(cfg.RaftAddr)
+	}
+
+	lock, err := store.LockDataDir(cfg.DataDir)
+	if err != nil {
+		log.Fatalf("failed to lock data directory: %s", err.Error())
+	}
 
-	err := stor.Open(joinAddr == "", nodeID)
+	cfg.NodeID, err = store.ResolveNodeID(cfg.DataDir, cfg.NodeID, cfg.RaftAddr)
+	if err != nil {
+		log.Fatalf("failed to resolve node ID: %s", err.Error())
+	}
+
+	stor.RaftAddr = cfg.RaftAddr
+	stor.HTTPAddr = cfg.HTTPAddr
+	stor.RESPAddr = cfg.RESPAddr
+	stor.RaftDir = cfg.DataDir
+	stor.LogBackend = store.LogBackend(cfg.LogBackend)
+	stor.RaftOptions = store.RaftOptions{
+		HeartbeatTimeout:    cfg.Raft.HeartbeatTimeout,
+		ElectionTimeout:     cfg.Raft.ElectionTimeout,
+		CommitTimeout:       cfg.Raft.CommitTimeout,
+		SnapshotInterval:    cfg.Raft.SnapshotInterval,
+		SnapshotThreshold:   cfg.Raft.SnapshotThreshold,
+		TrailingLogs:        cfg.Raft.TrailingLogs,
+		RetainSnapshotCount: cfg.Raft.RetainSnapshotCount,
+	}
+	sizeLimits := limits(cfg.Limits)
+	stor.Limits = sizeLimits.Limits
+
+	err = stor.Open(cfg.Join == "" && cfg.BootstrapExpect == 0, cfg.NodeID)
 	if err != nil {
 		log.Fatalf("failed to open store: %s", err.Error())
 	}
 
-	service.New(httpAddr, stor, stor).Start()
+	svc := service.New(cfg.HTTPAddr, stor, stor)
+	svc.CertFile = cfg.TLS.CertFile
+	svc.KeyFile = cfg.TLS.KeyFile
+	svc.AuthToken = cfg.Auth.Token
+	svc.Limits = sizeLimits
+	err = svc.Start()
+	if err != nil {
+		log.Fatalf("failed to start HTTP service: %s", err.Error())
+	}
+	servers := []server{svc}
+
+	if cfg.GRPCAddr != "" {
+		grpcSvc := service.NewGRPC(cfg.GRPCAddr, stor, stor)
+		grpcSvc.CertFile = cfg.TLS.CertFile
+		grpcSvc.KeyFile = cfg.TLS.KeyFile
+		grpcSvc.AuthToken = cfg.Auth.Token
+		err = grpcSvc.Start()
+		if err != nil {
+			log.Fatalf("failed to start gRPC service: %s", err.Error())
+		}
+		servers = append(servers, grpcSvc)
+		log.Printf("serving gRPC on %s", cfg.GRPCAddr)
+	}
+
+	if cfg.RESPAddr != "" {
+		respSvc := resp.New(cfg.RESPAddr, stor)
+		respSvc.CertFile = cfg.TLS.CertFile
+		respSvc.KeyFile = cfg.TLS.KeyFile
+		respSvc.AuthToken = cfg.Auth.Token
+		respSvc.MaxBulkLen = max(sizeLimits.MaxKeyLength, sizeLimits.MaxValueSize)
+		err = respSvc.Start()
+		if err != nil {
+			log.Fatalf("failed to start Redis protocol service: %s", err.Error())
+		}
+		servers = append(servers, respSvc)
+		log.Printf("serving the Redis protocol on %s", cfg.RESPAddr)
+	}
+
+	if cfg.MemcacheAddr != "" {
+		memcacheSvc := memcache.New(cfg.MemcacheAddr, stor)
+		memcacheSvc.CertFile = cfg.TLS.CertFile
+		memcacheSvc.KeyFile = cfg.TLS.KeyFile
+		memcacheSvc.AuthToken = cfg.Auth.Token
+		err = memcacheSvc.Start()
+		if err != nil {
+			log.Fatalf("failed to start memcached protocol service: %s", err.Error())
+		}
+		servers = append(servers, memcacheSvc)
+		log.Printf("serving the memcached protocol on %s", cfg.MemcacheAddr)
+	}
 
 	// If join was specified, make the join request.
-	if joinAddr != "" {
-		err := join(joinAddr, raftAddr, nodeID)
+	if cfg.Join != "" {
+		err := join(cfg)
+		if err != nil {
+			shutdown(servers, stor)
+			lock.Release()
+			log.Fatalf("failed to join cluster at %s: %s", cfg.Join, err.Error())
+		}
+	}
+
+	if cfg.BootstrapExpect > 0 {
+		err := bootstrapExpect(cfg, stor)
 		if err != nil {
-			log.Fatalf("failed to join node at %s: %s", joinAddr, err.Error())
+			shutdown(servers, stor)
+			lock.Release()
+			log.Fatalf("failed to form cluster with %s: %s", cfg.Peers, err.Error())
 		}
 	}
 
-	log.Printf("kvdb started successfully, listening on %s", httpAddr)
+	log.Printf("kvdb started successfully, listening on %s", cfg.HTTPAddr)
 
 	terminate := make(chan os.Signal, 1)
-	signal.Notify(terminate, os.Interrupt)
-	<-terminate
-	log.Println("kvdb exiting")
+	signal.Notify(terminate, os.Interrupt, syscall.SIGTERM)
+	sig := <-terminate
+	log.Printf("received %s, kvdb exiting", sig)
+
+	err = shutdown(servers, stor)
+	lock.Release()
+	if err != nil {
+		log.Fatalf("failed to shut down cleanly: %s", err.Error())
+	}
+	log.Println("kvdb stopped")
+}
+
+// server is a network service of the node
+type server interface {
+	Shutdown(ctx context.Context) error
 }
 
-func join(joinAddr, raftAddr, nodeID string) error {
-	url := fmt.Sprintf("http://%s/raft/join", joinAddr)
+// shutdown drains the services and then closes the store, taking a final snapshot.
+// It gives up once shutdownTimeout has passed.
+func shutdown(servers []server, stor *store.Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	_, err := resty.New().R().
-		SetBody(map[string]string{"addr": raftAddr, "nodeID": nodeID}).
-		Post(url)
-	if err != nil {
+	done := make(chan error, 1)
+	go func() {
+		for _, s := range servers {
+			err := s.Shutdown(ctx)
+			if err != nil {
+				log.Printf("failed to drain connections: %s", err.Error())
+			}
+		}
+		done <- stor.Close(true)
+	}()
+
+	select {
+	case err := <-done:
 		return err
+	case <-ctx.Done():
+		return fmt.Errorf("shutdown did not complete within %s", shutdownTimeout)
+	}
+}
+
+// loadConfig builds the configuration from the configuration file, the environment and the command line
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.Load(configPath, config.Config{
+		HTTPAddr:    DefaultHTTPAddr,
+		RaftAddr:    DefaultRaftAddr,
+		JoinTimeout: DefaultJoinTimeout,
+		LogBackend:  string(store.LogBackendBolt),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "httpaddr":
+			cfg.HTTPAddr = httpAddr
+		case "grpcaddr":
+			cfg.GRPCAddr = grpcAddr
+		case "respaddr":
+			cfg.RESPAddr = respAddr
+		case "memcacheaddr":
+			cfg.MemcacheAddr = memcacheAddr
+		case "raftaddr":
+			cfg.RaftAddr = raftAddr
+		case "join":
+			cfg.Join = joinAddr
+		case "jointimeout":
+			cfg.JoinTimeout = joinTimeout
+		case "bootstrap-expect":
+			cfg.BootstrapExpect = bootstrapExpectCount
+		case "peers":
+			cfg.Peers = peers
+		case "id":
+			cfg.NodeID = nodeID
+		case "logbackend":
+			cfg.LogBackend = logBackend
+		case "datadir":
+			cfg.DataDir = dataDir
+		}
+	})
+
+	if flag.NArg() > 1 {
+		return nil, fmt.Errorf("unexpected arguments %v", flag.Args()[1:])
+	}
+	if flag.NArg() == 1 {
+		if dataDir != "" && dataDir != flag.Arg(0) {
+			return nil, fmt.Errorf("data directory given both as -datadir=%s and argument %s", dataDir, flag.Arg(0))
+		}
+		cfg.DataDir = flag.Arg(0)
 	}
 
+	return cfg, cfg.Validate()
+}
+
+// limits returns the limits of the HTTP service and the store, the service defaults overridden by the configured
+// limits
+func limits(l config.Limits) service.Limits {
+	defaults := service.DefaultLimits
+	return service.Limits{
+		Limits: store.Limits{
+			MaxKeyLength: cmp.Or(l.MaxKeyLength, defaults.MaxKeyLength),
+			MaxValueSize: cmp.Or(l.MaxValueSize, defaults.MaxValueSize),
+			MaxBatchSize: cmp.Or(l.MaxBatchSize, defaults.MaxBatchSize),
+		},
+		MaxBodySize: cmp.Or(int64(l.MaxBodySize), defaults.MaxBodySize),
+	}
+}
+
+// join asks the cluster to add this node through the comma-separated addresses in cfg.Join, retrying
+// with backoff until the leader accepts it or cfg.JoinTimeout has passed
+func join(cfg *config.Config) error {
+	addrs := splitAddrs(cfg.Join)
+	if len(addrs) == 0 {
+		return fmt.Errorf("no join address")
+	}
+
+	c := newClient(cfg, addrs...)
+	c.Retries = -1
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.JoinTimeout)
+	defer cancel()
+
+	err := c.JoinNode(ctx, client.Node{
+		NodeID:   cfg.NodeID,
+		RaftAddr: cfg.RaftAddr,
+		HTTPAddr: cfg.HTTPAddr,
+		RESPAddr: cfg.RESPAddr,
+		Version:  store.CommandVersion,
+	})
+	if err != nil {
+		return fmt.Errorf("not joined within %s: %w", cfg.JoinTimeout, err)
+	}
+	log.Printf("joined cluster through %s", cfg.Join)
 	return nil
 }
+
+// newClient returns a client of the cluster members at addrs, with the TLS and auth settings of cfg
+func newClient(cfg *config.Config, addrs ...string) *client.Client {
+	c := client.New(addrs...)
+	c.SetTimeout(joinRequestTimeout)
+	if cfg.TLS.CertFile != "" {
+		c.SetTLS(cfg.TLS.CAFile)
+	}
+	if cfg.Auth.Token != "" {
+		c.SetToken(cfg.Auth.Token)
+	}
+	return c
+}
+
+// splitAddrs returns the addresses in the comma-separated list addrs
+func splitAddrs(addrs string) []string {
+	var result []string
+	for _, addr := range strings.Split(addrs, ",") {
+		addr = strings.TrimSpace(addr)
+		if addr != "" {
+			result = append(result, addr)
+		}
+	}
+	return result
+}
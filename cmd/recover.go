@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/naveen246/kvdb/store"
+	"os"
+)
+
+// runRecover rewrites the cluster configuration of a stopped node so that a cluster which permanently lost
+// quorum can be restarted from its surviving nodes.
+//
+//	kvdb recover -id=node1 -raftaddr=localhost:12001 -peers=peers.json -dryrun
+//
+// The peers file lists the servers of the recovered cluster in the format read by raft.ReadConfigJSON:
+//
+//	[{"id": "node1", "address": "localhost:12001", "non_voter": false}]
+func runRecover(args []string) int {
+	fs := flag.NewFlagSet("recover", flag.ExitOnError)
+	id := fs.String("id", "", "Node ID of this node. If not set, the ID recorded in the data directory")
+	addr := fs.String("raftaddr", DefaultRaftAddr, "Raft bind address of this node")
+	dir := fs.String("datadir", "", "Raft data directory of this node. If not set, data/<raftaddr>")
+	peers := fs.String("peers", "", "Path to the peers file describing the recovered cluster configuration")
+	backend := fs.String("logbackend", string(store.LogBackendBolt), "Raft log storage backend of this node, bolt or wal")
+	dryRun := fs.Bool("dryrun", false, "Report the changes without applying them")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s recover [options]\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Run on every surviving node while stopped, then restart the nodes without -join.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *peers == "" {
+		fs.Usage()
+		return 2
+	}
+	configuration, err := raft.ReadConfigJSON(*peers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read peers file %s: %s\n", *peers, err)
+		return 1
+	}
+
+	stor := store.NewStore()
+	if *dir == "" {
+		*dir = stor.DataDir(*addr)
+	}
+
+	// LockDataDir would create a missing directory
+	_, err = os.Stat(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "data directory: %s\n", err)
+		return 1
+	}
+
+	lock, err := store.LockDataDir(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to lock data directory: %s\n", err)
+		return 1
+	}
+	defer lock.Release()
+
+	if *dryRun {
+		*id, _, err = store.ReadNodeID(*dir, *id, *addr)
+	} else {
+		*id, err = store.ResolveNodeID(*dir, *id, *addr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to resolve node ID: %s\n", err)
+		return 1
+	}
+
+	stor.RaftAddr = *addr
+	stor.RaftDir = *dir
+	stor.LogBackend = store.LogBackend(*backend)
+
+	report, err := stor.Recover(*id, configuration, *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to recover %s: %s\n", stor.RaftDir, err)
+		return 1
+	}
+
+	fmt.Printf("raft dir:       %s\n", stor.RaftDir)
+	fmt.Printf("last index:     %d (term %d)\n", report.LastIndex, report.LastTerm)
+	fmt.Printf("snapshot index: %d\n", report.SnapshotIndex)
+	printServers("current configuration", report.Current)
+	printServers("new configuration", report.Desired)
+	printServers("added", report.Added)
+	printServers("removed", report.Removed)
+	printServers("changed", report.Changed)
+
+	if *dryRun {
+		fmt.Println("dry run, no changes made")
+	} else {
+		fmt.Println("configuration recovered, restart the node without -join")
+	}
+	return 0
+}
+
+func printServers(title string, servers []raft.Server) {
+	fmt.Printf("%s:\n", title)
+	if len(servers) == 0 {
+		fmt.Println("  (none)")
+	}
+	for _, server := range servers {
+		fmt.Printf("  %s at %s (%s)\n", server.ID, server.Address, server.Suffrage)
+	}
+}
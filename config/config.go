@@ -0,0 +1,324 @@
+// Package config loads the kvdb server configuration from a YAML file and KVDB_* environment variables.
+package config
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/naveen246/kvdb/service"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding the configuration.
+// Every setting can be overridden by the upper-cased path of its YAML key joined by underscores,
+// e.g. KVDB_HTTP_ADDR for http_addr and KVDB_RAFT_HEARTBEAT_TIMEOUT for raft.heartbeat_timeout.
+const EnvPrefix = "KVDB"
+
+// Config holds the settings of a kvdb server
+type Config struct {
+	NodeID       string        `yaml:"node_id"`
+	HTTPAddr     string        `yaml:"http_addr"`
+	RaftAddr     string        `yaml:"raft_addr"`
+	GRPCAddr     string        `yaml:"grpc_addr"`     // the gRPC API is served only if set
+	RESPAddr     string        `yaml:"resp_addr"`     // the Redis protocol is served only if set
+	MemcacheAddr string        `yaml:"memcache_addr"` // the memcached protocol is served only if set
+	Join         string        `yaml:"join"`          // comma-separated HTTP addresses of cluster members
+	JoinTimeout  time.Duration `yaml:"join_timeout"`  // time spent retrying to join before giving up
+	DataDir      string        `yaml:"data_dir"`
+	LogBackend   string        `yaml:"log_backend"`
+
+	// BootstrapExpect is the number of initial voters the cluster is bootstrapped with, the nodes with the lowest
+	// addresses in Peers, a comma-separated list of the HTTP addresses of all the nodes. Zero disables the mode.
+	BootstrapExpect int    `yaml:"bootstrap_expect"`
+	Peers           string `yaml:"peers"`
+
+	Raft   Raft   `yaml:"raft"`
+	TLS    TLS    `yaml:"tls"`
+	Auth   Auth   `yaml:"auth"`
+	Limits Limits `yaml:"limits"`
+}
+
+// Raft tunes raft. Zero values keep the raft library defaults.
+type Raft struct {
+	HeartbeatTimeout    time.Duration `yaml:"heartbeat_timeout"`
+	ElectionTimeout     time.Duration `yaml:"election_timeout"`
+	CommitTimeout       time.Duration `yaml:"commit_timeout"`
+	SnapshotInterval    time.Duration `yaml:"snapshot_interval"`
+	SnapshotThreshold   uint64        `yaml:"snapshot_threshold"`
+	TrailingLogs        uint64        `yaml:"trailing_logs"`
+	RetainSnapshotCount int           `yaml:"retain_snapshot_count"`
+}
+
+// TLS secures the HTTP API. The server uses CertFile and KeyFile, CAFile verifies the
+// certificates of other nodes when joining a cluster.
+type TLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+}
+
+// Auth restricts access to the HTTP API to clients presenting Token as bearer token
+type Auth struct {
+	Token string `yaml:"token"`
+}
+
+// Limits bound the size of the writes accepted by the store from every API, and MaxBodySize the size of HTTP
+// request bodies. Sizes are in bytes. Zero values keep the service defaults.
+type Limits struct {
+	MaxKeyLength int `yaml:"max_key_length"`
+	MaxValueSize int `yaml:"max_value_size"`
+	MaxBodySize  int `yaml:"max_body_size"`
+	MaxBatchSize int `yaml:"max_batch_size"`
+}
+
+// FieldError is a validation error for the setting at Field, named by its YAML path
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Load returns the configuration built from defaults, overridden by the YAML file at path if path is not empty,
+// overridden by KVDB_* environment variables. The result is not validated since command line flags may still
+// override it, call Validate once all overrides are applied.
+func Load(path string, defaults Config) (*Config, error) {
+	cfg := defaults
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		err = decoder.Decode(&cfg)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+	}
+
+	err := applyEnv(reflect.ValueOf(&cfg).Elem(), EnvPrefix, "")
+	if err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// applyEnv sets the fields of the struct v from the environment variables named after their YAML path
+func applyEnv(v reflect.Value, envPrefix, fieldPrefix string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		name := field.Tag.Get("yaml")
+		envName := envPrefix + "_" + strings.ToUpper(name)
+		fieldName := fieldPrefix + name
+
+		if field.Type.Kind() == reflect.Struct {
+			err := applyEnv(v.Field(i), envName, fieldName+".")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := os.LookupEnv(envName)
+		if !ok {
+			continue
+		}
+		err := setField(v.Field(i), value)
+		if err != nil {
+			return &FieldError{Field: fieldName, Err: fmt.Errorf("invalid value %q in %s: %w", value, envName, err)}
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.ParseInt(value, 10, 0)
+		if err != nil {
+			return err
+		}
+		field.SetInt(n)
+	case field.Kind() == reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetUint(n)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate checks the configuration and returns a *FieldError naming the first invalid setting
+func (c *Config) Validate() error {
+	if c.HTTPAddr == "" {
+		return &FieldError{Field: "http_addr", Err: errors.New("must be set")}
+	}
+	if c.RaftAddr == "" {
+		return &FieldError{Field: "raft_addr", Err: errors.New("must be set")}
+	}
+	for _, a := range []struct {
+		field string
+		value string
+	}{
+		{"http_addr", c.HTTPAddr},
+		{"raft_addr", c.RaftAddr},
+		{"grpc_addr", c.GRPCAddr},
+		{"resp_addr", c.RESPAddr},
+		{"memcache_addr", c.MemcacheAddr},
+	} {
+		if a.value == "" {
+			continue
+		}
+		_, _, err := net.SplitHostPort(a.value)
+		if err != nil {
+			return &FieldError{Field: a.field, Err: err}
+		}
+	}
+	if c.HTTPAddr == c.RaftAddr {
+		return &FieldError{Field: "raft_addr", Err: errors.New("must differ from http_addr")}
+	}
+	if c.GRPCAddr != "" && (c.GRPCAddr == c.HTTPAddr || c.GRPCAddr == c.RaftAddr) {
+		return &FieldError{Field: "grpc_addr", Err: errors.New("must differ from http_addr and raft_addr")}
+	}
+	if c.RESPAddr != "" && slices.Contains([]string{c.HTTPAddr, c.RaftAddr, c.GRPCAddr}, c.RESPAddr) {
+		return &FieldError{Field: "resp_addr", Err: errors.New("must differ from http_addr, raft_addr and grpc_addr")}
+	}
+	if c.MemcacheAddr != "" && slices.Contains([]string{c.HTTPAddr, c.RaftAddr, c.GRPCAddr, c.RESPAddr}, c.MemcacheAddr) {
+		return &FieldError{Field: "memcache_addr", Err: errors.New("must differ from http_addr, raft_addr, grpc_addr and resp_addr")}
+	}
+
+	if c.JoinTimeout < 0 {
+		return &FieldError{Field: "join_timeout", Err: errors.New("must not be negative")}
+	}
+
+	if c.BootstrapExpect < 0 {
+		return &FieldError{Field: "bootstrap_expect", Err: errors.New("must not be negative")}
+	}
+	if c.BootstrapExpect > 0 {
+		if c.Peers == "" {
+			return &FieldError{Field: "peers", Err: errors.New("must be set with bootstrap_expect")}
+		}
+		if c.Join != "" {
+			return &FieldError{Field: "join", Err: errors.New("can't be used with bootstrap_expect")}
+		}
+		// The peers and this node, which may or may not be listed
+		nodes := []string{c.HTTPAddr}
+		for _, peer := range strings.Split(c.Peers, ",") {
+			peer = strings.TrimSpace(peer)
+			if peer != "" && !slices.Contains(nodes, peer) {
+				nodes = append(nodes, peer)
+			}
+		}
+		if c.BootstrapExpect > len(nodes) {
+			return &FieldError{Field: "bootstrap_expect", Err: fmt.Errorf("%d exceeds the %d nodes listed in peers", c.BootstrapExpect, len(nodes))}
+		}
+	}
+
+	switch c.LogBackend {
+	case "", "bolt", "wal":
+	default:
+		return &FieldError{Field: "log_backend", Err: fmt.Errorf("unknown backend %q, must be bolt or wal", c.LogBackend)}
+	}
+
+	// Unset raft settings keep the raft defaults, check the values raft will run with
+	defaults := raft.DefaultConfig()
+	r := c.Raft
+	for _, d := range []struct {
+		field    string
+		value    time.Duration
+		minValue time.Duration
+	}{
+		{"raft.heartbeat_timeout", r.HeartbeatTimeout, 5 * time.Millisecond},
+		{"raft.election_timeout", r.ElectionTimeout, 5 * time.Millisecond},
+		{"raft.commit_timeout", r.CommitTimeout, time.Millisecond},
+		{"raft.snapshot_interval", r.SnapshotInterval, 5 * time.Millisecond},
+	} {
+		if d.value != 0 && d.value < d.minValue {
+			return &FieldError{Field: d.field, Err: fmt.Errorf("must be at least %s", d.minValue)}
+		}
+	}
+	heartbeat := cmp.Or(r.HeartbeatTimeout, defaults.HeartbeatTimeout)
+	election := cmp.Or(r.ElectionTimeout, defaults.ElectionTimeout)
+	if election < heartbeat {
+		return &FieldError{Field: "raft.election_timeout", Err: fmt.Errorf("%s must be at least raft.heartbeat_timeout %s", election, heartbeat)}
+	}
+	if r.RetainSnapshotCount < 0 {
+		return &FieldError{Field: "raft.retain_snapshot_count", Err: errors.New("must not be negative")}
+	}
+
+	for _, l := range []struct {
+		field string
+		value int
+	}{
+		{"limits.max_key_length", c.Limits.MaxKeyLength},
+		{"limits.max_value_size", c.Limits.MaxValueSize},
+		{"limits.max_body_size", c.Limits.MaxBodySize},
+		{"limits.max_batch_size", c.Limits.MaxBatchSize},
+	} {
+		if l.value < 0 {
+			return &FieldError{Field: l.field, Err: errors.New("must not be negative")}
+		}
+	}
+	// Unset limits keep the service defaults, compare the limits the service will run with
+	maxValueSize := int64(cmp.Or(c.Limits.MaxValueSize, service.DefaultLimits.MaxValueSize))
+	maxBodySize := cmp.Or(int64(c.Limits.MaxBodySize), service.DefaultLimits.MaxBodySize)
+	if maxBodySize < maxValueSize {
+		return &FieldError{Field: "limits.max_body_size", Err: fmt.Errorf("%d must be at least limits.max_value_size %d", maxBodySize, maxValueSize)}
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		field := "tls.key_file"
+		if c.TLS.CertFile == "" {
+			field = "tls.cert_file"
+		}
+		return &FieldError{Field: field, Err: errors.New("tls.cert_file and tls.key_file must be set together")}
+	}
+	for _, f := range []struct {
+		field string
+		path  string
+	}{
+		{"tls.cert_file", c.TLS.CertFile},
+		{"tls.key_file", c.TLS.KeyFile},
+		{"tls.ca_file", c.TLS.CAFile},
+	} {
+		if f.path == "" {
+			continue
+		}
+		_, err := os.Stat(f.path)
+		if err != nil {
+			return &FieldError{Field: f.field, Err: err}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testDefaults = Config{
+	HTTPAddr:   "localhost:11001",
+	RaftAddr:   "localhost:12001",
+	LogBackend: "bolt",
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "kvdb.yaml")
+	err := os.WriteFile(path, []byte(content), 0644)
+	assert.NoError(t, err)
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load("", testDefaults)
+	assert.NoError(t, err)
+	assert.Equal(t, testDefaults, *cfg)
+	assert.NoError(t, cfg.Validate())
+}
+
+func TestLoadFile(t *testing.T) {
+	path := writeConfig(t, `
+node_id: node2
+http_addr: localhost:11002
+grpc_addr: localhost:13002
+resp_addr: localhost:6379
+memcache_addr: localhost:11211
+raft_addr: localhost:12002
+join: localhost:11001,localhost:11003
+join_timeout: 2m
+log_backend: wal
+raft:
+  heartbeat_timeout: 500ms
+  election_timeout: 2s
+  snapshot_threshold: 1024
+  retain_snapshot_count: 3
+auth:
+  token: secret
+limits:
+  max_value_size: 65536
+  max_batch_size: 100
+`)
+
+	cfg, err := Load(path, testDefaults)
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.Validate())
+
+	assert.Equal(t, "node2", cfg.NodeID)
+	assert.Equal(t, "localhost:11002", cfg.HTTPAddr)
+	assert.Equal(t, "localhost:13002", cfg.GRPCAddr)
+	assert.Equal(t, "localhost:6379", cfg.RESPAddr)
+	assert.Equal(t, "localhost:11211", cfg.MemcacheAddr)
+	assert.Equal(t, "localhost:11001,localhost:11003", cfg.Join)
+	assert.Equal(t, 2*time.Minute, cfg.JoinTimeout)
+	assert.Equal(t, "wal", cfg.LogBackend)
+	assert.Equal(t, 500*time.Millisecond, cfg.Raft.HeartbeatTimeout)
+	assert.Equal(t, 2*time.Second, cfg.Raft.ElectionTimeout)
+	assert.Equal(t, uint64(1024), cfg.Raft.SnapshotThreshold)
+	assert.Equal(t, 3, cfg.Raft.RetainSnapshotCount)
+	assert.Equal(t, "secret", cfg.Auth.Token)
+	assert.Equal(t, Limits{MaxValueSize: 65536, MaxBatchSize: 100}, cfg.Limits)
+
+	// Settings missing from the file keep their defaults
+	assert.Empty(t, cfg.DataDir)
+	assert.Zero(t, cfg.Raft.TrailingLogs)
+}
+
+func TestLoadEmptyFile(t *testing.T) {
+	cfg, err := Load(writeConfig(t, ""), testDefaults)
+	assert.NoError(t, err)
+	assert.Equal(t, testDefaults, *cfg)
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	_, err := Load(writeConfig(t, "raft:\n  heartbeat: 1s\n"), testDefaults)
+	assert.ErrorContains(t, err, "line 2: field heartbeat not found")
+}
+
+func TestLoadEnv(t *testing.T) {
+	path := writeConfig(t, "http_addr: localhost:11002\n")
+	t.Setenv("KVDB_HTTP_ADDR", "localhost:11003")
+	t.Setenv("KVDB_RAFT_TRAILING_LOGS", "100")
+	t.Setenv("KVDB_RAFT_COMMIT_TIMEOUT", "20ms")
+	t.Setenv("KVDB_TLS_CA_FILE", "ca.pem")
+
+	cfg, err := Load(path, testDefaults)
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:11003", cfg.HTTPAddr)
+	assert.Equal(t, uint64(100), cfg.Raft.TrailingLogs)
+	assert.Equal(t, 20*time.Millisecond, cfg.Raft.CommitTimeout)
+	assert.Equal(t, "ca.pem", cfg.TLS.CAFile)
+
+	t.Setenv("KVDB_RAFT_RETAIN_SNAPSHOT_COUNT", "two")
+	_, err = Load(path, testDefaults)
+	var fieldErr *FieldError
+	assert.ErrorAs(t, err, &fieldErr)
+	assert.Equal(t, "raft.retain_snapshot_count", fieldErr.Field)
+	assert.ErrorContains(t, err, "KVDB_RAFT_RETAIN_SNAPSHOT_COUNT")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		field  string
+	}{
+		{"missing http addr", func(cfg *Config) { cfg.HTTPAddr = "" }, "http_addr"},
+		{"http addr without port", func(cfg *Config) { cfg.HTTPAddr = "localhost" }, "http_addr"},
+		{"malformed raft addr", func(cfg *Config) { cfg.RaftAddr = "localhost:12001:1" }, "raft_addr"},
+		{"same addresses", func(cfg *Config) { cfg.RaftAddr = cfg.HTTPAddr }, "raft_addr"},
+		{"same grpc address", func(cfg *Config) { cfg.GRPCAddr = cfg.RaftAddr }, "grpc_addr"},
+		{"same resp address", func(cfg *Config) { cfg.RESPAddr = cfg.HTTPAddr }, "resp_addr"},
+		{"same memcache address", func(cfg *Config) { cfg.MemcacheAddr, cfg.RESPAddr = "localhost:6379", "localhost:6379" }, "memcache_addr"},
+		{"negative join timeout", func(cfg *Config) { cfg.JoinTimeout = -time.Second }, "join_timeout"},
+		{"negative bootstrap expect", func(cfg *Config) { cfg.BootstrapExpect = -1 }, "bootstrap_expect"},
+		{"bootstrap expect without peers", func(cfg *Config) { cfg.BootstrapExpect = 3 }, "peers"},
+		{"bootstrap expect above peers", func(cfg *Config) {
+			cfg.BootstrapExpect, cfg.Peers = 3, "localhost:11002"
+		}, "bootstrap_expect"},
+		{"bootstrap expect with join", func(cfg *Config) {
+			cfg.BootstrapExpect, cfg.Peers, cfg.Join = 3, "localhost:11001", "localhost:11001"
+		}, "join"},
+		{"unknown log backend", func(cfg *Config) { cfg.LogBackend = "rocksdb" }, "log_backend"},
+		{"short heartbeat", func(cfg *Config) { cfg.Raft.HeartbeatTimeout = time.Millisecond }, "raft.heartbeat_timeout"},
+		{"negative commit timeout", func(cfg *Config) { cfg.Raft.CommitTimeout = -time.Second }, "raft.commit_timeout"},
+		{"election below default heartbeat", func(cfg *Config) { cfg.Raft.ElectionTimeout = 500 * time.Millisecond }, "raft.election_timeout"},
+		{"negative retained snapshots", func(cfg *Config) { cfg.Raft.RetainSnapshotCount = -1 }, "raft.retain_snapshot_count"},
+		{"negative key length", func(cfg *Config) { cfg.Limits.MaxKeyLength = -1 }, "limits.max_key_length"},
+		{"body smaller than value", func(cfg *Config) { cfg.Limits.MaxValueSize, cfg.Limits.MaxBodySize = 1024, 512 }, "limits.max_body_size"},
+		{"body smaller than default value", func(cfg *Config) { cfg.Limits.MaxBodySize = 512 << 10 }, "limits.max_body_size"},
+		{"value larger than default body", func(cfg *Config) { cfg.Limits.MaxValueSize = 8 << 20 }, "limits.max_body_size"},
+		{"cert without key", func(cfg *Config) { cfg.TLS.CertFile = "cert.pem" }, "tls.key_file"},
+		{"missing ca file", func(cfg *Config) { cfg.TLS.CAFile = "missing.pem" }, "tls.ca_file"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := testDefaults
+			test.modify(&cfg)
+
+			err := cfg.Validate()
+			var fieldErr *FieldError
+			assert.ErrorAs(t, err, &fieldErr)
+			assert.Equal(t, test.field, fieldErr.Field)
+		})
+	}
+}
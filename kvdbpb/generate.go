@@ -0,0 +1,4 @@
+// Package kvdbpb holds the protobuf messages and gRPC services of the kvdb gRPC API.
+package kvdbpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative kvdb.proto
@@ -0,0 +1,357 @@
+package memcache
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/naveen246/kvdb/store"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bounds of keys and values, as in memcached
+const (
+	maxKeyLen    = 250
+	maxValueSize = 1024 * 1024
+)
+
+// maxRelativeExptime is the largest expiration time taken as seconds from now, larger ones are unix timestamps
+const maxRelativeExptime = 30 * 24 * 60 * 60
+
+// maxCASRetries bounds the attempts of commands reading a key and then writing it only if it did not change
+const maxCASRetries = 100
+
+var errContention = errors.New("too many concurrent changes, try again")
+
+// errNotNumeric is returned by incr and decr for values that are not unsigned 64-bit integers
+var errNotNumeric = errors.New("cannot increment or decrement non-numeric value")
+
+// commands maps the command names to the functions running them, which receive the command name
+// followed by its arguments
+var commands = map[string]func(s *Server, c *conn, args []string){
+	"get":     (*Server).get,
+	"gets":    (*Server).get,
+	"set":     (*Server).storage,
+	"add":     (*Server).storage,
+	"replace": (*Server).storage,
+	"cas":     (*Server).storage,
+	"delete":  (*Server).delete,
+	"incr":    (*Server).incr,
+	"decr":    (*Server).incr,
+	"touch":   (*Server).touch,
+	"version": (*Server).version,
+	"quit":    (*Server).quit,
+}
+
+// get writes the items of the keys that are set, get|gets <key>*. Items are written with their cas unique
+// for gets.
+func (s *Server) get(c *conn, args []string) {
+	if len(args) < 2 {
+		c.w.WriteString("ERROR\r\n")
+		return
+	}
+	for _, key := range args[1:] {
+		if !validKey(key) {
+			c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+	}
+
+	for _, key := range args[1:] {
+		item, ok := s.store.Item(key)
+		if !ok {
+			continue
+		}
+		if args[0] == "gets" {
+			fmt.Fprintf(c.w, "VALUE %s 0 %d %d\r\n", key, len(item.Value), item.Revision)
+		} else {
+			fmt.Fprintf(c.w, "VALUE %s 0 %d\r\n", key, len(item.Value))
+		}
+		c.w.WriteString(item.Value + "\r\n")
+	}
+	c.w.WriteString("END\r\n")
+}
+
+// storage runs the storage commands, set|add|replace <key> <flags> <exptime> <bytes> [noreply] and
+// cas <key> <flags> <exptime> <bytes> <cas unique> [noreply], followed by a data block of <bytes> bytes
+func (s *Server) storage(c *conn, args []string) {
+	name := args[0]
+	n := 5
+	if name == "cas" {
+		n = 6
+	}
+	if len(args) != n && !(len(args) == n+1 && args[n] == "noreply") {
+		c.w.WriteString("ERROR\r\n")
+		return
+	}
+
+	key := args[1]
+	_, flagsErr := strconv.ParseUint(args[2], 10, 32)
+	exptime, exptimeErr := strconv.ParseInt(args[3], 10, 64)
+	size, sizeErr := strconv.Atoi(args[4])
+	var unique uint64
+	var uniqueErr error
+	if name == "cas" {
+		unique, uniqueErr = strconv.ParseUint(args[5], 10, 64)
+	}
+	if !validKey(key) || flagsErr != nil || exptimeErr != nil || sizeErr != nil || size < 0 || uniqueErr != nil {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	c.noreply = len(args) == n+1
+
+	if size > maxValueSize {
+		// The data block is skipped to read the next command
+		io.CopyN(io.Discard, c.r, int64(size)+2)
+		c.reply("SERVER_ERROR object too large for cache")
+		return
+	}
+	data := make([]byte, size+2)
+	_, err := io.ReadFull(c.r, data)
+	if err != nil {
+		c.quit = true
+		return
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		c.w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		c.quit = true
+		return
+	}
+	value := string(data[:size])
+
+	if !c.authed {
+		s.authenticate(c, value)
+		return
+	}
+
+	op := store.TxnOp{Op: store.CmdSet, Key: key, Value: value, ExpiresAt: expiresAt(exptime)}
+	switch name {
+	case "set":
+		_, err = s.store.Txn(store.Txn{Success: []store.TxnOp{op}})
+		s.replyResult(c, err, true, "STORED", "")
+	case "add":
+		var stored bool
+		stored, err = s.store.Txn(store.Txn{Compares: []store.Compare{{Key: key}}, Success: []store.TxnOp{op}})
+		s.replyResult(c, err, stored, "STORED", "NOT_STORED")
+	case "replace":
+		var exists bool
+		_, exists, err = s.update(key, func(_ store.Item, exists bool) ([]store.TxnOp, error) {
+			if !exists {
+				return nil, nil
+			}
+			return []store.TxnOp{op}, nil
+		})
+		s.replyResult(c, err, exists, "STORED", "NOT_STORED")
+	case "cas":
+		s.cas(c, op, unique)
+	}
+}
+
+// cas stores op if its key was last changed at revision unique
+func (s *Server) cas(c *conn, op store.TxnOp, unique uint64) {
+	// Revisions start at 1, a zero unique never matches
+	stored := false
+	var err error
+	if unique != 0 {
+		stored, err = s.store.Txn(store.Txn{
+			Compares: []store.Compare{{Key: op.Key, Revision: unique}},
+			Success:  []store.TxnOp{op},
+		})
+	}
+	if err != nil || stored {
+		s.replyResult(c, err, stored, "STORED", "")
+		return
+	}
+
+	if _, exists := s.store.Item(op.Key); exists {
+		c.reply("EXISTS")
+		return
+	}
+	c.reply("NOT_FOUND")
+}
+
+// authenticate authenticates the client with data "<username> <token>" sent by a set command
+func (s *Server) authenticate(c *conn, data string) {
+	_, token, _ := strings.Cut(data, " ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.AuthToken)) != 1 {
+		c.reply("CLIENT_ERROR authentication failure")
+		return
+	}
+	c.authed = true
+	c.reply("STORED")
+}
+
+// delete deletes a key, delete <key> [0] [noreply]
+func (s *Server) delete(c *conn, args []string) {
+	if len(args) > 2 && args[2] == "0" {
+		args = append(args[:2], args[3:]...)
+	}
+	if len(args) < 2 || len(args) > 3 || (len(args) == 3 && args[2] != "noreply") || !validKey(args[1]) {
+		c.w.WriteString("CLIENT_ERROR bad command line format.  Usage: delete <key> [noreply]\r\n")
+		return
+	}
+	c.noreply = len(args) == 3
+
+	_, exists, err := s.update(args[1], func(_ store.Item, exists bool) ([]store.TxnOp, error) {
+		if !exists {
+			return nil, nil
+		}
+		return []store.TxnOp{{Op: store.CmdDelete, Key: args[1]}}, nil
+	})
+	s.replyResult(c, err, exists, "DELETED", "NOT_FOUND")
+}
+
+// incr adds to or subtracts from the value of a key, incr|decr <key> <value> [noreply]. Values are unsigned
+// 64-bit integers, incr wraps around on overflow, decr stops at 0. The key keeps its expiration time.
+func (s *Server) incr(c *conn, args []string) {
+	if len(args) != 3 && !(len(args) == 4 && args[3] == "noreply") {
+		c.w.WriteString("ERROR\r\n")
+		return
+	}
+	if !validKey(args[1]) {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	delta, err := strconv.ParseUint(args[2], 10, 64)
+	if err != nil {
+		c.w.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+	c.noreply = len(args) == 4
+
+	var result uint64
+	_, exists, err := s.update(args[1], func(item store.Item, exists bool) ([]store.TxnOp, error) {
+		if !exists {
+			return nil, nil
+		}
+		n, err := strconv.ParseUint(item.Value, 10, 64)
+		if err != nil {
+			return nil, errNotNumeric
+		}
+
+		switch {
+		case args[0] == "incr":
+			result = n + delta
+		case delta > n:
+			result = 0
+		default:
+			result = n - delta
+		}
+		return []store.TxnOp{{Op: store.CmdSet, Key: args[1], Value: strconv.FormatUint(result, 10), KeepTTL: true}}, nil
+	})
+	if errors.Is(err, errNotNumeric) {
+		c.reply("CLIENT_ERROR " + err.Error())
+		return
+	}
+	s.replyResult(c, err, exists, strconv.FormatUint(result, 10), "NOT_FOUND")
+}
+
+// touch changes the expiration time of a key, touch <key> <exptime> [noreply]
+func (s *Server) touch(c *conn, args []string) {
+	if len(args) != 3 && !(len(args) == 4 && args[3] == "noreply") {
+		c.w.WriteString("ERROR\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[2], 10, 64)
+	if !validKey(args[1]) || err != nil {
+		c.w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	c.noreply = len(args) == 4
+
+	_, exists, err := s.update(args[1], func(item store.Item, exists bool) ([]store.TxnOp, error) {
+		if !exists {
+			return nil, nil
+		}
+		return []store.TxnOp{{Op: store.CmdSet, Key: args[1], Value: item.Value, ExpiresAt: expiresAt(exptime)}}, nil
+	})
+	s.replyResult(c, err, exists, "TOUCHED", "NOT_FOUND")
+}
+
+func (s *Server) version(c *conn, _ []string) {
+	c.w.WriteString("VERSION kvdb\r\n")
+}
+
+func (s *Server) quit(c *conn, _ []string) {
+	c.quit = true
+}
+
+// update applies the operations returned by change for the current item of key, if the key did not change
+// meanwhile, retrying otherwise. The transaction is applied even without operations, checking the item on
+// the leader. It returns the item change was called with last and whether it was set.
+func (s *Server) update(key string, change func(item store.Item, exists bool) ([]store.TxnOp, error)) (store.Item, bool, error) {
+	for attempt := 0; attempt < maxCASRetries; attempt++ {
+		item, exists := s.store.Item(key)
+		ops, err := change(item, exists)
+		if err != nil {
+			return item, exists, err
+		}
+
+		compare := store.Compare{Key: key}
+		if exists {
+			compare.Revision = item.Revision
+		}
+		ok, err := s.store.Txn(store.Txn{Compares: []store.Compare{compare}, Success: ops})
+		if err != nil || ok {
+			return item, exists, err
+		}
+	}
+	return store.Item{}, false, errContention
+}
+
+// replyResult replies to a mutation with success or failure depending on ok, or with the error of the mutation
+func (s *Server) replyResult(c *conn, err error, ok bool, success, failure string) {
+	switch {
+	case errors.Is(err, store.ErrNotLeader):
+		leader := s.store.Leader()
+		if leader.NodeID == "" {
+			c.reply("SERVER_ERROR " + err.Error() + ", no leader elected")
+			return
+		}
+		msg := fmt.Sprintf("SERVER_ERROR %s, leader is %s", err, leader.NodeID)
+		if leader.HTTPAddr != "" {
+			msg += " at " + leader.HTTPAddr
+		}
+		c.reply(msg)
+	case errors.Is(err, store.ErrTooLarge):
+		c.reply("SERVER_ERROR object too large for cache")
+	case err != nil:
+		c.reply("SERVER_ERROR " + err.Error())
+	case ok:
+		c.reply(success)
+	default:
+		c.reply(failure)
+	}
+}
+
+// expiresAt returns the expiry time in unix nanoseconds of a key stored with a memcached expiration time,
+// zero for no expiration, seconds from now up to 30 days, a unix timestamp beyond, expired if negative
+func expiresAt(exptime int64) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		// the earliest time that is not zero
+		return 1
+	case exptime <= maxRelativeExptime:
+		return time.Now().Add(time.Duration(exptime) * time.Second).UnixNano()
+	default:
+		return time.Unix(min(exptime, math.MaxInt64/int64(time.Second)), 0).UnixNano()
+	}
+}
+
+// validKey reports whether key is a valid memcached key, without control characters
+func validKey(key string) bool {
+	if len(key) > maxKeyLen {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
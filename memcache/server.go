@@ -0,0 +1,255 @@
+// Package memcache serves the key-value store over the memcached text protocol, for clients that speak only
+// memcached.
+//
+// The storage commands, get, gets, delete, incr, decr and touch are supported. Reads are served from the local
+// store, mutations are replicated through raft and fail with a SERVER_ERROR on followers, naming the leader.
+// The cas unique of a key is its revision, the raft index of its last change. Flags are not stored, items are
+// returned with flags 0.
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"github.com/naveen246/kvdb/store"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is the key-value store served by the Server
+type Store interface {
+	// Item returns key as stored and whether it is set
+	Item(key string) (store.Item, bool)
+
+	// Txn applies txn, via distributed consensus, and reports whether its compares succeeded
+	Txn(txn store.Txn) (bool, error)
+
+	// Leader returns the leader of the cluster
+	Leader() store.Node
+}
+
+// Server serves the memcached text protocol over TCP
+type Server struct {
+	addr  string
+	store Store
+	ln    net.Listener
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	closing bool
+	wg      sync.WaitGroup
+
+	// CertFile and KeyFile enable TLS when both are set
+	CertFile string
+	KeyFile  string
+
+	// AuthToken, when set, must be sent by clients before other commands as the data of a set command,
+	// "<username> <token>" with any username, as for memcached text protocol authentication
+	AuthToken string
+}
+
+// New returns an uninitialized memcached server
+func New(addr string, store Store) *Server {
+	return &Server{
+		addr:  addr,
+		store: store,
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// Start starts the server. The listener is bound before Start returns, connections are served in the background.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	if s.CertFile != "" && s.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	s.ln = ln
+	go s.serve()
+	return nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *Server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("memcache accept: %s", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		c := &conn{
+			Conn:   nc,
+			r:      bufio.NewReader(nc),
+			w:      bufio.NewWriter(nc),
+			authed: s.AuthToken == "",
+		}
+		s.conns[nc] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(c)
+
+			s.mu.Lock()
+			delete(s.conns, nc)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Shutdown stops accepting new connections and closes the open connections once their running command
+// has been answered. Connections still open when ctx is done are closed immediately.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.ln == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.closing = true
+	s.ln.Close()
+	// Connections waiting for a command stop reading, the others stop after their running command
+	for nc := range s.conns {
+		nc.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for nc := range s.conns {
+			nc.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// conn is a client connection
+type conn struct {
+	net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	authed bool
+	quit   bool
+	// noreply is set while running a command sent with noreply, whose replies are not written
+	noreply bool
+}
+
+// reply writes a reply line unless the running command was sent with noreply
+func (c *conn) reply(line string) {
+	if !c.noreply {
+		c.w.WriteString(line + "\r\n")
+	}
+}
+
+// serveConn runs the commands sent on c until the client quits or the connection fails. Replies to
+// pipelined commands are flushed together.
+func (s *Server) serveConn(c *conn) {
+	defer c.Close()
+
+	for !c.quit {
+		line, err := readLine(c.r)
+		if errors.Is(err, errLineTooLong) {
+			c.w.WriteString("CLIENT_ERROR line too long\r\n")
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			var netErr net.Error
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+				log.Printf("memcache read from %s: %s", c.RemoteAddr(), err)
+			}
+			return
+		}
+
+		args := strings.Fields(line)
+		if len(args) == 0 {
+			c.w.WriteString("ERROR\r\n")
+		} else {
+			s.run(c, args)
+		}
+
+		if c.r.Buffered() == 0 || c.quit {
+			err = c.w.Flush()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// run runs the command args, its name followed by its arguments, on c and writes its reply
+func (s *Server) run(c *conn, args []string) {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		c.w.WriteString("ERROR\r\n")
+		return
+	}
+	if !c.authed && args[0] != "set" && args[0] != "quit" {
+		c.w.WriteString("CLIENT_ERROR unauthenticated\r\n")
+		return
+	}
+
+	c.noreply = false
+	cmd(s, c, args)
+	c.noreply = false
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// maxLineLen bounds the length of command lines
+const maxLineLen = 64 * 1024
+
+// readLine reads a line terminated by LF or CRLF, without its terminator
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxLineLen {
+			return "", errLineTooLong
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
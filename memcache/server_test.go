@@ -0,0 +1,196 @@
+package memcache
+
+import (
+	"bufio"
+	"context"
+	"github.com/naveen246/kvdb/store"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStore is a single node store applying transactions in memory, the revision of a change is
+// the number of transactions applied
+type testStore struct {
+	mu     sync.Mutex
+	items  map[string]store.Item
+	index  uint64
+	leader bool
+	limits store.Limits
+}
+
+func newTestStore() *testStore {
+	return &testStore{items: make(map[string]store.Item), leader: true}
+}
+
+func (t *testStore) Item(key string) (store.Item, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	item, ok := t.items[key]
+	return item, ok
+}
+
+func (t *testStore) Txn(txn store.Txn) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.leader {
+		return false, store.ErrNotLeader
+	}
+	err := t.limits.CheckTxn(txn)
+	if err != nil {
+		return false, err
+	}
+
+	t.index++
+	ops, succeeded := txn.Success, true
+	for _, c := range txn.Compares {
+		item, ok := t.items[c.Key]
+		if (c.Revision != 0 && item.Revision != c.Revision) || (c.Revision == 0 && (ok != c.Exists || item.Value != c.Value)) {
+			ops, succeeded = txn.Failure, false
+			break
+		}
+	}
+	for _, op := range ops {
+		if op.Op == store.CmdDelete {
+			delete(t.items, op.Key)
+			continue
+		}
+		item := store.Item{Value: op.Value, Revision: t.index, ExpiresAt: op.ExpiresAt}
+		if op.KeepTTL {
+			item.ExpiresAt = t.items[op.Key].ExpiresAt
+		}
+		t.items[op.Key] = item
+	}
+	return succeeded, nil
+}
+
+func (t *testStore) Leader() store.Node {
+	if t.leader {
+		return store.Node{NodeID: "node1", HTTPAddr: "localhost:11001"}
+	}
+	return store.Node{NodeID: "node2", HTTPAddr: "localhost:11002"}
+}
+
+// testClient sends commands to a server and reads its replies
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestServer(t *testing.T, kv Store, authToken string) (*Server, *testClient) {
+	s := New("localhost:0", kv)
+	s.AuthToken = authToken
+	assert.NoError(t, s.Start())
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	conn, err := net.Dial("tcp", s.Addr().String())
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return s, &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends lines, joined by CRLF, and returns the reply lines up to the line starting with last
+func (c *testClient) do(last string, lines ...string) []string {
+	_, err := io.WriteString(c.conn, strings.Join(lines, "\r\n")+"\r\n")
+	assert.NoError(c.t, err)
+
+	var reply []string
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		line, err := c.r.ReadString('\n')
+		if !assert.NoError(c.t, err) {
+			return reply
+		}
+		line = strings.TrimSuffix(line, "\r\n")
+		reply = append(reply, line)
+		if strings.HasPrefix(line, last) {
+			return reply
+		}
+	}
+}
+
+// line sends lines and returns the first reply line
+func (c *testClient) line(lines ...string) string {
+	return c.do("", lines...)[0]
+}
+
+// Test_MemcacheStorage tests the storage and retrieval commands.
+func Test_MemcacheStorage(t *testing.T) {
+	kv := newTestStore()
+	_, c := newTestServer(t, kv, "")
+
+	assert.Equal(t, "STORED", c.line("set k1 5 0 2", "v1"))
+	assert.Equal(t, []string{"VALUE k1 0 2", "v1", "END"}, c.do("END", "get k1 missing"))
+	assert.Equal(t, []string{"END"}, c.do("END", "get missing"))
+
+	assert.Equal(t, "NOT_STORED", c.line("add k1 0 0 2", "v2"))
+	assert.Equal(t, "STORED", c.line("add k2 0 0 2", "v2"))
+	assert.Equal(t, "NOT_STORED", c.line("replace k3 0 0 2", "v3"))
+	assert.Equal(t, "STORED", c.line("replace k2 0 60 3", "v22"))
+	assert.InDelta(t, time.Now().Add(time.Minute).UnixNano(), kv.items["k2"].ExpiresAt, float64(time.Second))
+
+	revision := strconv.FormatUint(kv.items["k2"].Revision, 10)
+	assert.Equal(t, []string{"VALUE k2 0 3 " + revision, "v22", "END"}, c.do("END", "gets k2"))
+	assert.Equal(t, "EXISTS", c.line("cas k2 0 0 2 1", "v4"))
+	assert.Equal(t, "STORED", c.line("cas k2 0 0 2 "+revision, "v4"))
+	assert.Equal(t, "NOT_FOUND", c.line("cas k3 0 0 2 "+revision, "v4"))
+	assert.Zero(t, kv.items["k2"].ExpiresAt)
+
+	// Replies to noreply commands are skipped
+	assert.Equal(t, "STORED", c.line("set k3 0 0 2 noreply", "v3", "set k4 0 0 2", "v4"))
+
+	assert.Equal(t, "TOUCHED", c.line("touch k1 100"))
+	assert.NotZero(t, kv.items["k1"].ExpiresAt)
+	assert.Equal(t, "NOT_FOUND", c.line("touch missing 100"))
+
+	assert.Equal(t, "DELETED", c.line("delete k1"))
+	assert.Equal(t, "NOT_FOUND", c.line("delete k1"))
+
+	assert.Equal(t, "CLIENT_ERROR bad data chunk", c.line("set k5 0 0 1", "toolong"))
+}
+
+// Test_MemcacheIncr tests incr and decr.
+func Test_MemcacheIncr(t *testing.T) {
+	kv := newTestStore()
+	_, c := newTestServer(t, kv, "")
+
+	assert.Equal(t, "NOT_FOUND", c.line("incr n 1"))
+	assert.Equal(t, "STORED", c.line("set n 0 0 2", "10"))
+	assert.Equal(t, "15", c.line("incr n 5"))
+	assert.Equal(t, "0", c.line("decr n 20"))
+	assert.Equal(t, "STORED", c.line("set n 0 0 20", "18446744073709551615"))
+	assert.Equal(t, "1", c.line("incr n 2"))
+
+	assert.Equal(t, "STORED", c.line("set s 0 0 3", "abc"))
+	assert.Equal(t, "CLIENT_ERROR cannot increment or decrement non-numeric value", c.line("incr s 1"))
+	assert.Equal(t, "CLIENT_ERROR invalid numeric delta argument", c.line("incr n -1"))
+}
+
+// Test_MemcacheErrors tests the replies to invalid commands, unauthenticated clients and writes on followers.
+func Test_MemcacheErrors(t *testing.T) {
+	kv := newTestStore()
+	_, c := newTestServer(t, kv, "secret")
+
+	assert.Equal(t, "CLIENT_ERROR unauthenticated", c.line("get k"))
+	assert.Equal(t, "CLIENT_ERROR authentication failure", c.line("set auth 0 0 11", "user wrong1"))
+	assert.Equal(t, "STORED", c.line("set auth 0 0 11", "user secret"))
+	assert.Empty(t, kv.items)
+
+	assert.Equal(t, "ERROR", c.line("flush_all"))
+	assert.Equal(t, "CLIENT_ERROR bad command line format", c.line("get "+strings.Repeat("k", maxKeyLen+1)))
+	assert.Equal(t, "SERVER_ERROR object too large for cache", c.line("set big 0 0 1048577", strings.Repeat("v", maxValueSize+1)))
+	// Values over the limit of the store
+	kv.limits = store.Limits{MaxValueSize: 4}
+	assert.Equal(t, "SERVER_ERROR object too large for cache", c.line("set big 0 0 5", "12345"))
+	kv.limits = store.Limits{}
+
+	kv.leader = false
+	assert.Equal(t, "SERVER_ERROR not leader, leader is node2 at localhost:11002", c.line("set k 0 0 1", "v"))
+	assert.Equal(t, "VERSION kvdb", c.line("version"))
+}
@@ -0,0 +1,487 @@
+package resp
+
+import (
+	"cmp"
+	"errors"
+	"github.com/naveen246/kvdb/store"
+	"hash/fnv"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCASRetries bounds the attempts of commands reading a key and then writing it only if it did not change
+const maxCASRetries = 100
+
+// Default number of keys returned by SCAN
+const defaultScanCount = 10
+
+var errContention = errors.New("too many concurrent changes, try again")
+
+// command is a command served by the server
+type command struct {
+	// arity is the number of arguments including the command name, negative for at least -arity arguments
+	arity int
+	// noAuth commands are allowed before the client is authenticated
+	noAuth bool
+	// firstKey, lastKey and keyStep locate the keys in the arguments including the command name, as in the
+	// key specs of Redis. A negative lastKey counts from the last argument, a zero firstKey means no keys.
+	firstKey, lastKey, keyStep int
+	run                        func(s *Server, c *conn, args []string)
+}
+
+// keys returns the keys in the arguments of the command, including the command name
+func (cmd command) keys(args []string) []string {
+	if cmd.firstKey == 0 {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last += len(args)
+	}
+	var keys []string
+	for i := cmd.firstKey; i <= last && i < len(args); i += cmd.keyStep {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+var commands = map[string]command{
+	"PING":    {arity: -1, run: (*Server).ping},
+	"ECHO":    {arity: 2, run: (*Server).echo},
+	"HELLO":   {arity: -1, noAuth: true, run: (*Server).hello},
+	"AUTH":    {arity: -2, noAuth: true, run: (*Server).auth},
+	"SELECT":  {arity: 2, run: (*Server).selectDB},
+	"QUIT":    {arity: -1, noAuth: true, run: (*Server).quit},
+	"COMMAND": {arity: -1, run: (*Server).command},
+	"CLIENT":  {arity: -2, run: (*Server).client},
+	"GET":     {arity: 2, firstKey: 1, lastKey: 1, keyStep: 1, run: (*Server).get},
+	"SET":     {arity: -3, firstKey: 1, lastKey: 1, keyStep: 1, run: (*Server).set},
+	"DEL":     {arity: -2, firstKey: 1, lastKey: -1, keyStep: 1, run: (*Server).del},
+	"EXISTS":  {arity: -2, firstKey: 1, lastKey: -1, keyStep: 1, run: (*Server).exists},
+	"KEYS":    {arity: 2, run: (*Server).keys},
+	"SCAN":    {arity: -2, run: (*Server).scan},
+	"MGET":    {arity: -2, firstKey: 1, lastKey: -1, keyStep: 1, run: (*Server).mget},
+	"MSET":    {arity: -3, firstKey: 1, lastKey: -1, keyStep: 2, run: (*Server).mset},
+	"INCR":    {arity: 2, firstKey: 1, lastKey: 1, keyStep: 1, run: (*Server).incr},
+}
+
+// ************** Connection commands *********************************//
+
+func (s *Server) ping(c *conn, args []string) {
+	switch len(args) {
+	case 0:
+		c.w.simple("PONG")
+	case 1:
+		c.w.bulk(args[0])
+	default:
+		c.w.error("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func (s *Server) echo(c *conn, args []string) {
+	c.w.bulk(args[0])
+}
+
+// hello switches the protocol version and authenticates the client, HELLO [protover [AUTH username password]
+// [SETNAME clientname]]
+func (s *Server) hello(c *conn, args []string) {
+	proto := c.w.proto
+	if len(args) > 0 {
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			c.w.error("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if version != 2 && version != 3 {
+			c.w.error("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = version
+	}
+
+	for i := 1; i < len(args); i++ {
+		switch {
+		case strings.EqualFold(args[i], "AUTH") && i+2 < len(args):
+			if !s.authenticate(c, args[i+2]) {
+				c.w.error("WRONGPASS invalid username-password pair or user is disabled.")
+				return
+			}
+			i += 2
+		case strings.EqualFold(args[i], "SETNAME") && i+1 < len(args):
+			i++
+		default:
+			c.w.error("ERR syntax error in HELLO option '" + args[i] + "'")
+			return
+		}
+	}
+	if !c.authed {
+		c.w.error("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used")
+		return
+	}
+
+	role := "replica"
+	if s.store.Leader().NodeID == s.store.LocalNode().NodeID {
+		role = "master"
+	}
+	c.w.proto = proto
+	c.w.dict(7)
+	c.w.bulk("server")
+	c.w.bulk("kvdb")
+	c.w.bulk("version")
+	c.w.bulk("0.0.0")
+	c.w.bulk("proto")
+	c.w.integer(int64(proto))
+	c.w.bulk("id")
+	c.w.integer(c.id)
+	c.w.bulk("mode")
+	c.w.bulk("standalone")
+	c.w.bulk("role")
+	c.w.bulk(role)
+	c.w.bulk("modules")
+	c.w.array(0)
+}
+
+// auth authenticates the client, AUTH [username] password
+func (s *Server) auth(c *conn, args []string) {
+	if len(args) > 2 {
+		c.w.error("ERR syntax error")
+		return
+	}
+	if s.AuthToken == "" {
+		c.w.error("ERR AUTH called without any password configured")
+		return
+	}
+	if !s.authenticate(c, args[len(args)-1]) {
+		c.w.error("WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	c.w.simple("OK")
+}
+
+// selectDB selects the database, only database 0 exists
+func (s *Server) selectDB(c *conn, args []string) {
+	if args[0] != "0" {
+		c.w.error("ERR DB index is out of range")
+		return
+	}
+	c.w.simple("OK")
+}
+
+func (s *Server) quit(c *conn, _ []string) {
+	c.w.simple("OK")
+	c.quit = true
+}
+
+// command answers the introspection of clients with an empty list, command details are not available
+func (s *Server) command(c *conn, _ []string) {
+	c.w.array(0)
+}
+
+func (s *Server) client(c *conn, args []string) {
+	switch strings.ToUpper(args[0]) {
+	case "ID":
+		c.w.integer(c.id)
+	case "SETNAME", "SETINFO":
+		c.w.simple("OK")
+	default:
+		c.w.error("ERR unknown subcommand '" + args[0] + "'")
+	}
+}
+
+// ************** Key-value commands *********************************//
+
+func (s *Server) get(c *conn, args []string) {
+	value, ok := s.store.Get(args[0])
+	if !ok {
+		c.w.null()
+		return
+	}
+	c.w.bulk(value)
+}
+
+// set sets a key, SET key value [NX | XX] [GET] [EX seconds | PX milliseconds | KEEPTTL]
+func (s *Server) set(c *conn, args []string) {
+	key, value := args[0], args[1]
+	var nx, xx, get, keepTTL bool
+	var ttl time.Duration
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); {
+		case opt == "NX" && !xx:
+			nx = true
+		case opt == "XX" && !nx:
+			xx = true
+		case opt == "GET":
+			get = true
+		case opt == "KEEPTTL" && ttl == 0:
+			keepTTL = true
+		case (opt == "EX" || opt == "PX") && ttl == 0 && !keepTTL && i+1 < len(args):
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				c.w.error("ERR value is not an integer or out of range")
+				return
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				c.w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * unit
+			i++
+		default:
+			c.w.error("ERR syntax error")
+			return
+		}
+	}
+
+	op := store.TxnOp{Op: store.CmdSet, Key: key, Value: value, KeepTTL: keepTTL}
+	if ttl > 0 {
+		op.ExpiresAt = time.Now().Add(ttl).UnixNano()
+	}
+	if !nx && !xx && !get {
+		_, err := s.store.Txn(store.Txn{Success: []store.TxnOp{op}})
+		if err != nil {
+			s.writeError(c, err)
+			return
+		}
+		c.w.simple("OK")
+		return
+	}
+
+	// The key is set only if it did not change since it was read, which also checks the conditions on the leader
+	for attempt := 0; attempt < maxCASRetries; attempt++ {
+		old, exists := s.store.Get(key)
+		txn := store.Txn{Compares: []store.Compare{{Key: key, Value: old, Exists: exists}}}
+		apply := !(nx && exists) && !(xx && !exists)
+		if apply {
+			txn.Success = []store.TxnOp{op}
+		}
+
+		ok, err := s.store.Txn(txn)
+		if err != nil {
+			s.writeError(c, err)
+			return
+		}
+		if !ok {
+			continue
+		}
+
+		switch {
+		case get && exists:
+			c.w.bulk(old)
+		case get || !apply:
+			c.w.null()
+		default:
+			c.w.simple("OK")
+		}
+		return
+	}
+	s.writeError(c, errContention)
+}
+
+// del deletes the keys and returns the number of keys that were set
+func (s *Server) del(c *conn, args []string) {
+	keys := slices.Clone(args)
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	for attempt := 0; attempt < maxCASRetries; attempt++ {
+		txn := store.Txn{}
+		deleted := 0
+		for _, key := range keys {
+			value, exists := s.store.Get(key)
+			txn.Compares = append(txn.Compares, store.Compare{Key: key, Value: value, Exists: exists})
+			if exists {
+				txn.Success = append(txn.Success, store.TxnOp{Op: store.CmdDelete, Key: key})
+				deleted++
+			}
+		}
+
+		ok, err := s.store.Txn(txn)
+		if err != nil {
+			s.writeError(c, err)
+			return
+		}
+		if ok {
+			c.w.integer(int64(deleted))
+			return
+		}
+	}
+	s.writeError(c, errContention)
+}
+
+// exists returns the number of the keys that are set, counting repeated keys as many times
+func (s *Server) exists(c *conn, args []string) {
+	count := 0
+	for _, key := range args {
+		if _, ok := s.store.Get(key); ok {
+			count++
+		}
+	}
+	c.w.integer(int64(count))
+}
+
+// keys returns the keys matching a glob-style pattern
+func (s *Server) keys(c *conn, args []string) {
+	var keys []string
+	for _, key := range s.store.Keys() {
+		if match(args[0], key) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	c.w.array(len(keys))
+	for _, key := range keys {
+		c.w.bulk(key)
+	}
+}
+
+// scan iterates over the keys, SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]. Keys are visited in
+// the order of their 32-bit FNV-1a hash, the cursor being the hash to continue from. As in Redis, a full
+// iteration returns every key set during the whole iteration, keys may be returned more than once.
+func (s *Server) scan(c *conn, args []string) {
+	cursor, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		c.w.error("ERR invalid cursor")
+		return
+	}
+
+	pattern, count, stringType := "*", defaultScanCount, true
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			c.w.error("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern = args[i+1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[i+1])
+			if err != nil {
+				c.w.error("ERR value is not an integer or out of range")
+				return
+			}
+			if count < 1 {
+				c.w.error("ERR syntax error")
+				return
+			}
+		case "TYPE":
+			stringType = strings.EqualFold(args[i+1], "string")
+		default:
+			c.w.error("ERR syntax error")
+			return
+		}
+	}
+
+	type hashedKey struct {
+		hash uint32
+		key  string
+	}
+	var pending []hashedKey
+	for _, key := range s.store.Keys() {
+		if h := keyHash(key); uint64(h) >= cursor {
+			pending = append(pending, hashedKey{h, key})
+		}
+	}
+	slices.SortFunc(pending, func(a, b hashedKey) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.key, b.key))
+	})
+
+	// Keys with the same hash are returned together, since the cursor can't point between them
+	n := min(count, len(pending))
+	for n < len(pending) && pending[n].hash == pending[n-1].hash {
+		n++
+	}
+	next := uint64(0)
+	if n < len(pending) {
+		next = uint64(pending[n-1].hash) + 1
+	}
+
+	var keys []string
+	for _, k := range pending[:n] {
+		if stringType && match(pattern, k.key) {
+			keys = append(keys, k.key)
+		}
+	}
+
+	c.w.array(2)
+	c.w.bulk(strconv.FormatUint(next, 10))
+	c.w.array(len(keys))
+	for _, key := range keys {
+		c.w.bulk(key)
+	}
+}
+
+func keyHash(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+func (s *Server) mget(c *conn, args []string) {
+	c.w.array(len(args))
+	for _, key := range args {
+		s.get(c, []string{key})
+	}
+}
+
+// mset sets the keys atomically, MSET key value [key value ...]
+func (s *Server) mset(c *conn, args []string) {
+	if len(args)%2 != 0 {
+		c.w.error("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+
+	txn := store.Txn{}
+	for i := 0; i < len(args); i += 2 {
+		txn.Success = append(txn.Success, store.TxnOp{Op: store.CmdSet, Key: args[i], Value: args[i+1]})
+	}
+	_, err := s.store.Txn(txn)
+	if err != nil {
+		s.writeError(c, err)
+		return
+	}
+	c.w.simple("OK")
+}
+
+// incr increments the integer value of a key, a key that is not set counts as 0. The key keeps its time to live.
+func (s *Server) incr(c *conn, args []string) {
+	key := args[0]
+	for attempt := 0; attempt < maxCASRetries; attempt++ {
+		old, exists := s.store.Get(key)
+		var n int64
+		if exists {
+			var err error
+			n, err = strconv.ParseInt(old, 10, 64)
+			if err != nil {
+				c.w.error("ERR value is not an integer or out of range")
+				return
+			}
+		}
+		if n == math.MaxInt64 {
+			c.w.error("ERR increment or decrement would overflow")
+			return
+		}
+		n++
+
+		ok, err := s.store.Txn(store.Txn{
+			Compares: []store.Compare{{Key: key, Value: old, Exists: exists}},
+			Success:  []store.TxnOp{{Op: store.CmdSet, Key: key, Value: strconv.FormatInt(n, 10), KeepTTL: true}},
+		})
+		if err != nil {
+			s.writeError(c, err)
+			return
+		}
+		if ok {
+			c.w.integer(n)
+			return
+		}
+	}
+	s.writeError(c, errContention)
+}
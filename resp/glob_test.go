@@ -0,0 +1,34 @@
+package resp
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Test_Match tests glob-style pattern matching.
+func Test_Match(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		s       string
+		matched bool
+	}{
+		{"*", "", true},
+		{"a/*", "a/b/c", true},
+		{"a/*", "b/a", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "heeeello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbx", false},
+	} {
+		assert.Equal(t, tt.matched, match(tt.pattern, tt.s), "%q %q", tt.pattern, tt.s)
+	}
+}
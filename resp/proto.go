@@ -0,0 +1,128 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Bounds of the requests accepted from clients, as in Redis
+const (
+	maxArgs           = 1024 * 1024
+	defaultMaxBulkLen = 512 * 1024 * 1024
+	maxInlineLen      = 64 * 1024
+)
+
+// errProtocol is returned for malformed requests, after which the connection is closed
+var errProtocol = errors.New("Protocol error")
+
+// readCommand reads a command, sent as an array of bulk strings or as an inline command
+// separated by spaces. It returns an empty command for empty inline lines. Bulk strings longer than
+// maxBulkLen are refused before they are read.
+func readCommand(r *bufio.Reader, maxBulkLen int) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+
+	args := make([]string, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", errProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line terminated by LF or CRLF, without its terminator
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := r.ReadLine()
+		if err != nil {
+			return "", err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxInlineLen {
+			return "", fmt.Errorf("%w: too big inline request", errProtocol)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// writer writes replies in the protocol version negotiated by the client, 2 unless changed by HELLO
+type writer struct {
+	*bufio.Writer
+	proto int
+}
+
+func (w *writer) simple(s string) {
+	w.WriteString("+" + s + "\r\n")
+}
+
+// error writes an error reply, msg starts with an error code such as ERR
+func (w *writer) error(msg string) {
+	w.WriteString("-" + msg + "\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (w *writer) bulk(s string) {
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (w *writer) null() {
+	if w.proto == 3 {
+		w.WriteString("_\r\n")
+		return
+	}
+	w.WriteString("$-1\r\n")
+}
+
+// array writes the header of an array of n elements, which are written next
+func (w *writer) array(n int) {
+	w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
+
+// dict writes the header of a map of n key-value pairs, which are written next. RESP2 has no maps,
+// they are written as arrays of alternating keys and values.
+func (w *writer) dict(n int) {
+	if w.proto == 3 {
+		w.WriteString("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	w.array(2 * n)
+}
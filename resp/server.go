@@ -0,0 +1,279 @@
+// Package resp serves the key-value store over the Redis serialization protocol, RESP2 and RESP3, so that
+// Redis clients and tools can talk to kvdb.
+//
+// Strings are the only data type, mapped one to one to keys. Reads are served from the local store, writes
+// are replicated through raft and fail with a READONLY error on followers, naming the leader to send them to.
+// There is a single database, 0.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/naveen246/kvdb/store"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store is the key-value store served by the Server
+type Store interface {
+	// Get returns the value of key and whether it is set
+	Get(key string) (string, bool)
+
+	// Keys returns the keys set in the store
+	Keys() []string
+
+	// Txn applies txn, via distributed consensus, and reports whether its compares succeeded
+	Txn(txn store.Txn) (bool, error)
+
+	// Leader returns the leader of the cluster
+	Leader() store.Node
+
+	// LocalNode returns the node serving the store
+	LocalNode() store.Node
+}
+
+// Server serves the RESP protocol over TCP
+type Server struct {
+	addr  string
+	store Store
+	ln    net.Listener
+
+	mu      sync.Mutex
+	conns   map[net.Conn]struct{}
+	nextID  int64
+	closing bool
+	wg      sync.WaitGroup
+
+	// CertFile and KeyFile enable TLS when both are set
+	CertFile string
+	KeyFile  string
+
+	// AuthToken, when set, must be sent by clients with AUTH or HELLO before other commands. Any username
+	// is accepted.
+	AuthToken string
+
+	// MaxBulkLen is the maximum length of the bulk strings of requests, 512MB as in Redis by default.
+	// Set it to the largest key or value the store accepts so that larger ones are refused before they
+	// are read.
+	MaxBulkLen int
+}
+
+// New returns an uninitialized RESP server
+func New(addr string, store Store) *Server {
+	return &Server{
+		addr:       addr,
+		store:      store,
+		conns:      make(map[net.Conn]struct{}),
+		MaxBulkLen: defaultMaxBulkLen,
+	}
+}
+
+// Start starts the server. The listener is bound before Start returns, connections are served in the background.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	if s.CertFile != "" && s.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	s.ln = ln
+	go s.serve()
+	return nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+func (s *Server) serve() {
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("RESP accept: %s", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		s.nextID++
+		c := newConn(nc, s.nextID, s.AuthToken == "")
+		s.conns[nc] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(c)
+
+			s.mu.Lock()
+			delete(s.conns, nc)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Shutdown stops accepting new connections and closes the open connections once their running command
+// has been answered. Connections still open when ctx is done are closed immediately.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.ln == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.closing = true
+	s.ln.Close()
+	// Connections waiting for a command stop reading, the others stop after their running command
+	for nc := range s.conns {
+		nc.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for nc := range s.conns {
+			nc.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// conn is a client connection
+type conn struct {
+	net.Conn
+	id     int64
+	r      *bufio.Reader
+	w      *writer
+	authed bool
+	quit   bool
+}
+
+func newConn(nc net.Conn, id int64, authed bool) *conn {
+	return &conn{
+		Conn:   nc,
+		id:     id,
+		r:      bufio.NewReader(nc),
+		w:      &writer{Writer: bufio.NewWriter(nc), proto: 2},
+		authed: authed,
+	}
+}
+
+// serveConn runs the commands sent on c until the client quits or the connection fails. Replies to
+// pipelined commands are flushed together.
+func (s *Server) serveConn(c *conn) {
+	defer c.Close()
+
+	for !c.quit {
+		args, err := readCommand(c.r, s.MaxBulkLen)
+		if errors.Is(err, errProtocol) {
+			c.w.error("ERR " + err.Error())
+			c.w.Flush()
+			return
+		}
+		if err != nil {
+			var netErr net.Error
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !(errors.As(err, &netErr) && netErr.Timeout()) {
+				log.Printf("RESP read from %s: %s", c.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		s.run(c, args)
+		if c.r.Buffered() == 0 || c.quit {
+			err = c.w.Flush()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// run runs the command args on c and writes its reply
+func (s *Server) run(c *conn, args []string) {
+	name := strings.ToUpper(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.error(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.error(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(name)))
+		return
+	}
+	if !c.authed && !cmd.noAuth {
+		c.w.error("NOAUTH Authentication required.")
+		return
+	}
+	// Keys with NUL bytes would reach into the namespaces of the HTTP API
+	for _, key := range cmd.keys(args) {
+		err := store.CheckKey(key)
+		if err != nil {
+			c.w.error("ERR " + err.Error())
+			return
+		}
+	}
+	cmd.run(s, c, args[1:])
+}
+
+// authenticate checks the password sent by the client against s.AuthToken
+func (s *Server) authenticate(c *conn, password string) bool {
+	c.authed = s.AuthToken == "" || subtle.ConstantTimeCompare([]byte(password), []byte(s.AuthToken)) == 1
+	return c.authed
+}
+
+// writeError writes the reply to a failed write, naming the leader and its Redis protocol address if the node is
+// not the leader
+func (s *Server) writeError(c *conn, err error) {
+	if !errors.Is(err, store.ErrNotLeader) && !errors.Is(err, raft.ErrNotLeader) && !errors.Is(err, raft.ErrLeadershipLost) &&
+		!errors.Is(err, raft.ErrLeadershipTransferInProgress) {
+		c.w.error("ERR " + err.Error())
+		return
+	}
+
+	leader := s.store.Leader()
+	if leader.NodeID == "" {
+		c.w.error("CLUSTERDOWN no leader elected")
+		return
+	}
+	msg := fmt.Sprintf("READONLY %s, leader is %s", err, leader.NodeID)
+	if leader.RESPAddr != "" {
+		msg += " at " + leader.RESPAddr
+	}
+	c.w.error(msg)
+}
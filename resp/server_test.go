@@ -0,0 +1,321 @@
+package resp
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/naveen246/kvdb/store"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testStore is a single node store applying transactions in memory
+type testStore struct {
+	mu     sync.Mutex
+	kv     map[string]string
+	txns   []store.Txn
+	leader bool
+	// err is returned by writes on followers, store.ErrNotLeader unless set
+	err error
+}
+
+func newTestStore() *testStore {
+	return &testStore{kv: make(map[string]string), leader: true}
+}
+
+func (t *testStore) Get(key string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	value, ok := t.kv[key]
+	return value, ok
+}
+
+func (t *testStore) Keys() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var keys []string
+	for key := range t.kv {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (t *testStore) Txn(txn store.Txn) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.leader {
+		return false, cmp.Or(t.err, store.ErrNotLeader)
+	}
+
+	t.txns = append(t.txns, txn)
+	ops, succeeded := txn.Success, true
+	for _, c := range txn.Compares {
+		value, ok := t.kv[c.Key]
+		if ok != c.Exists || value != c.Value {
+			ops, succeeded = txn.Failure, false
+			break
+		}
+	}
+	for _, op := range ops {
+		if op.Op == store.CmdSet {
+			t.kv[op.Key] = op.Value
+		} else {
+			delete(t.kv, op.Key)
+		}
+	}
+	return succeeded, nil
+}
+
+func (t *testStore) Leader() store.Node {
+	if t.leader {
+		return t.LocalNode()
+	}
+	return store.Node{NodeID: "node2", HTTPAddr: "localhost:11002", RESPAddr: "localhost:6380"}
+}
+
+func (t *testStore) LocalNode() store.Node {
+	return store.Node{NodeID: "node1", HTTPAddr: "localhost:11001"}
+}
+
+// testClient sends commands to a server and reads its replies
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// errorReply is an error reply
+type errorReply string
+
+func newTestServer(t *testing.T, kv Store, authToken string) (*Server, *testClient) {
+	s := New("localhost:0", kv)
+	s.AuthToken = authToken
+	assert.NoError(t, s.Start())
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return s, newTestClient(t, s)
+}
+
+func newTestClient(t *testing.T, s *Server) *testClient {
+	conn, err := net.Dial("tcp", s.Addr().String())
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends args as command and returns the reply
+func (c *testClient) do(args ...string) any {
+	c.send(args...)
+	return c.read()
+}
+
+func (c *testClient) send(args ...string) {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(c.conn, cmd)
+	assert.NoError(c.t, err)
+}
+
+// read reads a reply, as a string, int64, nil, errorReply or []any for arrays and maps
+func (c *testClient) read() any {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	if !assert.NoError(c.t, err) {
+		return nil
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return errorReply(line[1:])
+	case ':':
+		n, _ := strconv.ParseInt(line[1:], 10, 64)
+		return n
+	case '_':
+		return nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		io.ReadFull(c.r, buf)
+		return string(buf[:n])
+	case '*', '%':
+		n, _ := strconv.Atoi(line[1:])
+		if line[0] == '%' {
+			n *= 2
+		}
+		reply := []any{}
+		for i := 0; i < n; i++ {
+			reply = append(reply, c.read())
+		}
+		return reply
+	}
+	c.t.Fatalf("unexpected reply %q", line)
+	return nil
+}
+
+// Test_RESPCommands tests the key-value commands.
+func Test_RESPCommands(t *testing.T) {
+	kv := newTestStore()
+	_, c := newTestServer(t, kv, "")
+
+	assert.Equal(t, "PONG", c.do("PING"))
+	assert.Equal(t, "OK", c.do("SET", "k1", "v1"))
+	assert.Equal(t, "v1", c.do("get", "k1"))
+	assert.Nil(t, c.do("GET", "missing"))
+
+	assert.Nil(t, c.do("SET", "k1", "v2", "NX"))
+	assert.Equal(t, "v1", c.do("SET", "k1", "v2", "XX", "GET"))
+	assert.Nil(t, c.do("SET", "k2", "v2", "XX"))
+	assert.Equal(t, "OK", c.do("SET", "k2", "v2", "NX", "EX", "10"))
+	assert.InDelta(t, time.Now().Add(10*time.Second).UnixNano(), kv.txns[len(kv.txns)-1].Success[0].ExpiresAt, float64(time.Second))
+	assert.Equal(t, errorReply("ERR syntax error"), c.do("SET", "k2", "v2", "NX", "XX"))
+	assert.Equal(t, errorReply("ERR invalid expire time in 'set' command"), c.do("SET", "k2", "v2", "PX", "0"))
+
+	assert.Equal(t, "OK", c.do("MSET", "a/1", "1", "a/2", "2", "b", "3"))
+	assert.Equal(t, []any{"1", nil, "3"}, c.do("MGET", "a/1", "missing", "b"))
+	assert.Equal(t, int64(3), c.do("EXISTS", "a/1", "a/1", "missing", "b"))
+	assert.Equal(t, []any{"a/1", "a/2"}, c.do("KEYS", "a/*"))
+
+	assert.Equal(t, int64(3), c.do("INCR", "a/2"))
+	assert.Equal(t, int64(4), c.do("INCR", "a/2"))
+	assert.Equal(t, int64(1), c.do("INCR", "counter"))
+	assert.True(t, kv.txns[len(kv.txns)-1].Success[0].KeepTTL)
+	assert.Equal(t, errorReply("ERR value is not an integer or out of range"), c.do("INCR", "k1"))
+
+	assert.Equal(t, int64(2), c.do("DEL", "a/1", "a/1", "b", "missing"))
+	assert.Equal(t, int64(0), c.do("EXISTS", "a/1", "b"))
+
+	// Keys with NUL bytes would reach into the namespace tenant
+	invalid := errorReply(`ERR invalid key "tenant\x00k": keys must not contain NUL bytes`)
+	assert.Equal(t, invalid, c.do("SET", "tenant\x00k", "v"))
+	assert.Equal(t, invalid, c.do("MSET", "k3", "v\x00", "tenant\x00k", "v"))
+	assert.Equal(t, invalid, c.do("GET", "tenant\x00k"))
+	assert.NotContains(t, kv.kv, "k3")
+
+	assert.Equal(t, errorReply("ERR unknown command 'FLUSHALL'"), c.do("FLUSHALL"))
+	assert.Equal(t, errorReply("ERR wrong number of arguments for 'get' command"), c.do("GET"))
+}
+
+// Test_RESPScan tests that a full SCAN iteration returns every key.
+func Test_RESPScan(t *testing.T) {
+	kv := newTestStore()
+	_, c := newTestServer(t, kv, "")
+	for i := 0; i < 100; i++ {
+		kv.kv[fmt.Sprintf("key%d", i)] = "v"
+	}
+	kv.kv["other"] = "v"
+
+	var keys []string
+	cursor := "0"
+	for {
+		reply := c.do("SCAN", cursor, "MATCH", "key*", "COUNT", "7").([]any)
+		for _, key := range reply[1].([]any) {
+			keys = append(keys, key.(string))
+		}
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Len(t, keys, 100)
+	assert.NotContains(t, keys, "other")
+
+	reply := c.do("SCAN", "0", "COUNT", "1000", "TYPE", "hash").([]any)
+	assert.Equal(t, []any{"0", []any{}}, reply)
+}
+
+// Test_RESPProtocol tests protocol negotiation, inline and pipelined commands and authentication.
+func Test_RESPProtocol(t *testing.T) {
+	kv := newTestStore()
+	_, c := newTestServer(t, kv, "secret")
+
+	assert.Equal(t, errorReply("NOAUTH Authentication required."), c.do("GET", "k"))
+	assert.Equal(t, errorReply("WRONGPASS invalid username-password pair or user is disabled."), c.do("AUTH", "wrong"))
+
+	hello := c.do("HELLO", "3", "AUTH", "default", "secret").([]any)
+	assert.Equal(t, []any{"proto", int64(3)}, hello[4:6])
+	assert.Equal(t, []any{"role", "master"}, hello[10:12])
+	// RESP3 null
+	assert.Nil(t, c.do("GET", "k"))
+	assert.Equal(t, errorReply("NOPROTO unsupported protocol version"), c.do("HELLO", "4"))
+
+	_, err := io.WriteString(c.conn, "SET k inline\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "OK", c.read())
+
+	c.send("INCR", "n")
+	c.send("INCR", "n")
+	c.send("GET", "n")
+	assert.Equal(t, []any{int64(1), int64(2), "2"}, []any{c.read(), c.read(), c.read()})
+
+	assert.Equal(t, "OK", c.do("QUIT"))
+	_, err = c.r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// Test_RESPMaxBulkLen tests that bulk strings longer than MaxBulkLen are refused before they are read.
+func Test_RESPMaxBulkLen(t *testing.T) {
+	s := New("localhost:0", newTestStore())
+	s.MaxBulkLen = 8
+	assert.NoError(t, s.Start())
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	c := newTestClient(t, s)
+
+	assert.Equal(t, "OK", c.do("SET", "k", "12345678"))
+	_, err := io.WriteString(c.conn, "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1000000000\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, errorReply("ERR Protocol error: invalid bulk length"), c.read())
+	_, err = c.r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// Test_RESPNotLeader tests that writes on followers fail with the leader in the error.
+func Test_RESPNotLeader(t *testing.T) {
+	kv := newTestStore()
+	kv.kv["k"] = "v"
+	kv.leader = false
+	_, c := newTestServer(t, kv, "")
+
+	assert.Equal(t, "v", c.do("GET", "k"))
+	assert.Equal(t, errorReply("READONLY not leader, leader is node2 at localhost:6380"), c.do("SET", "k", "v2"))
+	assert.Equal(t, errorReply("READONLY not leader, leader is node2 at localhost:6380"), c.do("DEL", "k"))
+
+	// Leadership lost between the leader check and the raft apply
+	kv.mu.Lock()
+	kv.err = raft.ErrNotLeader
+	kv.mu.Unlock()
+	assert.Equal(t, errorReply("READONLY node is not the leader, leader is node2 at localhost:6380"), c.do("SET", "k", "v2"))
+	kv.mu.Lock()
+	kv.err = raft.ErrLeadershipLost
+	kv.mu.Unlock()
+	assert.Equal(t, errorReply("READONLY leadership lost while committing log, leader is node2 at localhost:6380"), c.do("SET", "k", "v2"))
+}
+
+// Test_RESPShutdown tests that shutdown closes idle connections.
+func Test_RESPShutdown(t *testing.T) {
+	s, c := newTestServer(t, newTestStore(), "")
+	assert.Equal(t, "PONG", c.do("PING"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, s.Shutdown(ctx))
+
+	_, err := c.r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = net.Dial("tcp", s.Addr().String())
+	assert.Error(t, err)
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/hashicorp/raft"
+	"github.com/naveen246/kvdb/store"
+	"net/http"
+	"net/url"
+)
+
+// Codes of the errors returned by the HTTP API, telling clients why a request failed
+const (
+	// CodeInvalid is returned with 400 Bad Request for malformed requests
+	CodeInvalid = "invalid"
+	// CodeUnauthorized is returned with 401 Unauthorized for requests without the auth token
+	CodeUnauthorized = "unauthorized"
+	// CodeNotFound is returned with 404 Not Found for keys that are not set, sessions and namespaces
+	// that don't exist and unknown routes
+	CodeNotFound = "not_found"
+	// CodeConflict is returned with 409 Conflict for writes that conflict with the current state of the store,
+	// such as increments of values that are not integers, locks held by another session or ops some node of the
+	// cluster can't apply yet
+	CodeConflict = "conflict"
+	// CodeQuotaExceeded is returned with 409 Conflict for writes that would take a namespace over its quota
+	CodeQuotaExceeded = "quota_exceeded"
+	// CodeTooLarge is returned with 413 Request Entity Too Large for requests over the Limits of the service
+	// or of the store
+	CodeTooLarge = "too_large"
+	// CodeNotLeader is returned for writes sent to a follower, with 421 Misdirected Request and the leader
+	// to send them to, or 503 Service Unavailable while the leader or its HTTP address is unknown
+	CodeNotLeader = "not_leader"
+	// CodeTimeout is returned with 503 Service Unavailable for writes that could not be replicated in time
+	CodeTimeout = "timeout"
+	// CodeUnknownOutcome is returned with 503 Service Unavailable for writes proposed by a leader that lost its
+	// leadership before they committed. They may still commit, so they are not safe to retry unless idempotent.
+	CodeUnknownOutcome = "unknown_outcome"
+	// CodeInternal is returned with 500 Internal Server Error for other failures
+	CodeInternal = "internal"
+)
+
+// ErrorResponse is the body of error responses
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+// Error describes why a request failed
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Leader is the leader of the cluster for not_leader errors, if known
+	Leader *store.Node `json:"leader,omitempty"`
+}
+
+// abortWithError aborts the request with an error response
+func abortWithError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, ErrorResponse{Error: Error{Code: code, Message: message}})
+}
+
+// abortWithStoreError aborts the request with the error response matching err, returned by the store
+func (s *Service) abortWithStoreError(c *gin.Context, err error) {
+	var limitErr *store.LimitError
+	switch {
+	case errors.Is(err, store.ErrNotLeader), errors.Is(err, raft.ErrNotLeader),
+		errors.Is(err, raft.ErrLeadershipTransferInProgress):
+		s.abortNotLeader(c, err)
+	case errors.Is(err, raft.ErrLeadershipLost):
+		abortWithError(c, http.StatusServiceUnavailable, CodeUnknownOutcome, err.Error())
+	case errors.Is(err, store.ErrInvalidNamespace), errors.Is(err, store.ErrInvalidKey):
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+	case errors.Is(err, store.ErrSessionNotFound), errors.Is(err, store.ErrNamespaceNotFound):
+		abortWithError(c, http.StatusNotFound, CodeNotFound, err.Error())
+	case errors.Is(err, store.ErrNotInteger), errors.Is(err, store.ErrOutOfRange), errors.Is(err, store.ErrLocked),
+		errors.Is(err, store.ErrNotLockHolder), errors.Is(err, store.ErrUnsupportedCommand):
+		abortWithError(c, http.StatusConflict, CodeConflict, err.Error())
+	case errors.As(err, &limitErr):
+		abortTooLarge(c, limitErr.Limit, limitErr.Message)
+	case errors.Is(err, store.ErrQuotaExceeded):
+		abortWithError(c, http.StatusConflict, CodeQuotaExceeded, err.Error())
+	case errors.Is(err, raft.ErrEnqueueTimeout):
+		abortWithError(c, http.StatusServiceUnavailable, CodeTimeout, err.Error())
+	default:
+		abortWithError(c, http.StatusInternalServerError, CodeInternal, err.Error())
+	}
+}
+
+// abortNotLeader aborts a request sent to a follower. If the HTTP address of the leader is known, it answers
+// 421 Misdirected Request naming the leader, with the same URL on the leader as Location. Otherwise it
+// answers 503 Service Unavailable.
+func (s *Service) abortNotLeader(c *gin.Context, err error) {
+	var leader store.Node
+	if s.raftHandler != nil {
+		leader = s.raftHandler.Leader()
+	}
+
+	body := ErrorResponse{Error: Error{Code: CodeNotLeader, Message: err.Error()}}
+	if leader.NodeID != "" {
+		body.Error.Leader = &leader
+	}
+	if leader.HTTPAddr == "" {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, body)
+		return
+	}
+
+	scheme := "http"
+	if s.CertFile != "" && s.KeyFile != "" {
+		scheme = "https"
+	}
+	location := url.URL{Scheme: scheme, Host: leader.HTTPAddr, Path: c.Request.URL.Path, RawQuery: c.Request.URL.RawQuery}
+	c.Header("Location", location.String())
+	c.AbortWithStatusJSON(http.StatusMisdirectedRequest, body)
+}
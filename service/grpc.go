@@ -0,0 +1,438 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/naveen246/kvdb/kvdbpb"
+	"github.com/naveen246/kvdb/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"log"
+	"net"
+	"slices"
+	"strings"
+)
+
+// Transactional is implemented by KV stores applying transactions atomically.
+type Transactional interface {
+	// Txn applies txn, via distributed consensus, and reports whether its compares succeeded.
+	Txn(txn store.Txn) (bool, error)
+}
+
+// Watchable is implemented by KV stores streaming their changes.
+type Watchable interface {
+	// Watch returns the changes to the keys starting with prefix until cancel is called.
+	Watch(prefix string) (events <-chan store.Event, cancel func())
+}
+
+// GRPCService provides the gRPC API. Txn and Watch are served if the KV store implements
+// Transactional and Watchable.
+type GRPCService struct {
+	addr        string
+	kv          KV
+	raftHandler RaftHandler
+	server      *grpc.Server
+
+	// CertFile and KeyFile enable TLS when both are set
+	CertFile string
+	KeyFile  string
+
+	// AuthToken, when set, must be sent by clients as "authorization: Bearer <token>" metadata
+	AuthToken string
+}
+
+// NewGRPC returns an uninitialized gRPC service.
+func NewGRPC(addr string, kv KV, raftHandler RaftHandler) *GRPCService {
+	return &GRPCService{
+		addr:        addr,
+		kv:          kv,
+		raftHandler: raftHandler,
+	}
+}
+
+// Start starts the service. The listener is bound before Start returns, calls are served in the background.
+func (s *GRPCService) Start() error {
+	server, err := s.newServer()
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	s.server = server
+	go func() {
+		err := s.server.Serve(ln)
+		if err != nil {
+			log.Fatalf("gRPC serve: %s", err)
+		}
+	}()
+
+	return nil
+}
+
+// newServer returns the gRPC server serving the API
+func (s *GRPCService) newServer() (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if s.CertFile != "" && s.KeyFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if s.AuthToken != "" {
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				if err := s.authenticate(ctx); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := s.authenticate(ss.Context()); err != nil {
+					return err
+				}
+				return handler(srv, ss)
+			}))
+	}
+
+	server := grpc.NewServer(opts...)
+	kvdbpb.RegisterKVServer(server, &grpcKV{service: s})
+	kvdbpb.RegisterClusterServer(server, &grpcCluster{service: s})
+	return server, nil
+}
+
+// Shutdown stops accepting new calls and waits for running calls to complete until ctx is done,
+// after which the remaining calls are canceled.
+func (s *GRPCService) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// authenticate rejects calls not carrying s.AuthToken as bearer token
+func (s *GRPCService) authenticate(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.AuthToken)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+// grpcError converts err to a gRPC status with the code matching the error response of the HTTP API, failing
+// calls sent to followers with codes.Unavailable
+func (s *GRPCService) grpcError(err error) error {
+	switch {
+	case errors.Is(err, store.ErrNotLeader), errors.Is(err, raft.ErrNotLeader),
+		errors.Is(err, raft.ErrLeadershipTransferInProgress):
+		msg := err.Error()
+		if s.raftHandler != nil {
+			if leader := s.raftHandler.Leader(); leader.NodeID != "" {
+				msg = fmt.Sprintf("%s, leader is %s at %s", msg, leader.NodeID, leader.HTTPAddr)
+			}
+		}
+		return status.Error(codes.Unavailable, msg)
+	case errors.Is(err, raft.ErrLeadershipLost):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, store.ErrTooLarge), errors.Is(err, store.ErrInvalidNamespace), errors.Is(err, store.ErrInvalidKey):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, store.ErrSessionNotFound), errors.Is(err, store.ErrNamespaceNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, store.ErrNotInteger), errors.Is(err, store.ErrOutOfRange), errors.Is(err, store.ErrLocked),
+		errors.Is(err, store.ErrNotLockHolder), errors.Is(err, store.ErrUnsupportedCommand):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, store.ErrQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, raft.ErrEnqueueTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// ************** KV Service *********************************//
+
+type grpcKV struct {
+	kvdbpb.UnimplementedKVServer
+	service *GRPCService
+}
+
+// Get fails with codes.NotFound if the key is not set
+func (g *grpcKV) Get(_ context.Context, req *kvdbpb.GetRequest) (*kvdbpb.GetResponse, error) {
+	err := checkKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := g.service.kv.Get(req.Key)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "key %s not found", req.Key)
+	}
+	return &kvdbpb.GetResponse{Value: value}, nil
+}
+
+func (g *grpcKV) Put(_ context.Context, req *kvdbpb.PutRequest) (*kvdbpb.PutResponse, error) {
+	err := checkKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	err = g.service.kv.Set(req.Key, req.Value)
+	if err != nil {
+		return nil, g.service.grpcError(err)
+	}
+	return &kvdbpb.PutResponse{}, nil
+}
+
+func (g *grpcKV) Delete(_ context.Context, req *kvdbpb.DeleteRequest) (*kvdbpb.DeleteResponse, error) {
+	err := checkKey(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	err = g.service.kv.Delete(req.Key)
+	if err != nil {
+		return nil, g.service.grpcError(err)
+	}
+	return &kvdbpb.DeleteResponse{}, nil
+}
+
+// Range reads the matching keys one by one, concurrent writes may be partially visible
+func (g *grpcKV) Range(_ context.Context, req *kvdbpb.RangeRequest) (*kvdbpb.RangeResponse, error) {
+	if req.Prefix != "" && (req.Start != "" || req.End != "") {
+		return nil, status.Error(codes.InvalidArgument, "prefix can't be combined with start or end")
+	}
+
+	keys := g.service.kv.Keys()
+	slices.Sort(keys)
+
+	resp := &kvdbpb.RangeResponse{}
+	for _, key := range keys {
+		if !strings.HasPrefix(key, req.Prefix) || key < req.Start || (req.End != "" && key >= req.End) {
+			continue
+		}
+		if req.Limit > 0 && int64(len(resp.Kvs)) == req.Limit {
+			resp.More = true
+			break
+		}
+
+		kv := &kvdbpb.KeyValue{Key: key}
+		if !req.KeysOnly {
+			kv.Value, _ = g.service.kv.Get(key)
+		}
+		resp.Kvs = append(resp.Kvs, kv)
+	}
+	return resp, nil
+}
+
+func (g *grpcKV) Txn(_ context.Context, req *kvdbpb.TxnRequest) (*kvdbpb.TxnResponse, error) {
+	transactional, ok := g.service.kv.(Transactional)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "transactions are not supported")
+	}
+
+	txn := store.Txn{}
+	for _, c := range req.Compares {
+		err := checkKey(c.Key)
+		if err != nil {
+			return nil, err
+		}
+		txn.Compares = append(txn.Compares, store.Compare{Key: c.Key, Value: c.Value, Exists: c.Exists})
+	}
+	var err error
+	txn.Success, err = txnOps(req.Success)
+	if err != nil {
+		return nil, err
+	}
+	txn.Failure, err = txnOps(req.Failure)
+	if err != nil {
+		return nil, err
+	}
+
+	succeeded, err := transactional.Txn(txn)
+	if err != nil {
+		return nil, g.service.grpcError(err)
+	}
+	return &kvdbpb.TxnResponse{Succeeded: succeeded}, nil
+}
+
+func txnOps(ops []*kvdbpb.Op) ([]store.TxnOp, error) {
+	var result []store.TxnOp
+	for _, op := range ops {
+		switch o := op.Op.(type) {
+		case *kvdbpb.Op_Put:
+			err := checkKey(o.Put.Key)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, store.TxnOp{Op: store.CmdSet, Key: o.Put.Key, Value: o.Put.Value})
+		case *kvdbpb.Op_Delete:
+			err := checkKey(o.Delete.Key)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, store.TxnOp{Op: store.CmdDelete, Key: o.Delete.Key})
+		default:
+			return nil, status.Error(codes.InvalidArgument, "transaction op must be put or delete")
+		}
+	}
+	return result, nil
+}
+
+func (g *grpcKV) Watch(req *kvdbpb.WatchRequest, stream kvdbpb.KV_WatchServer) error {
+	watchable, ok := g.service.kv.(Watchable)
+	if !ok {
+		return status.Error(codes.Unimplemented, "watches are not supported")
+	}
+
+	err := checkKey(req.Prefix)
+	if err != nil {
+		return err
+	}
+	events, cancel := watchable.Watch(req.Prefix)
+	defer cancel()
+
+	// Headers tell the client that the changes are now watched
+	err = stream.SendHeader(nil)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return status.Error(codes.Aborted, "watcher fell behind, read the keys again and restart the watch")
+			}
+
+			// Send the events already buffered along with the first one. The keys of other namespaces start
+			// with the prefix too, they are not sent.
+			resp := &kvdbpb.WatchResponse{}
+			for buffered := len(events); ok; buffered-- {
+				if ns, _ := store.SplitKey(event.Key); ns == store.DefaultNamespace {
+					resp.Events = append(resp.Events, watchEvent(event))
+				}
+				if buffered == 0 {
+					break
+				}
+				event, ok = <-events
+			}
+			if len(resp.Events) == 0 {
+				continue
+			}
+
+			err := stream.Send(resp)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkKey fails with codes.InvalidArgument if key is rejected by store.CheckKey, as it would reach into
+// another namespace
+func checkKey(key string) error {
+	err := store.CheckKey(key)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+func watchEvent(event store.Event) *kvdbpb.Event {
+	e := &kvdbpb.Event{
+		Kv:    &kvdbpb.KeyValue{Key: event.Key, Value: event.Value},
+		Index: event.Index,
+	}
+	if event.Type == store.CmdDelete {
+		e.Type = kvdbpb.Event_DELETE
+	}
+	return e
+}
+
+// ************************ Cluster service *************************//
+
+type grpcCluster struct {
+	kvdbpb.UnimplementedClusterServer
+	service *GRPCService
+}
+
+func (g *grpcCluster) Join(_ context.Context, req *kvdbpb.JoinRequest) (*kvdbpb.JoinResponse, error) {
+	if req.NodeId == "" || req.RaftAddr == "" {
+		return nil, status.Error(codes.InvalidArgument, "node_id and raft_addr are required")
+	}
+
+	err := g.service.raftHandler.AddNode(req.NodeId, req.RaftAddr)
+	if err != nil {
+		return nil, g.service.grpcError(err)
+	}
+	if req.HttpAddr != "" {
+		err = g.service.raftHandler.SetNodeMeta(req.NodeId, store.NodeMeta{HTTPAddr: req.HttpAddr})
+		if err != nil {
+			return nil, g.service.grpcError(err)
+		}
+	}
+	return &kvdbpb.JoinResponse{}, nil
+}
+
+func (g *grpcCluster) Leader(context.Context, *kvdbpb.LeaderRequest) (*kvdbpb.Node, error) {
+	return grpcNode(g.service.raftHandler.Leader()), nil
+}
+
+func (g *grpcCluster) Servers(context.Context, *kvdbpb.ServersRequest) (*kvdbpb.ServersResponse, error) {
+	nodes, err := g.service.raftHandler.NodeList()
+	if err != nil {
+		return nil, g.service.grpcError(err)
+	}
+
+	resp := &kvdbpb.ServersResponse{}
+	for _, node := range nodes {
+		resp.Servers = append(resp.Servers, grpcNode(node))
+	}
+	return resp, nil
+}
+
+func (g *grpcCluster) Snapshot(context.Context, *kvdbpb.SnapshotRequest) (*kvdbpb.SnapshotResponse, error) {
+	err := g.service.raftHandler.Snapshot()
+	if err != nil {
+		return nil, g.service.grpcError(err)
+	}
+	return &kvdbpb.SnapshotResponse{}, nil
+}
+
+func (g *grpcCluster) Compact(context.Context, *kvdbpb.CompactRequest) (*kvdbpb.CompactResponse, error) {
+	err := g.service.raftHandler.Compact()
+	if err != nil {
+		return nil, g.service.grpcError(err)
+	}
+	return &kvdbpb.CompactResponse{}, nil
+}
+
+func grpcNode(node store.Node) *kvdbpb.Node {
+	return &kvdbpb.Node{NodeId: node.NodeID, RaftAddr: node.RaftAddr, HttpAddr: node.HTTPAddr}
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/naveen246/kvdb/kvdbpb"
+	"github.com/naveen246/kvdb/store"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+)
+
+// newTestGRPC serves svc over an in-memory connection and returns a client connection to it
+func newTestGRPC(t *testing.T, svc *GRPCService) *grpc.ClientConn {
+	server, err := svc.newServer()
+	assert.NoError(t, err)
+
+	ln := bufconn.Listen(1 << 20)
+	go server.Serve(ln)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// Test_GRPCKV tests the key-value RPCs.
+func Test_GRPCKV(t *testing.T) {
+	kv := newTestTxnStore()
+	client := kvdbpb.NewKVClient(newTestGRPC(t, NewGRPC("", kv, nil)))
+	ctx := context.Background()
+
+	for _, k := range []string{"b", "a/2", "a/1", "c"} {
+		_, err := client.Put(ctx, &kvdbpb.PutRequest{Key: k, Value: "v" + k})
+		assert.NoError(t, err)
+	}
+
+	resp, err := client.Get(ctx, &kvdbpb.GetRequest{Key: "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, "vb", resp.Value)
+
+	rangeResp, err := client.Range(ctx, &kvdbpb.RangeRequest{Prefix: "a/"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/1", "a/2"}, rangeKeys(rangeResp))
+	assert.Equal(t, "va/1", rangeResp.Kvs[0].Value)
+
+	rangeResp, err = client.Range(ctx, &kvdbpb.RangeRequest{Start: "a/2", End: "c", Limit: 1, KeysOnly: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a/2"}, rangeKeys(rangeResp))
+	assert.Empty(t, rangeResp.Kvs[0].Value)
+	assert.True(t, rangeResp.More)
+
+	_, err = client.Delete(ctx, &kvdbpb.DeleteRequest{Key: "b"})
+	assert.NoError(t, err)
+	_, err = client.Get(ctx, &kvdbpb.GetRequest{Key: "b"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	kv.err = store.ErrNotLeader
+	_, err = client.Put(ctx, &kvdbpb.PutRequest{Key: "k", Value: "v"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	kv.err = raft.ErrNotLeader
+	_, err = client.Put(ctx, &kvdbpb.PutRequest{Key: "k", Value: "v"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+// Test_GRPCErrors tests that store errors fail calls with the code matching the HTTP error response.
+func Test_GRPCErrors(t *testing.T) {
+	svc := NewGRPC("", newTestStore(), &testRaftHandler{httpAddrs: map[string]string{}})
+	for err, code := range map[error]codes.Code{
+		store.ErrNotLeader:                                   codes.Unavailable,
+		raft.ErrLeadershipLost:                               codes.Unavailable,
+		&store.LimitError{Limit: "key_length"}:               codes.InvalidArgument,
+		store.ErrInvalidKey:                                  codes.InvalidArgument,
+		fmt.Errorf("%w: tenant", store.ErrNamespaceNotFound): codes.NotFound,
+		store.ErrSessionNotFound:                             codes.NotFound,
+		store.ErrLocked:                                      codes.FailedPrecondition,
+		store.ErrNotLockHolder:                               codes.FailedPrecondition,
+		store.ErrNotInteger:                                  codes.FailedPrecondition,
+		store.ErrQuotaExceeded:                               codes.ResourceExhausted,
+		raft.ErrEnqueueTimeout:                               codes.DeadlineExceeded,
+		errors.New("disk full"):                              codes.Internal,
+	} {
+		assert.Equal(t, code, status.Code(svc.grpcError(err)), err.Error())
+	}
+}
+
+func rangeKeys(resp *kvdbpb.RangeResponse) []string {
+	var keys []string
+	for _, kv := range resp.Kvs {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+// Test_GRPCTxnWatch tests that transactions are applied and streamed to watchers.
+func Test_GRPCTxnWatch(t *testing.T) {
+	kv := newTestTxnStore()
+	client := kvdbpb.NewKVClient(newTestGRPC(t, NewGRPC("", kv, nil)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch, err := client.Watch(ctx, &kvdbpb.WatchRequest{Prefix: "a/"})
+	assert.NoError(t, err)
+	// The watch is registered once the stream headers are received
+	_, err = watch.Header()
+	assert.NoError(t, err)
+
+	txn := &kvdbpb.TxnRequest{
+		Compares: []*kvdbpb.Compare{{Key: "a/1"}},
+		Success: []*kvdbpb.Op{
+			{Op: &kvdbpb.Op_Put{Put: &kvdbpb.PutRequest{Key: "a/1", Value: "v1"}}},
+			{Op: &kvdbpb.Op_Delete{Delete: &kvdbpb.DeleteRequest{Key: "a/2"}}},
+		},
+	}
+	// Keys of other namespaces are not streamed to watchers
+	_, err = kv.Txn(store.Txn{Success: []store.TxnOp{{Op: store.CmdSet, Key: store.NamespacedKey("tenant", "a/3"), Value: "v3"}}})
+	assert.NoError(t, err)
+	kv.txns = nil
+	resp, err := client.Txn(ctx, txn)
+	assert.NoError(t, err)
+	assert.True(t, resp.Succeeded)
+	assert.Equal(t, store.Txn{
+		Compares: []store.Compare{{Key: "a/1"}},
+		Success:  []store.TxnOp{{Op: store.CmdSet, Key: "a/1", Value: "v1"}, {Op: store.CmdDelete, Key: "a/2"}},
+	}, kv.txns[0])
+
+	var events []*kvdbpb.Event
+	for len(events) < 2 {
+		watchResp, err := watch.Recv()
+		assert.NoError(t, err)
+		events = append(events, watchResp.Events...)
+	}
+	assert.Equal(t, kvdbpb.Event_PUT, events[0].Type)
+	assert.Equal(t, "a/1", events[0].Kv.Key)
+	assert.Equal(t, kvdbpb.Event_DELETE, events[1].Type)
+	assert.Equal(t, "a/2", events[1].Kv.Key)
+
+	_, err = client.Txn(ctx, &kvdbpb.TxnRequest{Success: []*kvdbpb.Op{{}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Txn(ctx, &kvdbpb.TxnRequest{Success: []*kvdbpb.Op{{Op: &kvdbpb.Op_Put{Put: &kvdbpb.PutRequest{Key: "tenant\x00k"}}}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Len(t, kv.txns, 1)
+
+	// Stores without transactions
+	client = kvdbpb.NewKVClient(newTestGRPC(t, NewGRPC("", newTestStore(), nil)))
+	_, err = client.Txn(ctx, txn)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// Test_GRPCCluster tests the cluster admin RPCs and authentication.
+func Test_GRPCCluster(t *testing.T) {
+	raftHandler := &testRaftHandler{leader: true, httpAddrs: map[string]string{}}
+	svc := NewGRPC("", newTestStore(), raftHandler)
+	svc.AuthToken = "secret"
+	client := kvdbpb.NewClusterClient(newTestGRPC(t, svc))
+
+	_, err := client.Leader(context.Background(), &kvdbpb.LeaderRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	_, err = client.Join(ctx, &kvdbpb.JoinRequest{NodeId: "node2", RaftAddr: "localhost:12002", HttpAddr: "localhost:11002"})
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:11002", raftHandler.httpAddrs["node2"])
+
+	_, err = client.Join(ctx, &kvdbpb.JoinRequest{NodeId: "node3"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	raftHandler.leader = false
+	raftHandler.httpAddrs["node1"] = "localhost:11001"
+	_, err = client.Join(ctx, &kvdbpb.JoinRequest{NodeId: "node3", RaftAddr: "localhost:12003"})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "leader is node1 at localhost:11001")
+
+	leader, err := client.Leader(ctx, &kvdbpb.LeaderRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", leader.NodeId)
+
+	servers, err := client.Servers(ctx, &kvdbpb.ServersRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, servers.Servers, 1)
+}
+
+// testTxnStore is a testStore applying transactions and streaming changes
+type testTxnStore struct {
+	*testStore
+	txns    []store.Txn
+	watches []chan store.Event
+}
+
+func newTestTxnStore() *testTxnStore {
+	return &testTxnStore{testStore: newTestStore()}
+}
+
+func (t *testTxnStore) Txn(txn store.Txn) (bool, error) {
+	t.txns = append(t.txns, txn)
+	for _, c := range txn.Compares {
+		value, ok := t.m[c.Key]
+		if ok != c.Exists || value != c.Value {
+			return false, nil
+		}
+	}
+	for _, op := range txn.Success {
+		for _, w := range t.watches {
+			w <- store.Event{Type: op.Op, Key: op.Key, Value: op.Value}
+		}
+	}
+	return true, nil
+}
+
+func (t *testTxnStore) Watch(string) (<-chan store.Event, func()) {
+	ch := make(chan store.Event, 10)
+	t.watches = append(t.watches, ch)
+	return ch, func() {}
+}
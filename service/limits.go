@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/armon/go-metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/naveen246/kvdb/store"
+	"net/http"
+)
+
+// DefaultLimits are the limits of a new Service
+var DefaultLimits = Limits{
+	Limits:      store.DefaultLimits,
+	MaxBodySize: 4 << 20,
+}
+
+// Limits bound the size of the requests accepted by the HTTP API. The writes are checked against the limits
+// of the store, with the ones of the store set to the same values, before any of their keys is written.
+// Requests over a limit are rejected with 413 Request Entity Too Large, and counted by the kvdb.http.rejected
+// metric labelled with the limit. Zero values don't limit.
+type Limits struct {
+	store.Limits
+	// MaxBodySize is the maximum size of a request body in bytes
+	MaxBodySize int64
+}
+
+// limitBody rejects requests whose body is larger than s.Limits.MaxBodySize. Bodies without Content-Length are
+// rejected once they are read past the limit, see abortWithBodyError.
+func (s *Service) limitBody(c *gin.Context) {
+	maxSize := s.Limits.MaxBodySize
+	if maxSize <= 0 {
+		c.Next()
+		return
+	}
+	if c.Request.ContentLength > maxSize {
+		abortTooLarge(c, "body_size", fmt.Sprintf("request body is larger than %d bytes", maxSize))
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize)
+	c.Next()
+}
+
+// checkBatch aborts the request and returns false if it sets more than s.Limits.MaxBatchSize keys
+func (s *Service) checkBatch(c *gin.Context, size int) bool {
+	return checkLimit(c, s.Limits.CheckBatch(size))
+}
+
+// checkKey aborts the request and returns false if key is invalid or longer than s.Limits.MaxKeyLength
+func (s *Service) checkKey(c *gin.Context, key string) bool {
+	return validKey(c, key) && checkLimit(c, s.Limits.CheckKey(key))
+}
+
+// checkKeyValue aborts the request and returns false if key is invalid or longer than s.Limits.MaxKeyLength,
+// or value longer than s.Limits.MaxValueSize
+func (s *Service) checkKeyValue(c *gin.Context, key, value string) bool {
+	return validKey(c, key) && checkLimit(c, s.Limits.CheckKeyValue(key, value))
+}
+
+// checkLimit aborts the request and returns false if err, returned by a check of store.Limits, is not nil
+func checkLimit(c *gin.Context, err error) bool {
+	var limitErr *store.LimitError
+	if errors.As(err, &limitErr) {
+		abortTooLarge(c, limitErr.Limit, limitErr.Message)
+		return false
+	}
+	return true
+}
+
+// abortWithBodyError aborts a request whose body could not be read, with 413 if it is larger than the limit
+func abortWithBodyError(c *gin.Context, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		abortTooLarge(c, "body_size", fmt.Sprintf("request body is larger than %d bytes", maxBytesErr.Limit))
+		return
+	}
+	abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+}
+
+// abortTooLarge aborts a request over limit with 413 Request Entity Too Large and counts it
+func abortTooLarge(c *gin.Context, limit, message string) {
+	metrics.IncrCounterWithLabels([]string{"kvdb", "http", "rejected"}, 1, []metrics.Label{{Name: "limit", Value: limit}})
+	abortWithError(c, http.StatusRequestEntityTooLarge, CodeTooLarge, message)
+}
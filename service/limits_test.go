@@ -0,0 +1,63 @@
+package service
+
+import (
+	"github.com/armon/go-metrics"
+	"github.com/naveen246/kvdb/store"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test_Limits tests that requests over the limits are rejected with 413 before reaching the store, and counted.
+func Test_Limits(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Minute, time.Minute)
+	conf := metrics.DefaultConfig("")
+	conf.EnableRuntimeMetrics = false
+	_, err := metrics.NewGlobal(conf, sink)
+	assert.NoError(t, err)
+
+	kv := newTestStore()
+	svc := New(DefaultHTTPAddr, kv, &testRaftHandler{})
+	svc.Limits = Limits{Limits: store.Limits{MaxKeyLength: 4, MaxValueSize: 8, MaxBatchSize: 2}, MaxBodySize: 64}
+	router := svc.router()
+	post := func(path string, body io.Reader) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, body))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusCreated, post("/v1/keys", strings.NewReader(`{"k1":"12345678","k2":"v2"}`)).Code)
+
+	rec := post("/v1/keys", strings.NewReader(`{"key12":"v1"}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"too_large","message":"key is longer than 4 bytes"}}`, rec.Body.String())
+
+	rec = post("/v1/keys", strings.NewReader(`{"k3":"v3","k4":"123456789"}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"too_large","message":"value of key k4 is larger than 8 bytes"}}`, rec.Body.String())
+
+	rec = post("/v1/keys", strings.NewReader(`{"k3":"v3","k4":"v4","k5":"v5"}`))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"too_large","message":"request sets 3 keys, more than 2"}}`, rec.Body.String())
+	assert.Len(t, kv.m, 2)
+
+	body := `{"k3":"` + strings.Repeat("v", 100) + `"}`
+	rec = post("/v1/keys", strings.NewReader(body))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"too_large","message":"request body is larger than 64 bytes"}}`, rec.Body.String())
+
+	// Bodies of unknown length are cut at the limit
+	rec = post("/v1/keys", io.MultiReader(strings.NewReader(body)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, post("/v1/keys/key12/incr", strings.NewReader(`{}`)).Code)
+
+	counters := sink.Data()[0].Counters
+	for limit, count := range map[string]int{"key_length": 2, "value_size": 1, "batch_size": 1, "body_size": 2} {
+		assert.Equal(t, count, counters["kvdb.http.rejected;limit="+limit].Count, limit)
+	}
+}
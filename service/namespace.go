@@ -0,0 +1,198 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/naveen246/kvdb/store"
+	"net/http"
+	"strings"
+)
+
+// Namespaces is implemented by KV stores keeping keys in namespaces with quotas. The KV methods address
+// the keys of a namespace by store.NamespacedKey.
+type Namespaces interface {
+	// SetNamespace creates the namespace or updates its quota, via distributed consensus.
+	SetNamespace(name string, quota store.Quota) error
+
+	// DeleteNamespace deletes the namespace and its keys, via distributed consensus.
+	DeleteNamespace(name string) error
+
+	// Namespace returns the namespace and whether it exists.
+	Namespace(name string) (store.NamespaceStats, bool)
+
+	// Namespaces returns the namespaces.
+	Namespaces() []store.NamespaceStats
+
+	// KeysIn returns the keys of the namespace.
+	KeysIn(ns string) []string
+}
+
+// quotaJSON is the quota of a namespace in the HTTP API, zero values don't limit
+type quotaJSON struct {
+	MaxKeys  int64 `json:"maxKeys"`
+	MaxBytes int64 `json:"maxBytes"`
+}
+
+// namespaceJSON is a namespace as returned by the HTTP API
+type namespaceJSON struct {
+	Name  string    `json:"name"`
+	Quota quotaJSON `json:"quota"`
+	Keys  int64     `json:"keys"`
+	Bytes int64     `json:"bytes"`
+}
+
+func newNamespaceJSON(stats store.NamespaceStats) namespaceJSON {
+	return namespaceJSON{
+		Name:  stats.Name,
+		Quota: quotaJSON{MaxKeys: stats.Quota.MaxKeys, MaxBytes: stats.Quota.MaxBytes},
+		Keys:  stats.Keys,
+		Bytes: stats.Bytes,
+	}
+}
+
+var (
+	quotaSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"maxKeys":  {Type: "integer", Description: "Maximum number of keys, 0 for no limit", Minimum: new(int64)},
+			"maxBytes": {Type: "integer", Description: "Maximum length of the keys and values, 0 for no limit", Minimum: new(int64)},
+		},
+	}
+
+	namespaceSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"name":  stringSchema,
+			"quota": quotaSchema,
+			"keys":  {Type: "integer", Description: "Number of keys"},
+			"bytes": {Type: "integer", Description: "Length of the keys and values"},
+		},
+	}
+)
+
+// namespaceRoutes returns the operations of the HTTP API on namespaces, and the key, transaction and watch
+// routes of keyRoutes on the keys of a namespace
+func (s *Service) namespaceRoutes(keyRoutes []route) []route {
+	routes := []route{
+		// curl localhost:11001/v1/namespaces
+		{
+			method: http.MethodGet, path: "/namespaces", operationID: "listNamespaces", summary: "List the namespaces",
+			responses: map[int]response{http.StatusOK: {description: "The namespaces", schema: &Schema{Type: "array", Items: namespaceSchema}}},
+			handler:   s.GetNamespaces,
+		},
+		// curl localhost:11001/v1/namespaces/tenant1
+		{
+			method: http.MethodGet, path: "/namespaces/:ns", operationID: "getNamespace",
+			summary:   "Get the quota and usage of a namespace",
+			responses: map[int]response{http.StatusOK: {description: "The namespace", schema: namespaceSchema}},
+			handler:   s.GetNamespace,
+		},
+		// curl -X PUT localhost:11001/v1/namespaces/tenant1 -d '{"maxKeys":1000,"maxBytes":1048576}'
+		{
+			method: http.MethodPut, path: "/namespaces/:ns", operationID: "setNamespace",
+			summary:   "Create a namespace or update its quota",
+			body:      quotaSchema,
+			responses: map[int]response{http.StatusOK: {description: "The namespace", schema: namespaceSchema}},
+			handler:   s.SetNamespace,
+		},
+		// curl -X DELETE localhost:11001/v1/namespaces/tenant1
+		{
+			method: http.MethodDelete, path: "/namespaces/:ns", operationID: "deleteNamespace",
+			summary:   "Delete a namespace and its keys",
+			responses: map[int]response{http.StatusNoContent: {description: "The namespace was deleted"}},
+			handler:   s.DeleteNamespace,
+		},
+	}
+
+	// curl localhost:11001/v1/namespaces/tenant1/keys/abc
+	for _, r := range keyRoutes {
+		if !strings.HasPrefix(r.path, "/keys") && r.path != "/txn" && r.path != "/watch" {
+			continue
+		}
+		r.path = "/namespaces/:ns" + r.path
+		r.operationID += "InNamespace"
+		r.summary += " in a namespace"
+		r.handler = s.inNamespace(r.handler)
+		r.alias = false
+		routes = append(routes, r)
+	}
+	return routes
+}
+
+// inNamespace returns handler answering 404 Not Found for namespaces that don't exist
+func (s *Service) inNamespace(handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ns := c.Param("ns")
+		if _, ok := s.kv.(Namespaces).Namespace(ns); !ok {
+			abortWithError(c, http.StatusNotFound, CodeNotFound, "namespace "+ns+" not found")
+			return
+		}
+		handler(c)
+	}
+}
+
+// storeKey returns the key of the store for key, in the namespace of the request if its route has one
+func storeKey(c *gin.Context, key string) string {
+	return store.NamespacedKey(c.Param("ns"), key)
+}
+
+// validKey aborts the request and returns false if key is rejected by store.CheckKey, as it would reach into
+// another namespace
+func validKey(c *gin.Context, key string) bool {
+	err := store.CheckKey(key)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Service) GetNamespaces(c *gin.Context) {
+	namespaces := make([]namespaceJSON, 0)
+	for _, stats := range s.kv.(Namespaces).Namespaces() {
+		namespaces = append(namespaces, newNamespaceJSON(stats))
+	}
+	c.JSON(http.StatusOK, namespaces)
+}
+
+// GetNamespace returns the quota and usage of the namespace, 404 Not Found if it doesn't exist
+func (s *Service) GetNamespace(c *gin.Context) {
+	ns := c.Param("ns")
+	stats, ok := s.kv.(Namespaces).Namespace(ns)
+	if !ok {
+		abortWithError(c, http.StatusNotFound, CodeNotFound, "namespace "+ns+" not found")
+		return
+	}
+	c.JSON(http.StatusOK, newNamespaceJSON(stats))
+}
+
+// SetNamespace creates the namespace with the quota of the request body, or updates its quota
+func (s *Service) SetNamespace(c *gin.Context) {
+	var body quotaJSON
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return
+	}
+
+	ns := c.Param("ns")
+	namespaces := s.kv.(Namespaces)
+	err = namespaces.SetNamespace(ns, store.Quota{MaxKeys: body.MaxKeys, MaxBytes: body.MaxBytes})
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+
+	// The namespace is read back from this node, the leader, which applied the change
+	stats, _ := namespaces.Namespace(ns)
+	c.JSON(http.StatusOK, newNamespaceJSON(stats))
+}
+
+// DeleteNamespace deletes the namespace and its keys
+func (s *Service) DeleteNamespace(c *gin.Context) {
+	err := s.kv.(Namespaces).DeleteNamespace(c.Param("ns"))
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
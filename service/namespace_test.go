@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"github.com/naveen246/kvdb/kvdbpb"
+	"github.com/naveen246/kvdb/store"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Test_Namespaces tests that keys are scoped to their namespace and that namespaces can be managed.
+func Test_Namespaces(t *testing.T) {
+	kv := &testNamespaceStore{testStore: newTestStore(), namespaces: map[string]store.Quota{store.DefaultNamespace: {}}}
+	router := New(DefaultHTTPAddr, kv, &testRaftHandler{}).router()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		var reader io.Reader
+		if body != "" {
+			reader = strings.NewReader(body)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, reader))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/v1/namespaces/tenant1/keys", `{"k1":"v1"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"not_found","message":"namespace tenant1 not found"}}`, rec.Body.String())
+
+	rec = do(http.MethodPut, "/v1/namespaces/tenant1", `{"maxKeys":1}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"name":"tenant1","quota":{"maxKeys":1,"maxBytes":0},"keys":0,"bytes":0}`, rec.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/v1/namespaces/tenant1", `{"maxKeys":-1}`).Code)
+
+	assert.Equal(t, http.StatusCreated, do(http.MethodPost, "/v1/namespaces/tenant1/keys", `{"k1":"v1"}`).Code)
+	assert.Equal(t, "v1", kv.m[store.NamespacedKey("tenant1", "k1")])
+	assert.JSONEq(t, `{"k1":"v1"}`, do(http.MethodGet, "/v1/namespaces/tenant1/keys/k1", "").Body.String())
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/v1/keys/k1", "").Code)
+	assert.JSONEq(t, `["k1"]`, do(http.MethodGet, "/v1/namespaces/tenant1/keys", "").Body.String())
+
+	rec = do(http.MethodPost, "/v1/namespaces/tenant1/keys", `{"k2":"v2"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"quota_exceeded","message":"quota exceeded"}}`, rec.Body.String())
+
+	rec = do(http.MethodGet, "/v1/namespaces/tenant1", "")
+	assert.JSONEq(t, `{"name":"tenant1","quota":{"maxKeys":1,"maxBytes":0},"keys":1,"bytes":4}`, rec.Body.String())
+	rec = do(http.MethodGet, "/v1/namespaces", "")
+	assert.JSONEq(t, `[{"name":"default","quota":{"maxKeys":0,"maxBytes":0},"keys":0,"bytes":0},
+		{"name":"tenant1","quota":{"maxKeys":1,"maxBytes":0},"keys":1,"bytes":4}]`, rec.Body.String())
+
+	assert.Equal(t, http.StatusNoContent, do(http.MethodDelete, "/v1/namespaces/tenant1", "").Code)
+	assert.Empty(t, kv.m)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/v1/namespaces/tenant1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/v1/namespaces/default", "").Code)
+}
+
+// Test_NamespaceEscape tests that keys of the default namespace can't reach into another namespace.
+func Test_NamespaceEscape(t *testing.T) {
+	kv := &testNamespaceStore{testStore: newTestStore(), namespaces: map[string]store.Quota{
+		store.DefaultNamespace: {}, "tenant1": {MaxKeys: 1},
+	}}
+	kv.m[store.NamespacedKey("tenant1", "k1")] = "v1"
+	router := New(DefaultHTTPAddr, kv, &testRaftHandler{}).router()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/v1/keys", `{"tenant1\u0000k2":"v2"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"invalid","message":"invalid key \"tenant1\\x00k2\": keys must not contain NUL bytes"}}`,
+		rec.Body.String())
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/v1/keys/tenant1%00k1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/v1/keys/tenant1%00k1", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/v1/keys/tenant1%00k1/incr", `{}`).Code)
+	assert.Equal(t, map[string]string{store.NamespacedKey("tenant1", "k1"): "v1"}, kv.m)
+
+	// Over gRPC
+	client := kvdbpb.NewKVClient(newTestGRPC(t, NewGRPC("", kv, nil)))
+	_, err := client.Put(context.Background(), &kvdbpb.PutRequest{Key: "tenant1\x00k2", Value: "v2"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = client.Get(context.Background(), &kvdbpb.GetRequest{Key: "tenant1\x00k1"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Len(t, kv.m, 1)
+}
+
+// testNamespaceStore is a testStore keeping keys in namespaces, limited to the number of keys of their quota
+type testNamespaceStore struct {
+	*testStore
+	namespaces map[string]store.Quota
+}
+
+func (t *testNamespaceStore) Set(key, value string) error {
+	ns, _, ok := strings.Cut(key, "\x00")
+	if !ok {
+		ns = store.DefaultNamespace
+	}
+	stats, _ := t.Namespace(ns)
+	if _, exists := t.m[key]; !exists && stats.Quota.MaxKeys > 0 && stats.Keys >= stats.Quota.MaxKeys {
+		return store.ErrQuotaExceeded
+	}
+	return t.testStore.Set(key, value)
+}
+
+func (t *testNamespaceStore) SetNamespace(name string, quota store.Quota) error {
+	if quota.MaxKeys < 0 || quota.MaxBytes < 0 {
+		return store.ErrInvalidNamespace
+	}
+	t.namespaces[name] = quota
+	return nil
+}
+
+func (t *testNamespaceStore) DeleteNamespace(name string) error {
+	if name == store.DefaultNamespace {
+		return store.ErrInvalidNamespace
+	}
+	for _, key := range t.KeysIn(name) {
+		delete(t.m, store.NamespacedKey(name, key))
+	}
+	delete(t.namespaces, name)
+	return nil
+}
+
+func (t *testNamespaceStore) Namespace(name string) (store.NamespaceStats, bool) {
+	quota, ok := t.namespaces[name]
+	if !ok {
+		return store.NamespaceStats{}, false
+	}
+	stats := store.NamespaceStats{Name: name, Quota: quota}
+	for _, key := range t.KeysIn(name) {
+		stats.Keys++
+		stats.Bytes += int64(len(key) + len(t.m[store.NamespacedKey(name, key)]))
+	}
+	return stats, true
+}
+
+func (t *testNamespaceStore) Namespaces() []store.NamespaceStats {
+	var namespaces []store.NamespaceStats
+	for name := range t.namespaces {
+		stats, _ := t.Namespace(name)
+		namespaces = append(namespaces, stats)
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces
+}
+
+func (t *testNamespaceStore) KeysIn(ns string) []string {
+	keys := make([]string, 0)
+	for key := range t.m {
+		if store.NamespacedKey(ns, "") == "" {
+			if !strings.Contains(key, "\x00") {
+				keys = append(keys, key)
+			}
+		} else if local, ok := strings.CutPrefix(key, store.NamespacedKey(ns, "")); ok {
+			keys = append(keys, local)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Schema is the subset of the OpenAPI schema object used to describe the JSON bodies of the API
+// and to validate request bodies
+type Schema struct {
+	Type                 string             `json:"type"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	MinLength            int                `json:"minLength,omitempty"`
+	Minimum              *int64             `json:"minimum,omitempty"`
+	Maximum              *int64             `json:"maximum,omitempty"`
+}
+
+var (
+	stringSchema = &Schema{Type: "string"}
+
+	keyValuesSchema = &Schema{
+		Type:                 "object",
+		Description:          "Values by key",
+		AdditionalProperties: stringSchema,
+	}
+
+	incrSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"delta": {Type: "integer", Description: "Added to the value, 1 if not set"},
+			"min":   {Type: "integer", Description: "Increments taking the value below min fail"},
+			"max":   {Type: "integer", Description: "Increments taking the value above max fail"},
+		},
+	}
+
+	nodeSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"NodeID":   stringSchema,
+			"RaftAddr": stringSchema,
+			"HTTPAddr": {Type: "string", Description: "Empty until the node's HTTP address is known to the cluster"},
+		},
+	}
+
+	joinSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"nodeID":   {Type: "string", MinLength: 1},
+			"addr":     {Type: "string", Description: "Raft address of the node", MinLength: 1},
+			"httpAddr": {Type: "string", Description: "HTTP address of the node"},
+		},
+		Required: []string{"nodeID", "addr"},
+	}
+
+	errorSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"error": {
+				Type: "object",
+				Properties: map[string]*Schema{
+					"code":    stringSchema,
+					"message": stringSchema,
+					"leader":  nodeSchema,
+				},
+				Required: []string{"code", "message"},
+			},
+		},
+		Required: []string{"error"},
+	}
+)
+
+// validate returns an error describing the first part of value, decoded from JSON, that doesn't match the schema.
+// path names value in the error.
+func (schema *Schema) validate(value interface{}, path string) error {
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", path)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok {
+				property = schema.AdditionalProperties
+			}
+			if property == nil {
+				continue
+			}
+			err := property.validate(object[name], path+"."+name)
+			if err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an array", path)
+		}
+		for i, item := range array {
+			err := schema.Items.validate(item, path+"["+strconv.Itoa(i)+"]")
+			if err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", path)
+		}
+		if len(str) < schema.MinLength {
+			return fmt.Errorf("%s must be at least %d characters long", path, schema.MinLength)
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return fmt.Errorf("%s must be an integer", path)
+		}
+		if schema.Minimum != nil && number < float64(*schema.Minimum) {
+			return fmt.Errorf("%s must be at least %d", path, *schema.Minimum)
+		}
+		if schema.Maximum != nil && number > float64(*schema.Maximum) {
+			return fmt.Errorf("%s must be at most %d", path, *schema.Maximum)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be a boolean", path)
+		}
+	}
+	return nil
+}
+
+// route is an operation of the HTTP API, served under /v1 and described in its OpenAPI document
+type route struct {
+	method string
+	// path is the gin path of the route, relative to /v1
+	path        string
+	operationID string
+	summary     string
+	// body is the schema of the request body, requests without a body if nil
+	body      *Schema
+	query     []parameter
+	responses map[int]response
+	handler   gin.HandlerFunc
+	// alias is set for the routes that predate /v1, also served without prefix as deprecated aliases
+	alias bool
+}
+
+// parameter is a query parameter of a route
+type parameter struct {
+	name        string
+	description string
+	schema      *Schema
+}
+
+// response describes a successful response of a route, error responses are ErrorResponse
+type response struct {
+	description string
+	// schema is the schema of JSON bodies
+	schema *Schema
+	// text is set for plain text bodies, responses without schema and text have no body
+	text bool
+}
+
+// routes returns the operations of the HTTP API, with the transaction, watch, session, namespace and sequence routes
+// if the KV store implements Transactional, Watchable, Sessions, Namespaces and Sequences, and the quarantine route
+// if the raft handler implements Quarantine
+func (s *Service) routes() []route {
+	routes := []route{
+		// curl -X POST localhost:11001/v1/keys -d '{"abc":"122"}'
+		{
+			method: http.MethodPost, path: "/keys", operationID: "setKeys", summary: "Set the keys of the body to their values",
+			body:      keyValuesSchema,
+			responses: map[int]response{http.StatusCreated: {description: "The keys were set", schema: keyValuesSchema}},
+			handler:   s.SetKey,
+			alias:     true,
+		},
+		// curl localhost:11001/v1/keys
+		{
+			method: http.MethodGet, path: "/keys", operationID: "listKeys", summary: "List the keys",
+			responses: map[int]response{http.StatusOK: {description: "The keys", schema: &Schema{Type: "array", Items: stringSchema}}},
+			handler:   s.GetKeys,
+			alias:     true,
+		},
+		// curl localhost:11001/v1/keys/abc
+		{
+			method: http.MethodGet, path: "/keys/:key", operationID: "getKey", summary: "Get the value of a key",
+			responses: map[int]response{http.StatusOK: {description: "The value by key", schema: keyValuesSchema}},
+			handler:   s.GetKey,
+			alias:     true,
+		},
+		// curl -X DELETE localhost:11001/v1/keys/abc
+		{
+			method: http.MethodDelete, path: "/keys/:key", operationID: "deleteKey", summary: "Delete a key",
+			responses: map[int]response{http.StatusOK: {description: "The deleted key", text: true}},
+			handler:   s.DeleteKey,
+			alias:     true,
+		},
+		// curl -X POST localhost:11001/v1/keys/abc/incr -d '{"delta":1}'
+		{
+			method: http.MethodPost, path: "/keys/:key/incr", operationID: "incrKey", summary: "Add to the integer value of a key",
+			body: incrSchema,
+			responses: map[int]response{http.StatusOK: {
+				description: "The new value by key",
+				schema:      &Schema{Type: "object", AdditionalProperties: &Schema{Type: "integer"}},
+			}},
+			handler: s.IncrKey,
+		},
+		// curl -X POST localhost:11001/v1/raft/join -d '{ "addr": "localhost:12002", "nodeID": "node2", "httpAddr": "localhost:11002" }'
+		{
+			method: http.MethodPost, path: "/raft/join", operationID: "joinCluster", summary: "Add a node to the cluster",
+			body:      joinSchema,
+			responses: map[int]response{http.StatusOK: {description: "The node was added", text: true}},
+			handler:   s.RaftJoin,
+			alias:     true,
+		},
+		// curl localhost:11001/v1/raft/node
+		{
+			method: http.MethodGet, path: "/raft/node", operationID: "getNode", summary: "Get the node serving the request",
+			responses: map[int]response{http.StatusOK: {description: "The node", schema: nodeSchema}},
+			handler:   s.RaftNode,
+			alias:     true,
+		},
+		// curl localhost:11001/v1/raft/leader
+		{
+			method: http.MethodGet, path: "/raft/leader", operationID: "getLeader", summary: "Get the leader of the cluster",
+			responses: map[int]response{http.StatusOK: {description: "The leader, with an empty NodeID if there is none", schema: nodeSchema}},
+			handler:   s.RaftLeader,
+			alias:     true,
+		},
+		// curl localhost:11001/v1/raft/servers
+		{
+			method: http.MethodGet, path: "/raft/servers", operationID: "listServers", summary: "List the members of the cluster",
+			responses: map[int]response{http.StatusOK: {description: "The members", schema: &Schema{Type: "array", Items: nodeSchema}}},
+			handler:   s.RaftServers,
+			alias:     true,
+		},
+		// curl -X POST localhost:11001/v1/raft/compact
+		{
+			method: http.MethodPost, path: "/raft/compact", operationID: "compactLog", summary: "Reclaim the disk space of the raft log store",
+			responses: map[int]response{http.StatusNoContent: {description: "The log store was compacted"}},
+			handler:   s.RaftCompact,
+			alias:     true,
+		},
+	}
+	if _, ok := s.kv.(Transactional); ok {
+		routes = append(routes, s.txnRoutes()...)
+	}
+	if _, ok := s.kv.(Watchable); ok {
+		routes = append(routes, s.watchRoutes()...)
+	}
+	if _, ok := s.kv.(Sessions); ok {
+		routes = append(routes, s.sessionRoutes()...)
+	}
+	if _, ok := s.kv.(Namespaces); ok {
+		routes = append(routes, s.namespaceRoutes(routes)...)
+	}
+	if _, ok := s.kv.(Sequences); ok {
+		routes = append(routes, s.sequenceRoutes()...)
+	}
+	if _, ok := s.raftHandler.(Quarantine); ok {
+		routes = append(routes, s.quarantineRoutes()...)
+	}
+	return routes
+}
+
+// openAPI returns the OpenAPI 3 document describing routes
+func (s *Service) openAPI(routes []route) gin.H {
+	paths := gin.H{}
+	for _, r := range routes {
+		path, parameters := openAPIPath(r.path)
+
+		responses := gin.H{
+			"default": gin.H{
+				"description": "The request failed",
+				"content":     gin.H{"application/json": gin.H{"schema": errorSchema}},
+			},
+		}
+		for status, resp := range r.responses {
+			body := gin.H{"description": resp.description}
+			if resp.schema != nil {
+				body["content"] = gin.H{"application/json": gin.H{"schema": resp.schema}}
+			} else if resp.text {
+				body["content"] = gin.H{"text/plain": gin.H{"schema": stringSchema}}
+			}
+			responses[strconv.Itoa(status)] = body
+		}
+
+		operation := gin.H{"operationId": r.operationID, "summary": r.summary, "responses": responses}
+		for _, p := range r.query {
+			parameters = append(parameters, gin.H{"name": p.name, "in": "query", "description": p.description, "schema": p.schema})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if r.body != nil {
+			operation["requestBody"] = gin.H{
+				"required": true,
+				"content":  gin.H{"application/json": gin.H{"schema": r.body}},
+			}
+		}
+
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[path] = item
+		}
+		item[strings.ToLower(r.method)] = operation
+	}
+
+	doc := gin.H{
+		"openapi": "3.0.3",
+		"info":    gin.H{"title": "kvdb", "version": "1"},
+		"servers": []gin.H{{"url": "/v1"}},
+		"paths":   paths,
+	}
+	if s.AuthToken != "" {
+		doc["components"] = gin.H{"securitySchemes": gin.H{"bearer": gin.H{"type": "http", "scheme": "bearer"}}}
+		doc["security"] = []gin.H{{"bearer": []string{}}}
+	}
+	return doc
+}
+
+// openAPIPath converts a gin path to an OpenAPI path, returning the path parameters it declares
+func openAPIPath(path string) (string, []gin.H) {
+	var parameters []gin.H
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		name, ok := strings.CutPrefix(segment, ":")
+		if !ok {
+			continue
+		}
+		segments[i] = "{" + name + "}"
+		parameters = append(parameters, gin.H{"name": name, "in": "path", "required": true, "schema": stringSchema})
+	}
+	return strings.Join(segments, "/"), parameters
+}
+
+// validateBody returns a handler rejecting requests whose body doesn't match schema
+func validateBody(schema *Schema) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortWithBodyError(c, err)
+			return
+		}
+
+		var value interface{}
+		err = json.Unmarshal(body, &value)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalid, "invalid JSON body: "+err.Error())
+			return
+		}
+		err = schema.validate(value, "body")
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
+
+// validateQuery returns a handler rejecting requests whose query parameters don't match their schema
+func validateQuery(parameters []parameter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, p := range parameters {
+			raw, ok := c.GetQuery(p.name)
+			if !ok {
+				continue
+			}
+
+			var value interface{} = raw
+			if p.schema.Type == "integer" {
+				n, err := strconv.ParseInt(raw, 10, 64)
+				if err != nil {
+					abortWithError(c, http.StatusBadRequest, CodeInvalid, p.name+" must be an integer")
+					return
+				}
+				value = float64(n)
+			}
+			err := p.schema.validate(value, p.name)
+			if err != nil {
+				abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// deprecated marks the responses of the unversioned aliases of the /v1 routes as deprecated,
+// linking to the /v1 route
+func deprecated(c *gin.Context) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", "</v1"+c.Request.URL.Path+`>; rel="successor-version"`)
+	c.Next()
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test_OpenAPI tests that the OpenAPI document describes every /v1 route.
+func Test_OpenAPI(t *testing.T) {
+	svc := New(DefaultHTTPAddr, newTestStore(), &testRaftHandler{})
+	router := svc.router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var doc struct {
+		OpenAPI string
+		Paths   map[string]map[string]struct {
+			OperationID string
+			Parameters  []struct{ Name, In string }
+			RequestBody *struct{ Required bool }
+			Responses   map[string]interface{}
+		}
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc.OpenAPI)
+
+	for _, r := range svc.routes() {
+		path, _ := openAPIPath(r.path)
+		operation, ok := doc.Paths[path][strings.ToLower(r.method)]
+		assert.True(t, ok, "%s %s is not documented", r.method, path)
+		assert.Equal(t, r.operationID, operation.OperationID)
+		assert.Contains(t, operation.Responses, "default")
+		assert.Equal(t, r.body != nil, operation.RequestBody != nil)
+	}
+
+	getKey := doc.Paths["/keys/{key}"]["get"]
+	assert.Len(t, getKey.Parameters, 1)
+	assert.Equal(t, "key", getKey.Parameters[0].Name)
+	assert.Equal(t, "path", getKey.Parameters[0].In)
+}
+
+// Test_Routes tests that request bodies are validated and that the unversioned routes are deprecated aliases.
+func Test_Routes(t *testing.T) {
+	kv := newTestStore()
+	router := New(DefaultHTTPAddr, kv, &testRaftHandler{leader: true, httpAddrs: map[string]string{}}).router()
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := request(http.MethodPost, "/v1/keys", `{"k1":"v1"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+	assert.Equal(t, "v1", kv.m["k1"])
+
+	rec = request(http.MethodGet, "/keys/k1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "true", rec.Header().Get("Deprecation"))
+	assert.Equal(t, `</v1/keys/k1>; rel="successor-version"`, rec.Header().Get("Link"))
+
+	for _, tc := range []struct{ path, body, message string }{
+		{"/v1/keys", `{"k1":1}`, "body.k1 must be a string"},
+		{"/v1/keys", `["k1"]`, "body must be an object"},
+		{"/v1/raft/join", `{"nodeID":"node2"}`, "body.addr is required"},
+		{"/v1/raft/join", `{"nodeID":"","addr":"localhost:12002"}`, "body.nodeID must be at least 1 characters long"},
+		{"/raft/join", `{"nodeID":"node2","addr":"localhost:12002","httpAddr":true}`, "body.httpAddr must be a string"},
+	} {
+		rec = request(http.MethodPost, tc.path, tc.body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, tc.body)
+		var resp ErrorResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, Error{Code: CodeInvalid, Message: tc.message}, resp.Error)
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/naveen246/kvdb/store"
+	"net/http"
+)
+
+// Quarantine is implemented by raft handlers recording the committed log entries their FSM could not apply
+// because they are malformed. The entries are recorded by each node, they are not replicated.
+type Quarantine interface {
+	// Quarantined returns the last entries the FSM could not apply, oldest first.
+	Quarantined() []store.QuarantinedEntry
+}
+
+var quarantinedSchema = &Schema{
+	Type: "object",
+	Properties: map[string]*Schema{
+		"index": {Type: "integer", Description: "Raft index of the entry"},
+		"op":    {Type: "string", Description: "Op of the command, if it could be decoded"},
+		"error": {Type: "string", Description: "Why the entry could not be applied"},
+		"data":  {Type: "string", Description: "Beginning of the entry, if it could not be decoded"},
+	},
+}
+
+// quarantineRoutes returns the admin operations on the quarantined log entries
+func (s *Service) quarantineRoutes() []route {
+	return []route{
+		// curl localhost:11001/v1/admin/quarantine
+		{
+			method: http.MethodGet, path: "/admin/quarantine", operationID: "listQuarantined",
+			summary: "List the malformed committed log entries this node could not apply",
+			responses: map[int]response{http.StatusOK: {
+				description: "The quarantined entries, oldest first",
+				schema:      &Schema{Type: "array", Items: quarantinedSchema},
+			}},
+			handler: s.GetQuarantined,
+		},
+	}
+}
+
+func (s *Service) GetQuarantined(c *gin.Context) {
+	entries := s.raftHandler.(Quarantine).Quarantined()
+	if entries == nil {
+		entries = []store.QuarantinedEntry{}
+	}
+	c.JSON(http.StatusOK, entries)
+}
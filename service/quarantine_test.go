@@ -0,0 +1,48 @@
+package service
+
+import (
+	"github.com/naveen246/kvdb/store"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test_GetQuarantined tests that the quarantined log entries are listed, and only by raft handlers recording them.
+func Test_GetQuarantined(t *testing.T) {
+	handler := &testQuarantineHandler{testRaftHandler: &testRaftHandler{}}
+	router := New(DefaultHTTPAddr, newTestStore(), handler).router()
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/admin/quarantine", nil))
+		return rec
+	}
+
+	rec := get()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[]`, rec.Body.String())
+
+	handler.entries = []store.QuarantinedEntry{
+		{Index: 7, Error: "malformed command: unexpected end of JSON input", Data: `{"op":`},
+		{Index: 9, Op: "INCR", Error: "malformed command: INCR command without incr"},
+	}
+	assert.JSONEq(t, `[
+		{"index":7,"error":"malformed command: unexpected end of JSON input","data":"{\"op\":"},
+		{"index":9,"op":"INCR","error":"malformed command: INCR command without incr"}
+	]`, get().Body.String())
+
+	router = New(DefaultHTTPAddr, newTestStore(), &testRaftHandler{}).router()
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/admin/quarantine", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// testQuarantineHandler is a testRaftHandler recording quarantined log entries
+type testQuarantineHandler struct {
+	*testRaftHandler
+	entries []store.QuarantinedEntry
+}
+
+func (t *testQuarantineHandler) Quarantined() []store.QuarantinedEntry {
+	return t.entries
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/naveen246/kvdb/store"
+	"net/http"
+	"strconv"
+)
+
+// Sequences is implemented by KV stores handing out IDs from sequences.
+type Sequences interface {
+	// NextSequence hands out count IDs of the sequence name, via distributed consensus, and returns the first one.
+	NextSequence(name string, count uint64) (uint64, error)
+}
+
+// sequenceRoutes returns the operations of the HTTP API on sequences
+func (s *Service) sequenceRoutes() []route {
+	minCount, maxCount := int64(1), int64(store.MaxSequenceCount)
+	return []route{
+		// curl -X POST 'localhost:11001/v1/sequences/orders/next?count=1000'
+		{
+			method: http.MethodPost, path: "/sequences/:name/next", operationID: "nextSequence",
+			summary: "Hand out a range of IDs of a sequence, never handed out before",
+			query: []parameter{{
+				name:        "count",
+				description: "Number of IDs to hand out, 1 if not set",
+				schema:      &Schema{Type: "integer", Minimum: &minCount, Maximum: &maxCount},
+			}},
+			responses: map[int]response{http.StatusOK: {
+				description: "The first and last IDs of the range",
+				schema: &Schema{
+					Type: "object",
+					Properties: map[string]*Schema{
+						"name":  stringSchema,
+						"first": {Type: "integer"},
+						"last":  {Type: "integer"},
+					},
+				},
+			}},
+			handler: s.NextSequence,
+		},
+	}
+}
+
+// NextSequence hands out the number of IDs of the count query parameter, 1 if it is not set, and returns
+// the first and last of them. IDs of a sequence start at 1 and increase with every range.
+func (s *Service) NextSequence(c *gin.Context) {
+	count := uint64(1)
+	if raw, ok := c.GetQuery("count"); ok {
+		var err error
+		count, err = strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+			return
+		}
+	}
+
+	name := c.Param("name")
+	first, err := s.kv.(Sequences).NextSequence(name, count)
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"name": name, "first": first, "last": first + count - 1})
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test_NextSequence tests that ranges of IDs are handed out in order and that counts are validated.
+func Test_NextSequence(t *testing.T) {
+	router := New(DefaultHTTPAddr, &testSequenceStore{testStore: newTestStore()}, &testRaftHandler{}).router()
+	next := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec
+	}
+
+	rec := next("/v1/sequences/orders/next?count=1000")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"name":"orders","first":1,"last":1000}`, rec.Body.String())
+
+	rec = next("/v1/sequences/orders/next")
+	assert.JSONEq(t, `{"name":"orders","first":1001,"last":1001}`, rec.Body.String())
+
+	rec = next("/v1/sequences/orders/next?count=0")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"invalid","message":"count must be at least 1"}}`, rec.Body.String())
+	assert.Equal(t, http.StatusBadRequest, next("/v1/sequences/orders/next?count=many").Code)
+	assert.Equal(t, http.StatusBadRequest, next("/v1/sequences/orders/next?count=1000001").Code)
+}
+
+// testSequenceStore is a testStore handing out IDs from sequences
+type testSequenceStore struct {
+	*testStore
+	sequences map[string]uint64
+}
+
+func (t *testSequenceStore) NextSequence(name string, count uint64) (uint64, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	if t.sequences == nil {
+		t.sequences = make(map[string]uint64)
+	}
+	first := t.sequences[name] + 1
+	t.sequences[name] += count
+	return first, nil
+}
@@ -1,16 +1,20 @@
 package service
 
 import (
+	"context"
+	"crypto/subtle"
 	"github.com/gin-gonic/gin"
 	"github.com/naveen246/kvdb/store"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 )
 
 // KV is the interface RaftHandler-backed key-value stores must implement.
 type KV interface {
-	// Get returns the value for the given key.
-	Get(key string) string
+	// Get returns the value for the given key and whether the key is set.
+	Get(key string) (string, bool)
 
 	// Set sets the value for the given key, via distributed consensus.
 	Set(key, value string) error
@@ -20,24 +24,52 @@ type KV interface {
 
 	// Keys returns the list of keys
 	Keys() []string
+
+	// Incr applies the increment, via distributed consensus, and returns the new value of the key.
+	Incr(incr store.Incr) (int64, error)
 }
 
 type RaftHandler interface {
 	// AddNode adds the node, identified by nodeID and reachable at addr, to the cluster.
 	AddNode(nodeID string, addr string) error
 
+	// SetNodeMeta records meta as the metadata of the node nodeID, such as its HTTP address.
+	SetNodeMeta(nodeID string, meta store.NodeMeta) error
+
+	// LocalNode returns the node serving the request.
+	LocalNode() store.Node
+
 	Leader() store.Node
 
 	NodeList() ([]store.Node, error)
 
 	Snapshot() error
+
+	// Compact rewrites the raft log store to reclaim disk space.
+	Compact() error
 }
 
-// Service provides HTTP service.
+// Service provides HTTP service. Sessions and locks are served if the KV store implements Sessions,
+// sequences if it implements Sequences, namespaces if it implements Namespaces, transactions if it implements
+// Transactional and watches if it implements Watchable. The quarantined log entries are served if the raft
+// handler implements Quarantine.
 type Service struct {
 	addr        string
 	kv          KV
 	raftHandler RaftHandler
+	server      *http.Server
+	// closing is closed on Shutdown to end the watches, which would hold it up otherwise
+	closing chan struct{}
+
+	// CertFile and KeyFile enable HTTPS when both are set
+	CertFile string
+	KeyFile  string
+
+	// AuthToken, when set, must be sent by clients in an "Authorization: Bearer <token>" header
+	AuthToken string
+
+	// Limits bound the size of requests, DefaultLimits unless changed before Start
+	Limits Limits
 }
 
 // New returns an uninitialized HTTP service.
@@ -46,56 +78,125 @@ func New(addr string, kv KV, raftHandler RaftHandler) *Service {
 		addr:        addr,
 		kv:          kv,
 		raftHandler: raftHandler,
+		closing:     make(chan struct{}),
+		Limits:      DefaultLimits,
 	}
 }
 
-// Start starts the service.
-func (s *Service) Start() {
-	router := gin.Default()
+// Start starts the service. The listener is bound before Start returns, requests are served in the background.
+func (s *Service) Start() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	s.server = &http.Server{Handler: s.router()}
+	s.server.RegisterOnShutdown(func() { close(s.closing) })
 
-	// curl -X POST localhost:11001/keys -d '{"abc":"122"}'
-	router.POST("/keys", s.SetKey)
+	go func() {
+		var err error
+		if s.CertFile != "" && s.KeyFile != "" {
+			err = s.server.ServeTLS(ln, s.CertFile, s.KeyFile)
+		} else {
+			err = s.server.Serve(ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP serve: %s", err)
+		}
+	}()
 
-	// curl localhost:11001/keys
-	router.GET("/keys", s.GetKeys)
+	return nil
+}
 
-	// curl localhost:11001/keys/abc
-	router.GET("/keys/:key", s.GetKey)
+// Shutdown stops accepting new connections and waits for in-flight requests to complete
+// until ctx is done, after which the remaining connections are closed.
+func (s *Service) Shutdown(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
 
-	// curl -X DELETE localhost:11001/keys/abc
-	router.DELETE("/keys/:key", s.DeleteKey)
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		s.server.Close()
+	}
+	return err
+}
 
-	// curl -X POST localhost:11001/raft/join -d '{ "addr": "localhost:12002", "nodeID": "node2" }'
-	router.POST("/raft/join", s.RaftJoin)
+// router returns the handler serving the HTTP API. The routes are served under /v1, the routes that
+// predate /v1 also without prefix as deprecated aliases.
+func (s *Service) router() *gin.Engine {
+	router := gin.Default()
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(func(c *gin.Context) {
+		abortWithError(c, http.StatusNotFound, CodeNotFound, "no route for "+c.Request.Method+" "+c.Request.URL.Path)
+	})
+	router.NoMethod(func(c *gin.Context) {
+		abortWithError(c, http.StatusMethodNotAllowed, CodeInvalid, c.Request.Method+" not allowed on "+c.Request.URL.Path)
+	})
+	if s.AuthToken != "" {
+		router.Use(s.authenticate)
+	}
+	router.Use(s.limitBody)
+
+	v1 := router.Group("/v1")
+	legacy := router.Group("/", deprecated)
+	routes := s.routes()
+	for _, r := range routes {
+		var handlers []gin.HandlerFunc
+		if len(r.query) > 0 {
+			handlers = append(handlers, validateQuery(r.query))
+		}
+		if r.body != nil {
+			handlers = append(handlers, validateBody(r.body))
+		}
+		handlers = append(handlers, r.handler)
+		v1.Handle(r.method, r.path, handlers...)
+		if r.alias {
+			legacy.Handle(r.method, r.path, handlers...)
+		}
+	}
 
-	// curl localhost:11001/raft/leader
-	router.GET("/raft/leader", s.RaftLeader)
+	// curl localhost:11001/v1/openapi.json
+	doc := s.openAPI(routes)
+	v1.GET("/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc)
+	})
 
-	// curl localhost:11001/raft/servers
-	router.GET("/raft/servers", s.RaftServers)
+	return router
+}
 
-	go func() {
-		err := router.Run(s.addr)
-		if err != nil {
-			log.Fatalf("HTTP serve: %s", err)
-		}
-	}()
+// authenticate rejects requests not carrying s.AuthToken as bearer token
+func (s *Service) authenticate(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.AuthToken)) != 1 {
+		abortWithError(c, http.StatusUnauthorized, CodeUnauthorized, "missing or invalid bearer token")
+		return
+	}
+	c.Next()
 }
 
 // ************** KV Service *********************************//
 
 func (s *Service) SetKey(c *gin.Context) {
 	m := map[string]string{}
-	err := c.BindJSON(&m)
+	err := c.ShouldBindJSON(&m)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, err.Error())
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return
+	}
+	if !s.checkBatch(c, len(m)) {
 		return
 	}
+	for k, v := range m {
+		if !s.checkKeyValue(c, k, v) {
+			return
+		}
+	}
 
 	for k, v := range m {
-		err := s.kv.Set(k, v)
+		err := s.kv.Set(storeKey(c, k), v)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, err.Error())
+			s.abortWithStoreError(c, err)
 			return
 		}
 	}
@@ -103,38 +204,113 @@ func (s *Service) SetKey(c *gin.Context) {
 	c.JSON(http.StatusCreated, m)
 }
 
+// GetKey returns the value of the key, 404 Not Found if it is not set
 func (s *Service) GetKey(c *gin.Context) {
 	key := c.Param("key")
-	value := s.kv.Get(key)
+	if !validKey(c, key) {
+		return
+	}
+	value, ok := s.kv.Get(storeKey(c, key))
+	if !ok {
+		abortWithError(c, http.StatusNotFound, CodeNotFound, "key "+key+" not found")
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{key: value})
 }
 
 func (s *Service) DeleteKey(c *gin.Context) {
 	key := c.Param("key")
-	err := s.kv.Delete(key)
+	if !validKey(c, key) {
+		return
+	}
+	err := s.kv.Delete(storeKey(c, key))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+		s.abortWithStoreError(c, err)
+		return
 	}
 
 	c.String(http.StatusOK, key)
 }
 
+// IncrKey adds the delta of the request body, 1 if it is not set, to the integer value of the key
+// and returns the new value. Increments of values that are not integers or out of the bounds of the request
+// answer 409 Conflict.
+func (s *Service) IncrKey(c *gin.Context) {
+	var body = struct {
+		Delta *int64 `json:"delta"`
+		Min   *int64 `json:"min"`
+		Max   *int64 `json:"max"`
+	}{}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return
+	}
+
+	key := c.Param("key")
+	if !s.checkKey(c, key) {
+		return
+	}
+	incr := store.Incr{Key: storeKey(c, key), Delta: 1, Min: body.Min, Max: body.Max}
+	if body.Delta != nil {
+		incr.Delta = *body.Delta
+	}
+	value, err := s.kv.Incr(incr)
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{key: value})
+}
+
 func (s *Service) GetKeys(c *gin.Context) {
+	if ns := c.Param("ns"); ns != "" {
+		c.JSON(http.StatusOK, s.kv.(Namespaces).KeysIn(ns))
+		return
+	}
 	c.JSON(http.StatusOK, s.kv.Keys())
 }
 
 // ************************ Raft service *************************//
 
+// RaftJoin adds the node in the request body to the cluster. Followers answer 421 Misdirected Request
+// naming the leader when they know its HTTP address and 503 Service Unavailable otherwise.
 func (s *Service) RaftJoin(c *gin.Context) {
 	var node = struct {
-		NodeID string `json:"nodeID"`
-		Addr   string `json:"addr"`
+		NodeID   string `json:"nodeID"`
+		Addr     string `json:"addr"`
+		HTTPAddr string `json:"httpAddr"`
+		RESPAddr string `json:"respAddr"`
+		Version  int    `json:"version"`
 	}{}
-	c.BindJSON(&node)
-	s.raftHandler.AddNode(node.NodeID, node.Addr)
+	err := c.ShouldBindJSON(&node)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return
+	}
+
+	err = s.raftHandler.AddNode(node.NodeID, node.Addr)
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+
+	if node.HTTPAddr != "" {
+		err = s.raftHandler.SetNodeMeta(node.NodeID, store.NodeMeta{HTTPAddr: node.HTTPAddr, RESPAddr: node.RESPAddr, Version: node.Version})
+		if err != nil {
+			s.abortWithStoreError(c, err)
+			return
+		}
+	}
+
 	c.String(http.StatusOK, "Node added %s - %s", node.NodeID, node.Addr)
 }
 
+func (s *Service) RaftNode(c *gin.Context) {
+	c.JSON(http.StatusOK, s.raftHandler.LocalNode())
+}
+
 func (s *Service) RaftLeader(c *gin.Context) {
 	leader := s.raftHandler.Leader()
 	c.JSON(http.StatusOK, leader)
@@ -143,7 +319,17 @@ func (s *Service) RaftLeader(c *gin.Context) {
 func (s *Service) RaftServers(c *gin.Context) {
 	servers, err := s.raftHandler.NodeList()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, err.Error())
+		s.abortWithStoreError(c, err)
+		return
 	}
 	c.JSON(http.StatusOK, servers)
 }
+
+func (s *Service) RaftCompact(c *gin.Context) {
+	err := s.raftHandler.Compact()
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -1,12 +1,20 @@
 package service
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-resty/resty/v2"
+	"github.com/hashicorp/raft"
+	"github.com/naveen246/kvdb/store"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/exp/maps"
+	"net/http"
+	"net/http/httptest"
 	"slices"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -23,7 +31,7 @@ func Test_NewServer(t *testing.T) {
 	New(DefaultHTTPAddr, stor, nil).Start()
 
 	resp := getKey(t, url, "k1")
-	assert.Equal(t, `{"k1":""}`, resp)
+	assert.JSONEq(t, `{"error":{"code":"not_found","message":"key k1 not found"}}`, resp)
 
 	resp = setKey(t, url, "k1", "v1")
 
@@ -45,11 +53,265 @@ func Test_NewServer(t *testing.T) {
 
 	resp = deleteKey(t, url, "k2")
 	resp = getKey(t, url, "k2")
-	assert.Equal(t, `{"k2":""}`, resp)
+	assert.JSONEq(t, `{"error":{"code":"not_found","message":"key k2 not found"}}`, resp)
+
+	// Empty values are set
+	setKey(t, url, "k3", "")
+	resp = getKey(t, url, "k3")
+	assert.Equal(t, `{"k3":""}`, resp)
+}
+
+// Test_Shutdown tests that a stopped server no longer accepts requests and frees its address.
+func Test_Shutdown(t *testing.T) {
+	addr := "localhost:11011"
+	url := fmt.Sprintf("http://%s", addr)
+
+	svc := New(addr, newTestStore(), nil)
+	err := svc.Start()
+	assert.NoError(t, err)
+	assert.Equal(t, "[]", getKeys(t, url))
+
+	err = svc.Shutdown(context.Background())
+	assert.NoError(t, err)
+
+	_, err = resty.New().R().Get(fmt.Sprintf("%s/keys", url))
+	assert.Error(t, err)
+
+	// The address can be bound again
+	svc = New(addr, newTestStore(), nil)
+	err = svc.Start()
+	assert.NoError(t, err)
+	svc.Shutdown(context.Background())
+}
+
+// Test_Authentication tests that requests without the configured bearer token are rejected.
+func Test_Authentication(t *testing.T) {
+	svc := New(DefaultHTTPAddr, newTestStore(), nil)
+	svc.AuthToken = "secret"
+	router := svc.router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/keys", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/keys", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/keys", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+// Test_RaftJoin tests the status codes of join requests on the leader and on followers.
+func Test_RaftJoin(t *testing.T) {
+	raftHandler := &testRaftHandler{leader: true, httpAddrs: map[string]string{}}
+	router := New(DefaultHTTPAddr, newTestStore(), raftHandler).router()
+
+	join := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/raft/join", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := join(`{"nodeID": "node2", "addr": "localhost:12002", "httpAddr": "localhost:11002"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "localhost:11002", raftHandler.httpAddrs["node2"])
+
+	assert.Equal(t, http.StatusBadRequest, join(`{"nodeID": "node2"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, join(`not json`).Code)
+
+	raftHandler.err = errors.New("add voter failed")
+	assert.Equal(t, http.StatusInternalServerError, join(`{"nodeID": "node3", "addr": "localhost:12003"}`).Code)
+
+	// Followers name the leader once its HTTP address is known
+	raftHandler.err = nil
+	raftHandler.leader = false
+	assert.Equal(t, http.StatusServiceUnavailable, join(`{"nodeID": "node3", "addr": "localhost:12003"}`).Code)
+
+	raftHandler.httpAddrs["node1"] = "localhost:11001"
+	rec = join(`{"nodeID": "node3", "addr": "localhost:12003"}`)
+	assert.Equal(t, http.StatusMisdirectedRequest, rec.Code)
+	assert.Equal(t, "http://localhost:11001/raft/join", rec.Header().Get("Location"))
+}
+
+// Test_KeysNotLeader tests that followers send clients to the leader.
+func Test_KeysNotLeader(t *testing.T) {
+	kv := newTestStore()
+	kv.err = store.ErrNotLeader
+	raftHandler := &testRaftHandler{httpAddrs: map[string]string{"node1": "localhost:11001"}}
+	router := New(DefaultHTTPAddr, kv, raftHandler).router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/keys", strings.NewReader(`{"k1":"v1"}`)))
+	assert.Equal(t, http.StatusMisdirectedRequest, rec.Code)
+	assert.Equal(t, "http://localhost:11001/keys", rec.Header().Get("Location"))
+	assert.JSONEq(t, `{"error":{"code":"not_leader","message":"not leader",
+		"leader":{"NodeID":"node1","RaftAddr":"localhost:12001","HTTPAddr":"localhost:11001","RESPAddr":"","Version":0}}}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/keys/k1", nil))
+	assert.Equal(t, http.StatusMisdirectedRequest, rec.Code)
+	assert.Equal(t, "http://localhost:11001/keys/k1", rec.Header().Get("Location"))
+
+	// Leadership lost between the leader check and the raft apply, the query string is kept in Location
+	sequences := &testSequenceStore{testStore: kv}
+	kv.err = raft.ErrNotLeader
+	rec = httptest.NewRecorder()
+	New(DefaultHTTPAddr, sequences, raftHandler).router().
+		ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/sequences/orders/next?count=10", nil))
+	assert.Equal(t, http.StatusMisdirectedRequest, rec.Code)
+	assert.Equal(t, "http://localhost:11001/v1/sequences/orders/next?count=10", rec.Header().Get("Location"))
+
+	delete(raftHandler.httpAddrs, "node1")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/keys/k1", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+}
+
+// Test_KeysLeadershipLost tests that writes whose leader lost its leadership after proposing them answer
+// unknown_outcome without sending the client to the new leader, unlike writes the leader never proposed.
+func Test_KeysLeadershipLost(t *testing.T) {
+	kv := newTestStore()
+	raftHandler := &testRaftHandler{httpAddrs: map[string]string{"node1": "localhost:11001"}}
+	router := New(DefaultHTTPAddr, kv, raftHandler).router()
+
+	kv.err = raft.ErrLeadershipLost
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/keys", strings.NewReader(`{"k1":"v1"}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+	assert.JSONEq(t, `{"error":{"code":"unknown_outcome","message":"leadership lost while committing log"}}`, rec.Body.String())
+
+	kv.err = raft.ErrNotLeader
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/keys", strings.NewReader(`{"k1":"v1"}`)))
+	assert.Equal(t, http.StatusMisdirectedRequest, rec.Code)
+	assert.Equal(t, "http://localhost:11001/keys", rec.Header().Get("Location"))
+}
+
+// Test_Errors tests the error responses of invalid requests and failed writes.
+func Test_Errors(t *testing.T) {
+	kv := newTestStore()
+	router := New(DefaultHTTPAddr, kv, &testRaftHandler{}).router()
+
+	request := func(method, path, body string) (int, ErrorResponse) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp ErrorResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, resp := request(http.MethodPost, "/keys", `not json`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, CodeInvalid, resp.Error.Code)
+
+	code, resp = request(http.MethodGet, "/unknown", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, CodeNotFound, resp.Error.Code)
+
+	code, resp = request(http.MethodPut, "/keys", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+	assert.Equal(t, CodeInvalid, resp.Error.Code)
+
+	kv.err = raft.ErrEnqueueTimeout
+	code, resp = request(http.MethodPost, "/keys", `{"k1":"v1"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, CodeTimeout, resp.Error.Code)
+
+	kv.err = errors.New("disk full")
+	code, resp = request(http.MethodDelete, "/keys/k1", "")
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, Error{Code: CodeInternal, Message: "disk full"}, resp.Error)
+}
+
+// Test_IncrKey tests that increments return the new value and that failed increments answer 409 Conflict.
+func Test_IncrKey(t *testing.T) {
+	kv := newTestStore()
+	router := New(DefaultHTTPAddr, kv, &testRaftHandler{}).router()
+
+	incr := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/keys/n/incr", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := incr(`{}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"n":1}`, rec.Body.String())
+
+	rec = incr(`{"delta":-5,"min":-3}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"conflict","message":"increment out of range"}}`, rec.Body.String())
+
+	rec = incr(`{"delta":-4,"min":-3}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"n":-3}`, rec.Body.String())
+	assert.Equal(t, "-3", kv.m["n"])
+
+	assert.Equal(t, http.StatusBadRequest, incr(`{"delta":1.5}`).Code)
+
+	kv.m["n"] = "v1"
+	assert.Equal(t, http.StatusConflict, incr(`{"delta":1}`).Code)
+}
+
+// Test_RaftNode tests that a node describes itself.
+func Test_RaftNode(t *testing.T) {
+	router := New(DefaultHTTPAddr, newTestStore(), &testRaftHandler{}).router()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/raft/node", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"NodeID":"node2","RaftAddr":"localhost:12002","HTTPAddr":"localhost:11002","RESPAddr":"","Version":0}`, rec.Body.String())
+}
+
+type testRaftHandler struct {
+	leader    bool
+	err       error
+	httpAddrs map[string]string
+}
+
+func (t *testRaftHandler) AddNode(nodeID string, addr string) error {
+	if !t.leader {
+		return store.ErrNotLeader
+	}
+	return t.err
+}
+
+func (t *testRaftHandler) SetNodeMeta(nodeID string, meta store.NodeMeta) error {
+	t.httpAddrs[nodeID] = meta.HTTPAddr
+	return nil
+}
+
+func (t *testRaftHandler) LocalNode() store.Node {
+	return store.Node{NodeID: "node2", RaftAddr: "localhost:12002", HTTPAddr: "localhost:11002"}
+}
+
+func (t *testRaftHandler) Leader() store.Node {
+	return store.Node{NodeID: "node1", RaftAddr: DefaultRaftAddr, HTTPAddr: t.httpAddrs["node1"]}
+}
+
+func (t *testRaftHandler) NodeList() ([]store.Node, error) {
+	return []store.Node{t.Leader()}, nil
+}
+
+func (t *testRaftHandler) Snapshot() error {
+	return nil
+}
+
+func (t *testRaftHandler) Compact() error {
+	return nil
 }
 
 type testStore struct {
-	m map[string]string
+	m   map[string]string
+	err error
 }
 
 func newTestStore() *testStore {
@@ -58,8 +320,9 @@ func newTestStore() *testStore {
 	}
 }
 
-func (t *testStore) Get(key string) string {
-	return t.m[key]
+func (t *testStore) Get(key string) (string, bool) {
+	value, ok := t.m[key]
+	return value, ok
 }
 
 func (t *testStore) Keys() []string {
@@ -67,15 +330,37 @@ func (t *testStore) Keys() []string {
 }
 
 func (t *testStore) Set(key, value string) error {
+	if t.err != nil {
+		return t.err
+	}
 	t.m[key] = value
 	return nil
 }
 
 func (t *testStore) Delete(key string) error {
+	if t.err != nil {
+		return t.err
+	}
 	delete(t.m, key)
 	return nil
 }
 
+func (t *testStore) Incr(incr store.Incr) (int64, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	n, err := strconv.ParseInt(t.m[incr.Key], 10, 64)
+	if err != nil && t.m[incr.Key] != "" {
+		return 0, store.ErrNotInteger
+	}
+	n += incr.Delta
+	if (incr.Min != nil && n < *incr.Min) || (incr.Max != nil && n > *incr.Max) {
+		return 0, store.ErrOutOfRange
+	}
+	t.m[incr.Key] = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
 func getKey(t *testing.T, url, key string) string {
 	resp, err := resty.New().R().
 		Get(fmt.Sprintf("%s/keys/%s", url, key))
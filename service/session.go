@@ -0,0 +1,265 @@
+package service
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/naveen246/kvdb/store"
+	"net/http"
+	"time"
+)
+
+// Sessions is implemented by KV stores supporting sessions and the locks bound to them.
+type Sessions interface {
+	// CreateSession creates a session expiring after ttl unless it is renewed, via distributed consensus.
+	CreateSession(ttl time.Duration) (store.Session, error)
+
+	// RenewSession extends the session to its TTL from now, via distributed consensus.
+	RenewSession(id string) (store.Session, error)
+
+	// DestroySession destroys the session and releases its locks, via distributed consensus.
+	DestroySession(id string) error
+
+	// Session returns the session with the given ID and whether it exists.
+	Session(id string) (store.Session, bool)
+
+	// Lock locks the key for the session and sets it to value, via distributed consensus, and returns
+	// the fencing token of the lock.
+	Lock(key, value, sessionID string) (uint64, error)
+
+	// Unlock releases the lock on the key held by the session and deletes the key, via distributed consensus.
+	Unlock(key, sessionID string) error
+
+	// Holder returns the lock on the key and whether the key is locked.
+	Holder(key string) (store.Lock, bool)
+}
+
+// sessionJSON is a session as returned by the HTTP API
+type sessionJSON struct {
+	ID        string    `json:"id"`
+	TTL       string    `json:"ttl"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func newSessionJSON(session store.Session) sessionJSON {
+	return sessionJSON{ID: session.ID, TTL: session.TTL.String(), ExpiresAt: time.Unix(0, session.ExpiresAt).UTC()}
+}
+
+// lockJSON is a lock as returned by the HTTP API
+type lockJSON struct {
+	Key     string `json:"key"`
+	Session string `json:"session"`
+	Token   uint64 `json:"token"`
+}
+
+var (
+	sessionSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"id":        stringSchema,
+			"ttl":       {Type: "string", Description: "Time to live of the session after its last renewal, such as 10s"},
+			"expiresAt": {Type: "string", Description: "RFC 3339 expiry time of the session"},
+		},
+	}
+
+	lockSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"key":     stringSchema,
+			"session": {Type: "string", Description: "ID of the session holding the lock"},
+			"token":   {Type: "integer", Description: "Fencing token, the raft index of the acquisition of the lock"},
+		},
+	}
+)
+
+// sessionRoutes returns the operations of the HTTP API on sessions and locks
+func (s *Service) sessionRoutes() []route {
+	return []route{
+		// curl -X POST localhost:11001/v1/sessions -d '{"ttl":"10s"}'
+		{
+			method: http.MethodPost, path: "/sessions", operationID: "createSession", summary: "Create a session",
+			body: &Schema{
+				Type:       "object",
+				Properties: map[string]*Schema{"ttl": {Type: "string", Description: "Time to live of the session, such as 10s"}},
+				Required:   []string{"ttl"},
+			},
+			responses: map[int]response{http.StatusCreated: {description: "The session", schema: sessionSchema}},
+			handler:   s.CreateSession,
+		},
+		// curl localhost:11001/v1/sessions/8c1f...
+		{
+			method: http.MethodGet, path: "/sessions/:id", operationID: "getSession", summary: "Get a session",
+			responses: map[int]response{http.StatusOK: {description: "The session", schema: sessionSchema}},
+			handler:   s.GetSession,
+		},
+		// curl -X POST localhost:11001/v1/sessions/8c1f.../renew
+		{
+			method: http.MethodPost, path: "/sessions/:id/renew", operationID: "renewSession",
+			summary:   "Renew a session, extending it to its TTL from now",
+			responses: map[int]response{http.StatusOK: {description: "The session", schema: sessionSchema}},
+			handler:   s.RenewSession,
+		},
+		// curl -X DELETE localhost:11001/v1/sessions/8c1f...
+		{
+			method: http.MethodDelete, path: "/sessions/:id", operationID: "destroySession",
+			summary:   "Destroy a session, releasing its locks",
+			responses: map[int]response{http.StatusNoContent: {description: "The session was destroyed"}},
+			handler:   s.DestroySession,
+		},
+		// curl -X POST localhost:11001/v1/locks/leader -d '{"session":"8c1f...","value":"worker1"}'
+		{
+			method: http.MethodPost, path: "/locks/:key", operationID: "lockKey",
+			summary: "Lock a key for a session and set it to a value",
+			body: &Schema{
+				Type: "object",
+				Properties: map[string]*Schema{
+					"session": {Type: "string", MinLength: 1},
+					"value":   stringSchema,
+				},
+				Required: []string{"session"},
+			},
+			responses: map[int]response{http.StatusOK: {description: "The lock", schema: lockSchema}},
+			handler:   s.LockKey,
+		},
+		// curl localhost:11001/v1/locks/leader
+		{
+			method: http.MethodGet, path: "/locks/:key", operationID: "getLock", summary: "Get the lock on a key",
+			responses: map[int]response{http.StatusOK: {description: "The lock", schema: lockSchema}},
+			handler:   s.GetLock,
+		},
+		// curl -X POST localhost:11001/v1/locks/leader/unlock -d '{"session":"8c1f..."}'
+		{
+			method: http.MethodPost, path: "/locks/:key/unlock", operationID: "unlockKey",
+			summary: "Release the lock on a key held by a session and delete the key",
+			body: &Schema{
+				Type:       "object",
+				Properties: map[string]*Schema{"session": {Type: "string", MinLength: 1}},
+				Required:   []string{"session"},
+			},
+			responses: map[int]response{http.StatusNoContent: {description: "The lock was released"}},
+			handler:   s.UnlockKey,
+		},
+	}
+}
+
+// ************** Sessions *********************************//
+
+// CreateSession creates a session with the TTL of the request body, a duration such as "10s"
+func (s *Service) CreateSession(c *gin.Context) {
+	var body = struct {
+		TTL string `json:"ttl"`
+	}{}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return
+	}
+	ttl, err := time.ParseDuration(body.TTL)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return
+	}
+	if ttl < store.MinSessionTTL || ttl > store.MaxSessionTTL {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid,
+			"ttl must be between "+store.MinSessionTTL.String()+" and "+store.MaxSessionTTL.String())
+		return
+	}
+
+	session, err := s.kv.(Sessions).CreateSession(ttl)
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, newSessionJSON(session))
+}
+
+// GetSession returns the session, 404 Not Found if it doesn't exist or has expired
+func (s *Service) GetSession(c *gin.Context) {
+	session, ok := s.kv.(Sessions).Session(c.Param("id"))
+	if !ok {
+		s.abortWithStoreError(c, store.ErrSessionNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, newSessionJSON(session))
+}
+
+// RenewSession is the heartbeat keeping a session alive, it extends the session to its TTL from now
+func (s *Service) RenewSession(c *gin.Context) {
+	session, err := s.kv.(Sessions).RenewSession(c.Param("id"))
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, newSessionJSON(session))
+}
+
+// DestroySession destroys the session, releasing its locks
+func (s *Service) DestroySession(c *gin.Context) {
+	err := s.kv.(Sessions).DestroySession(c.Param("id"))
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ************** Locks *********************************//
+
+// LockKey locks the key for the session of the request body and sets it to the value of the body.
+// Keys locked by another session answer 409 Conflict.
+func (s *Service) LockKey(c *gin.Context) {
+	var body = struct {
+		Session string `json:"session"`
+		Value   string `json:"value"`
+	}{}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return
+	}
+
+	key := c.Param("key")
+	if !s.checkKeyValue(c, key, body.Value) {
+		return
+	}
+	token, err := s.kv.(Sessions).Lock(key, body.Value, body.Session)
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, lockJSON{Key: key, Session: body.Session, Token: token})
+}
+
+// UnlockKey releases the lock on the key held by the session of the request body and deletes the key
+func (s *Service) UnlockKey(c *gin.Context) {
+	var body = struct {
+		Session string `json:"session"`
+	}{}
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return
+	}
+
+	if !validKey(c, c.Param("key")) {
+		return
+	}
+	err = s.kv.(Sessions).Unlock(c.Param("key"), body.Session)
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GetLock returns the lock on the key, 404 Not Found if the key is not locked
+func (s *Service) GetLock(c *gin.Context) {
+	key := c.Param("key")
+	if !validKey(c, key) {
+		return
+	}
+	lock, ok := s.kv.(Sessions).Holder(key)
+	if !ok {
+		abortWithError(c, http.StatusNotFound, CodeNotFound, "key "+key+" is not locked")
+		return
+	}
+	c.JSON(http.StatusOK, lockJSON{Key: key, Session: lock.Session, Token: lock.Token})
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/naveen246/kvdb/store"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test_Sessions tests the session and lock routes, and that they are only served by stores supporting sessions.
+func Test_Sessions(t *testing.T) {
+	router := New(DefaultHTTPAddr, newTestStore(), &testRaftHandler{}).router()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/sessions", strings.NewReader(`{"ttl":"10s"}`)))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	kv := newTestSessionStore()
+	router = New(DefaultHTTPAddr, kv, &testRaftHandler{}).router()
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/v1/sessions", `{"ttl":"10"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/v1/sessions", `{"ttl":"100ms"}`).Code)
+
+	rec = request(http.MethodPost, "/v1/sessions", `{"ttl":"10s"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	var session sessionJSON
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &session))
+	assert.Equal(t, "s1", session.ID)
+	assert.Equal(t, "10s", session.TTL)
+
+	rec = request(http.MethodPost, "/v1/locks/leader", `{"session":"s1","value":"worker1"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"key":"leader","session":"s1","token":1}`, rec.Body.String())
+	assert.Equal(t, "worker1", kv.m["leader"])
+
+	rec = request(http.MethodPost, "/v1/locks/leader", `{"session":"s2","value":"worker2"}`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/v1/locks/leader", `{"value":"worker2"}`).Code)
+
+	rec = request(http.MethodGet, "/v1/locks/leader", "")
+	assert.JSONEq(t, `{"key":"leader","session":"s1","token":1}`, rec.Body.String())
+
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/v1/sessions/s1/renew", "").Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/v1/sessions/s2/renew", "").Code)
+
+	assert.Equal(t, http.StatusConflict, request(http.MethodPost, "/v1/locks/leader/unlock", `{"session":"s2"}`).Code)
+	assert.Equal(t, http.StatusNoContent, request(http.MethodPost, "/v1/locks/leader/unlock", `{"session":"s1"}`).Code)
+	assert.Equal(t, http.StatusNotFound, request(http.MethodGet, "/v1/locks/leader", "").Code)
+
+	assert.Equal(t, http.StatusNoContent, request(http.MethodDelete, "/v1/sessions/s1", "").Code)
+	rec = request(http.MethodGet, "/v1/sessions/s1", "")
+	assert.JSONEq(t, `{"error":{"code":"not_found","message":"session not found"}}`, rec.Body.String())
+}
+
+// testSessionStore is a testStore supporting sessions, that never expire
+type testSessionStore struct {
+	*testStore
+	sessions map[string]store.Session
+	locks    map[string]store.Lock
+	index    uint64
+}
+
+func newTestSessionStore() *testSessionStore {
+	return &testSessionStore{
+		testStore: newTestStore(),
+		sessions:  make(map[string]store.Session),
+		locks:     make(map[string]store.Lock),
+	}
+}
+
+func (t *testSessionStore) CreateSession(ttl time.Duration) (store.Session, error) {
+	session := store.Session{ID: fmt.Sprintf("s%d", len(t.sessions)+1), TTL: ttl, ExpiresAt: time.Now().Add(ttl).UnixNano()}
+	t.sessions[session.ID] = session
+	return session, nil
+}
+
+func (t *testSessionStore) RenewSession(id string) (store.Session, error) {
+	session, ok := t.sessions[id]
+	if !ok {
+		return store.Session{}, store.ErrSessionNotFound
+	}
+	return session, nil
+}
+
+func (t *testSessionStore) DestroySession(id string) error {
+	if _, ok := t.sessions[id]; !ok {
+		return store.ErrSessionNotFound
+	}
+	delete(t.sessions, id)
+	return nil
+}
+
+func (t *testSessionStore) Session(id string) (store.Session, bool) {
+	session, ok := t.sessions[id]
+	return session, ok
+}
+
+func (t *testSessionStore) Lock(key, value, sessionID string) (uint64, error) {
+	lock, ok := t.locks[key]
+	if ok && lock.Session != sessionID {
+		return 0, store.ErrLocked
+	}
+	if !ok {
+		t.index++
+		lock = store.Lock{Session: sessionID, Token: t.index}
+		t.locks[key] = lock
+	}
+	t.m[key] = value
+	return lock.Token, nil
+}
+
+func (t *testSessionStore) Unlock(key, sessionID string) error {
+	lock, ok := t.locks[key]
+	if !ok || lock.Session != sessionID {
+		return store.ErrNotLockHolder
+	}
+	delete(t.locks, key)
+	delete(t.m, key)
+	return nil
+}
+
+func (t *testSessionStore) Holder(key string) (store.Lock, bool) {
+	lock, ok := t.locks[key]
+	return lock, ok
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"cmp"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/naveen246/kvdb/store"
+	"net/http"
+)
+
+// txnJSON is a transaction in the HTTP API. If all of Compares hold, the Success operations are applied,
+// otherwise the Failure operations are.
+type txnJSON struct {
+	Compares []compareJSON `json:"compares"`
+	Success  []txnOpJSON   `json:"success"`
+	Failure  []txnOpJSON   `json:"failure"`
+}
+
+// compareJSON is a condition of a transaction, see store.Compare
+type compareJSON struct {
+	Key      string `json:"key"`
+	Value    string `json:"value"`
+	Exists   bool   `json:"exists"`
+	Revision uint64 `json:"revision"`
+}
+
+// txnOpJSON is an operation of a transaction, Op is set or delete
+type txnOpJSON struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// eventJSON is a change streamed to watchers, Type is set or delete
+type eventJSON struct {
+	Type  string `json:"type"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Index uint64 `json:"index"`
+}
+
+var (
+	txnOpSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"op":    {Type: "string", Description: "set or delete", MinLength: 1},
+			"key":   stringSchema,
+			"value": {Type: "string", Description: "Value of set operations"},
+		},
+		Required: []string{"op", "key"},
+	}
+
+	txnSchema = &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"compares": {
+				Type:        "array",
+				Description: "Conditions that must all hold for the success operations to be applied",
+				Items: &Schema{
+					Type: "object",
+					Properties: map[string]*Schema{
+						"key":      stringSchema,
+						"value":    {Type: "string", Description: "Value the key must be set to if exists is true"},
+						"exists":   {Type: "boolean", Description: "Whether the key must be set, to value"},
+						"revision": {Type: "integer", Description: "If set, raft index of the last change the key must have"},
+					},
+					Required: []string{"key"},
+				},
+			},
+			"success": {Type: "array", Description: "Operations applied if the compares hold", Items: txnOpSchema},
+			"failure": {Type: "array", Description: "Operations applied otherwise", Items: txnOpSchema},
+		},
+	}
+
+	eventSchema = &Schema{
+		Type:        "object",
+		Description: "One JSON object per line, the last line is an error object if the watch is ended by the server",
+		Properties: map[string]*Schema{
+			"type":  {Type: "string", Description: "set or delete"},
+			"key":   stringSchema,
+			"value": stringSchema,
+			"index": {Type: "integer", Description: "Raft index of the change, shared by the changes of a transaction"},
+		},
+	}
+)
+
+// txnRoutes returns the transaction route of the HTTP API
+func (s *Service) txnRoutes() []route {
+	return []route{
+		// curl -X POST localhost:11001/v1/txn -d '{"compares":[{"key":"a","exists":true,"value":"1"}],"success":[{"op":"set","key":"b","value":"2"}]}'
+		{
+			method: http.MethodPost, path: "/txn", operationID: "txn", summary: "Apply a transaction atomically",
+			body: txnSchema,
+			responses: map[int]response{http.StatusOK: {
+				description: "Whether the compares held, and the success operations were applied",
+				schema:      &Schema{Type: "object", Properties: map[string]*Schema{"succeeded": {Type: "boolean"}}},
+			}},
+			handler: s.Txn,
+		},
+	}
+}
+
+// watchRoutes returns the watch route of the HTTP API
+func (s *Service) watchRoutes() []route {
+	return []route{
+		// curl -N 'localhost:11001/v1/watch?prefix=a/'
+		{
+			method: http.MethodGet, path: "/watch", operationID: "watch",
+			summary: "Stream the changes to the keys starting with a prefix as they are applied on the node",
+			query: []parameter{{
+				name:        "prefix",
+				description: "Prefix of the watched keys, all keys if not set",
+				schema:      stringSchema,
+			}},
+			responses: map[int]response{http.StatusOK: {description: "The changes, as JSON lines", schema: eventSchema}},
+			handler:   s.Watch,
+		},
+	}
+}
+
+// Txn applies the transaction of the request body and returns whether its compares held
+func (s *Service) Txn(c *gin.Context) {
+	var body txnJSON
+	err := c.ShouldBindJSON(&body)
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, CodeInvalid, err.Error())
+		return
+	}
+	if !s.checkBatch(c, len(body.Success)+len(body.Failure)) {
+		return
+	}
+
+	txn := store.Txn{}
+	for _, compare := range body.Compares {
+		if !s.checkKey(c, compare.Key) {
+			return
+		}
+		txn.Compares = append(txn.Compares, store.Compare{
+			Key: storeKey(c, compare.Key), Value: compare.Value, Exists: compare.Exists, Revision: compare.Revision,
+		})
+	}
+	txn.Success = s.txnOps(c, body.Success)
+	if c.IsAborted() {
+		return
+	}
+	txn.Failure = s.txnOps(c, body.Failure)
+	if c.IsAborted() {
+		return
+	}
+
+	succeeded, err := s.kv.(Transactional).Txn(txn)
+	if err != nil {
+		s.abortWithStoreError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"succeeded": succeeded})
+}
+
+// txnOps returns the store operations of ops, aborting the request if one of them is invalid
+func (s *Service) txnOps(c *gin.Context, ops []txnOpJSON) []store.TxnOp {
+	var result []store.TxnOp
+	for _, op := range ops {
+		if !s.checkKeyValue(c, op.Key, op.Value) {
+			return nil
+		}
+		switch op.Op {
+		case "set":
+			result = append(result, store.TxnOp{Op: store.CmdSet, Key: storeKey(c, op.Key), Value: op.Value})
+		case "delete":
+			result = append(result, store.TxnOp{Op: store.CmdDelete, Key: storeKey(c, op.Key)})
+		default:
+			abortWithError(c, http.StatusBadRequest, CodeInvalid, "transaction op must be set or delete, not "+op.Op)
+			return nil
+		}
+	}
+	return result
+}
+
+// Watch streams the changes to the keys starting with the prefix query parameter as JSON lines, until the
+// client goes away or the service shuts down. If the watcher falls behind, the stream ends with a conflict
+// error line and the client has to read the keys again.
+func (s *Service) Watch(c *gin.Context) {
+	ns := cmp.Or(c.Param("ns"), store.DefaultNamespace)
+	if !validKey(c, c.Query("prefix")) {
+		return
+	}
+	events, cancel := s.kv.(Watchable).Watch(storeKey(c, c.Query("prefix")))
+	defer cancel()
+
+	// Sending the headers tells the client that the changes are now watched
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	encoder := json.NewEncoder(c.Writer)
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-s.closing:
+			return
+		case event, ok := <-events:
+			if !ok {
+				encoder.Encode(ErrorResponse{Error: Error{
+					Code:    CodeConflict,
+					Message: "watcher fell behind, read the keys again and restart the watch",
+				}})
+				return
+			}
+
+			// The keys of other namespaces start with the prefix when watching the default namespace
+			eventNS, key := store.SplitKey(event.Key)
+			if eventNS != ns {
+				continue
+			}
+			eventType := "set"
+			if event.Type == store.CmdDelete {
+				eventType = "delete"
+			}
+			err := encoder.Encode(eventJSON{Type: eventType, Key: key, Value: event.Value, Index: event.Index})
+			if err != nil {
+				return
+			}
+			// Flush once the events already buffered are written
+			if len(events) == 0 {
+				c.Writer.Flush()
+			}
+		}
+	}
+}
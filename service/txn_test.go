@@ -0,0 +1,73 @@
+package service
+
+import (
+	"bufio"
+	"github.com/naveen246/kvdb/store"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test_Txn tests that transactions are applied and that invalid operations are rejected.
+func Test_Txn(t *testing.T) {
+	kv := newTestTxnStore()
+	kv.m["a"] = "1"
+	router := New(DefaultHTTPAddr, kv, &testRaftHandler{}).router()
+	txn := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/txn", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := txn(`{"compares":[{"key":"a","exists":true,"value":"1"}],"success":[{"op":"set","key":"b","value":"2"},{"op":"delete","key":"a"}]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"succeeded":true}`, rec.Body.String())
+	assert.Equal(t, store.Txn{
+		Compares: []store.Compare{{Key: "a", Value: "1", Exists: true}},
+		Success:  []store.TxnOp{{Op: store.CmdSet, Key: "b", Value: "2"}, {Op: store.CmdDelete, Key: "a"}},
+	}, kv.txns[0])
+
+	rec = txn(`{"compares":[{"key":"a","exists":true,"value":"2"}],"failure":[{"op":"set","key":"c","value":"3"}]}`)
+	assert.JSONEq(t, `{"succeeded":false}`, rec.Body.String())
+
+	rec = txn(`{"success":[{"op":"incr","key":"a"}]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.JSONEq(t, `{"error":{"code":"invalid","message":"transaction op must be set or delete, not incr"}}`, rec.Body.String())
+	assert.Equal(t, http.StatusBadRequest, txn(`{"success":[{"key":"a"}]}`).Code)
+	assert.Len(t, kv.txns, 2)
+}
+
+// Test_Watch tests that the changes to the watched keys are streamed as JSON lines.
+func Test_Watch(t *testing.T) {
+	kv := newTestTxnStore()
+	server := httptest.NewServer(New(DefaultHTTPAddr, kv, &testRaftHandler{}).router())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/v1/watch?prefix=a/")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	// The watch is registered once the headers are received
+	_, err = kv.Txn(store.Txn{Success: []store.TxnOp{
+		{Op: store.CmdSet, Key: "a/1", Value: "v1"},
+		{Op: store.CmdDelete, Key: "a/2"},
+		// Keys of other namespaces are not streamed to watchers of the default namespace
+		{Op: store.CmdSet, Key: store.NamespacedKey("tenant", "a/3"), Value: "v3"},
+	}})
+	assert.NoError(t, err)
+	close(kv.watches[0])
+
+	lines := bufio.NewScanner(resp.Body)
+	var events []string
+	for lines.Scan() {
+		events = append(events, lines.Text())
+	}
+	assert.Len(t, events, 3)
+	assert.JSONEq(t, `{"type":"set","key":"a/1","value":"v1","index":0}`, events[0])
+	assert.JSONEq(t, `{"type":"delete","key":"a/2","index":0}`, events[1])
+	assert.JSONEq(t, `{"error":{"code":"conflict","message":"watcher fell behind, read the keys again and restart the watch"}}`, events[2])
+}
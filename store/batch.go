@@ -0,0 +1,187 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/raft"
+	"time"
+)
+
+const (
+	// DefaultMaxBatchSize is the maximum number of writes coalesced into one raft log entry by a new Store
+	DefaultMaxBatchSize = 128
+	// DefaultMaxBatchBytes is the maximum size of the keys and values coalesced into one raft log entry by
+	// a new Store
+	DefaultMaxBatchBytes = 4 << 20
+)
+
+// batchOp is a write waiting to join a batch, done receives its result
+type batchOp struct {
+	cmd  command
+	done chan batchResult
+}
+
+// batchResult is the response of the FSM to a write of a batch, or the error applying the batch
+type batchResult struct {
+	resp interface{}
+	err  error
+}
+
+// batch applies c in a batch with the concurrent writes, via distributed consensus, and returns the response
+// of the FSM to c or the error it responded with. Writes are applied directly if batching is disabled.
+func (s *Store) batch(c command) (interface{}, error) {
+	if s.MaxBatchSize <= 1 {
+		return s.propose(c)
+	}
+	if s.raft.State() != raft.Leader {
+		return nil, ErrNotLeader
+	}
+	err := s.checkVersion(c)
+	if err != nil {
+		return nil, err
+	}
+
+	op := &batchOp{cmd: c, done: make(chan batchResult, 1)}
+	select {
+	case s.batchCh <- op:
+	case <-s.shutdownCh:
+		return nil, raft.ErrRaftShutdown
+	}
+	result := <-op.done
+	return result.resp, result.err
+}
+
+// runBatches coalesces the writes sent to s.batchCh into batches and hands them to raft in order. The writes
+// arriving while raft takes a batch form the next one.
+func (s *Store) runBatches() {
+	var next *batchOp
+	for {
+		first := next
+		if first == nil {
+			select {
+			case first = <-s.batchCh:
+			case <-s.shutdownCh:
+				return
+			}
+		}
+		var batch []*batchOp
+		batch, next = s.collectBatch(first)
+		s.applyBatch(batch)
+	}
+}
+
+// collectBatch returns a batch of up to s.MaxBatchSize writes starting with first, whose keys and values add up
+// to at most s.MaxBatchBytes unless first alone is larger. The batch takes the writes already waiting, then those
+// arriving within s.BatchWindow of first. The write that would take the batch over s.MaxBatchBytes is returned
+// as next, to start the next batch.
+func (s *Store) collectBatch(first *batchOp) (batch []*batchOp, next *batchOp) {
+	batch = []*batchOp{first}
+	size := first.cmd.size()
+	var window <-chan time.Time
+	if s.BatchWindow > 0 {
+		timer := time.NewTimer(s.BatchWindow)
+		defer timer.Stop()
+		window = timer.C
+	}
+
+	for len(batch) < s.MaxBatchSize {
+		var op *batchOp
+		if window == nil {
+			select {
+			case op = <-s.batchCh:
+			default:
+				return batch, nil
+			}
+		} else {
+			select {
+			case op = <-s.batchCh:
+			case <-window:
+				return batch, nil
+			case <-s.shutdownCh:
+				return batch, nil
+			}
+		}
+
+		size += op.cmd.size()
+		if s.MaxBatchBytes > 0 && size > s.MaxBatchBytes {
+			return batch, op
+		}
+		batch = append(batch, op)
+	}
+	return batch, nil
+}
+
+// size returns the number of bytes of the key and value of c
+func (c command) size() int {
+	return len(c.Key) + len(c.Value)
+}
+
+// applyBatch applies the writes of batch in one raft log entry, sending each write its result once the entry
+// is applied. A batch of one write is applied as the write itself.
+func (s *Store) applyBatch(batch []*batchOp) {
+	c := batch[0].cmd
+	if len(batch) > 1 {
+		c = command{Op: CmdBatch, Batch: make([]command, len(batch))}
+		for i, op := range batch {
+			c.Batch[i] = op.cmd
+		}
+	}
+
+	cmd, err := json.Marshal(c)
+	if err != nil {
+		for _, op := range batch {
+			op.done <- batchResult{err: err}
+		}
+		return
+	}
+	// raft.Apply returns once the leader takes the entry, the next batch is collected while it is committed
+	f := s.raft.Apply(cmd, raftTimeout)
+	go finishBatch(batch, f)
+}
+
+// finishBatch waits for the entry of batch to be applied and sends each write its result
+func finishBatch(batch []*batchOp, f raft.ApplyFuture) {
+	err := f.Error()
+	resps := f.Response()
+	if respErr, ok := resps.(error); ok && err == nil {
+		// the whole entry was rejected, such as a batch quarantined by the FSM
+		err = respErr
+	}
+	for i, op := range batch {
+		if err != nil {
+			op.done <- batchResult{err: err}
+			continue
+		}
+		resp := resps
+		if len(batch) > 1 {
+			resp = resps.([]interface{})[i]
+		}
+		if err, ok := resp.(error); ok {
+			op.done <- batchResult{err: err}
+			continue
+		}
+		op.done <- batchResult{resp: resp}
+	}
+}
+
+// applyCommand applies c via raft and returns the response of the FSM
+func (s *Store) applyCommand(c command) (interface{}, error) {
+	cmd, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	f := s.raft.Apply(cmd, raftTimeout)
+	if f.Error() != nil {
+		return nil, f.Error()
+	}
+	return f.Response(), nil
+}
+
+// applyBatchCommand applies the writes of a batch in order, each independently of the others, and returns
+// their responses
+func (f *fsm) applyBatchCommand(index uint64, batch []command) interface{} {
+	resps := make([]interface{}, len(batch))
+	for i, c := range batch {
+		resps[i] = f.apply(index, c)
+	}
+	return resps
+}
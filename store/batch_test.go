@@ -0,0 +1,147 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test_BatchApply tests that the writes of a batch are applied in order, each with its own result.
+func Test_BatchApply(t *testing.T) {
+	s := NewStore()
+	batch := []command{
+		{Op: CmdSet, Key: "k1", Value: "v1"},
+		{Op: CmdSet, Key: NamespacedKey("missing", "k2"), Value: "v2"},
+		{Op: CmdSet, Key: "k1", Value: "v2"},
+		{Op: CmdDelete, Key: "k3"},
+	}
+	resps := applyCommand(t, s, 7, command{Op: CmdBatch, Batch: batch}).([]interface{})
+
+	assert.Len(t, resps, 4)
+	assert.Nil(t, resps[0])
+	assert.ErrorIs(t, resps[1].(error), ErrNamespaceNotFound)
+	assert.Nil(t, resps[2])
+	assert.Nil(t, resps[3])
+	item, _ := s.Item("k1")
+	assert.Equal(t, Item{Value: "v2", Revision: 7}, item)
+}
+
+// Test_StoreBatch tests that concurrent writes are coalesced into fewer raft log entries.
+func Test_StoreBatch(t *testing.T) {
+	s := openBatchStore(t, DefaultMaxBatchSize, 10*time.Millisecond)
+	first := s.raft.LastIndex()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, s.Set("k"+strconv.Itoa(i), "v"))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, s.Keys(), 100)
+	assert.Less(t, s.raft.LastIndex()-first, uint64(50))
+
+	err := s.Delete("k1")
+	assert.NoError(t, err)
+	_, ok := s.Get("k1")
+	assert.False(t, ok)
+	assert.ErrorIs(t, s.Set(NamespacedKey("missing", "k1"), "v"), ErrNamespaceNotFound)
+}
+
+// Test_StoreBatchBytes tests that batches of large values are closed before their keys and values add up to
+// more than MaxBatchBytes.
+func Test_StoreBatchBytes(t *testing.T) {
+	s := openBatchStore(t, DefaultMaxBatchSize, 10*time.Millisecond)
+	s.MaxBatchBytes = 1 << 20
+	first := s.raft.LastIndex()
+
+	value := strings.Repeat("v", 400<<10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.NoError(t, s.Set("k"+strconv.Itoa(i), value))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, s.Keys(), 8)
+	last := s.raft.LastIndex()
+	assert.GreaterOrEqual(t, last-first, uint64(4))
+	for index := first + 1; index <= last; index++ {
+		var l raft.Log
+		assert.NoError(t, s.boltStore.GetLog(index, &l))
+		var c command
+		assert.NoError(t, json.Unmarshal(l.Data, &c))
+		assert.LessOrEqual(t, len(c.Batch), 2)
+		assert.Less(t, len(l.Data), s.MaxBatchBytes+1024)
+	}
+}
+
+// openBatchStore opens a single node store batching writes and waits for it to become leader
+func openBatchStore(tb testing.TB, maxBatchSize int, window time.Duration) *Store {
+	dir, err := os.MkdirTemp("", "kvdb-batch")
+	assert.NoError(tb, err)
+	tb.Cleanup(func() { os.RemoveAll(dir) })
+
+	s := NewStore()
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = dir
+	s.MaxBatchSize = maxBatchSize
+	s.BatchWindow = window
+	err = s.Open(true, "node1")
+	assert.NoError(tb, err)
+	tb.Cleanup(func() { s.Close(false) })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.raft.State() != raft.Leader {
+		if time.Now().After(deadline) {
+			tb.Fatal("no leader")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return s
+}
+
+// BenchmarkStoreSet measures the throughput of concurrent writes without and with batching
+func BenchmarkStoreSet(b *testing.B) {
+	for _, bench := range []struct {
+		name         string
+		maxBatchSize int
+	}{
+		{"Unbatched", 1},
+		{"Batched", DefaultMaxBatchSize},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			s := openBatchStore(b, bench.maxBatchSize, 0)
+			b.SetParallelism(16)
+			b.ResetTimer()
+
+			var n sync.Mutex
+			i := 0
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					n.Lock()
+					key := fmt.Sprintf("k%d", i)
+					i++
+					n.Unlock()
+					err := s.Set(key, "value")
+					if err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
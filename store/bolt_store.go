@@ -3,20 +3,55 @@ package store
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"github.com/armon/go-metrics"
 	"github.com/hashicorp/raft"
 	"go.etcd.io/bbolt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 )
 
-const fileMode = 0666
+const (
+	fileMode = 0666
+
+	// compactTxMaxSize is the maximum size of a single transaction used to copy data while compacting the db
+	compactTxMaxSize = 64 << 20
+
+	// bulkTruncateMaxKeep is the maximum number of logs DeleteRange will copy to a fresh bucket
+	// when truncating a prefix of the log. Above this it falls back to deleting keys one by one.
+	bulkTruncateMaxKeep = 1 << 16
+)
 
 var (
 	// logBucket is the name of bucket in boltDB used by raft.LogStore methods for storing raft logs
 	logBucket = []byte("logBucket")
 	// storeBucket is the name of bucket in boltDB used by raft.StableStore methods for storing key configurations
 	storeBucket = []byte("storeBucket")
+	// metaBucket is the name of bucket in boltDB holding the format of the db
+	metaBucket = []byte("metaBucket")
+	// logFormatKey is set in metaBucket to checksummedFormat once every record of logBucket ends with a checksum
+	logFormatKey = []byte("logFormat")
 
 	ErrKeyNotFound = errors.New("not found")
 	ErrCorrupt     = errors.New("corrupt")
+
+	// ErrStoreClosed is returned by Compact when neither the compacted nor the original db could be reopened
+	ErrStoreClosed = errors.New("bolt store closed")
+
+	// crcTable is the Castagnoli table used to checksum log records
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+const (
+	// logHeaderLen is the length of the fixed-size header of a log record (index, term, type, data length)
+	logHeaderLen = 25
+	// checksumLen is the length of the CRC32C trailer appended to a log record
+	checksumLen = 4
+	// checksummedFormat is the log format of dbs whose records all end with a checksum
+	checksummedFormat = 1
 )
 
 type Options struct {
@@ -35,11 +70,18 @@ func (o *Options) readOnly() bool {
 }
 
 // BoltStore wraps boltdb and implements the interfaces
-// raft.LogStore to store raft logs and
+// raft.LogStore to store raft logs,
+// raft.MonotonicLogStore since raft logs are always stored without gaps and
 // raft.StableStore for key/value storage. The interfaces are defined in hashicorp/raft library
 type BoltStore struct {
-	db   *bbolt.DB
-	path string
+	// mu guards db, which is replaced when the store is compacted
+	mu      sync.RWMutex
+	db      *bbolt.DB
+	path    string
+	options Options
+	// legacy is set for read-only dbs written before every record had a checksum, whose records are
+	// accepted without one
+	legacy bool
 }
 
 func NewBoltStore(path string) (*BoltStore, error) {
@@ -55,16 +97,23 @@ func New(options Options) (*BoltStore, error) {
 	db.NoSync = options.NoSync
 
 	store := &BoltStore{
-		db:   db,
-		path: options.Path,
+		db:      db,
+		path:    options.Path,
+		options: options,
 	}
 
-	if !options.readOnly() {
-		err := store.initialize()
-		if err != nil {
-			store.Close()
-			return nil, err
-		}
+	if options.readOnly() {
+		err = db.View(func(tx *bbolt.Tx) error {
+			meta := tx.Bucket(metaBucket)
+			store.legacy = meta == nil || meta.Get(logFormatKey) == nil
+			return nil
+		})
+	} else {
+		err = store.initialize()
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
 	}
 	return store, nil
 }
@@ -87,16 +136,60 @@ func (b *BoltStore) initialize() error {
 		return err
 	}
 
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	if meta.Get(logFormatKey) == nil {
+		err = checksumLogs(tx.Bucket(logBucket))
+		if err != nil {
+			return err
+		}
+		err = meta.Put(logFormatKey, []byte{checksummedFormat})
+		if err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
 
+// checksumLogs appends a checksum to the records of bucket written before checksums were introduced, so that
+// the checksum of every record is verified from then on. Records that can't be decoded are left for Verify
+// to report.
+func checksumLogs(bucket *bbolt.Bucket) error {
+	var keys, vals [][]byte
+	var log raft.Log
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		if len(v) >= logHeaderLen && uint64(len(v)-logHeaderLen) == binary.BigEndian.Uint64(v[17:25]) &&
+			decodeLog(v, &log, true) == nil {
+			keys = append(keys, k)
+			vals = append(vals, convertLogToBytes(&log))
+		}
+	}
+
+	for i := range keys {
+		err := bucket.Put(keys[i], vals[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Close the BoltStore db
 func (b *BoltStore) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	return b.db.Close()
 }
 
 // logCount returns the number of raft logs present in logBucket of boltDB
 func (b *BoltStore) logCount() (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	tx, err := b.db.Begin(false)
 	if err != nil {
 		return 0, err
@@ -110,6 +203,9 @@ func (b *BoltStore) logCount() (int, error) {
 
 // FirstIndex returns the first index of raft logs written. 0 for no entries.
 func (b *BoltStore) FirstIndex() (uint64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	tx, err := b.db.Begin(false)
 	if err != nil {
 		return 0, err
@@ -127,6 +223,9 @@ func (b *BoltStore) FirstIndex() (uint64, error) {
 
 // LastIndex returns the last index of raft logs written. 0 for no entries.
 func (b *BoltStore) LastIndex() (uint64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	tx, err := b.db.Begin(false)
 	if err != nil {
 		return 0, err
@@ -144,6 +243,9 @@ func (b *BoltStore) LastIndex() (uint64, error) {
 
 // GetLog gets a log entry at a given index.
 func (b *BoltStore) GetLog(idx uint64, log *raft.Log) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	tx, err := b.db.Begin(false)
 	if err != nil {
 		return err
@@ -156,7 +258,15 @@ func (b *BoltStore) GetLog(idx uint64, log *raft.Log) error {
 		return raft.ErrLogNotFound
 	}
 
-	return convertBytesToLog(val, log)
+	err = decodeLog(val, log, b.legacy)
+	if err == nil && log.Index != idx {
+		err = ErrCorrupt
+	}
+	if err != nil {
+		metrics.IncrCounter([]string{"kvdb", "boltstore", "corrupt"}, 1)
+		return fmt.Errorf("log entry at index %d: %w", idx, err)
+	}
+	return nil
 }
 
 // StoreLog stores a log entry.
@@ -166,6 +276,9 @@ func (b *BoltStore) StoreLog(log *raft.Log) error {
 
 // StoreLogs stores multiple log entries.
 func (b *BoltStore) StoreLogs(logs []*raft.Log) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	tx, err := b.db.Begin(true)
 	if err != nil {
 		return err
@@ -173,6 +286,8 @@ func (b *BoltStore) StoreLogs(logs []*raft.Log) error {
 	defer tx.Rollback()
 
 	bucket := tx.Bucket(logBucket)
+	// logs are appended in index order, so pages can be filled completely when split
+	bucket.FillPercent = 1.0
 	for _, log := range logs {
 		key := uint64ToBytes(log.Index)
 		val := convertLogToBytes(log)
@@ -186,13 +301,53 @@ func (b *BoltStore) StoreLogs(logs []*raft.Log) error {
 }
 
 // DeleteRange deletes a range of log entries. The range is inclusive.
+//
+// Deleting every log, as raft does after restoring a snapshot, drops and recreates logBucket.
+// Deleting a prefix of the log, as raft does after taking a snapshot, copies the few remaining logs
+// to a fresh bucket when that is cheaper than deleting the prefix key by key.
 func (b *BoltStore) DeleteRange(min, max uint64) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	tx, err := b.db.Begin(true)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
+	cursor := tx.Bucket(logBucket).Cursor()
+	first, _ := cursor.First()
+	last, _ := cursor.Last()
+	if first == nil {
+		return nil
+	}
+	firstIdx, lastIdx := bytesToUint64(first), bytesToUint64(last)
+
+	switch {
+	case min <= firstIdx && max >= lastIdx:
+		err = truncateAll(tx)
+	case min <= firstIdx && max >= firstIdx && lastIdx-max <= bulkTruncateMaxKeep && lastIdx-max < max-firstIdx:
+		err = truncatePrefix(tx, max)
+	default:
+		err = deleteRangeCursor(tx, min, max)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// -------------End raft.LogStore interface implementation-----------------------//
+
+// IsMonotonic implements the raft.MonotonicLogStore interface.
+// Raft removes all logs after restoring a snapshot instead of relying on a gap in log indexes.
+func (b *BoltStore) IsMonotonic() bool {
+	return true
+}
+
+// deleteRangeCursor deletes the logs in the inclusive range min to max one key at a time
+func deleteRangeCursor(tx *bbolt.Tx, min, max uint64) error {
 	minKey := uint64ToBytes(min)
 	cursor := tx.Bucket(logBucket).Cursor()
 	k, _ := cursor.Seek(minKey)
@@ -205,14 +360,53 @@ func (b *BoltStore) DeleteRange(min, max uint64) error {
 		k, _ = cursor.Next()
 	}
 
-	return tx.Commit()
+	return nil
 }
 
-// -------------End raft.LogStore interface implementation-----------------------//
+// truncateAll deletes every log by recreating logBucket, which releases all its pages at once
+func truncateAll(tx *bbolt.Tx) error {
+	err := tx.DeleteBucket(logBucket)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.CreateBucket(logBucket)
+	return err
+}
+
+// truncatePrefix deletes every log up to and including max by copying the logs after max
+// into a recreated logBucket
+func truncatePrefix(tx *bbolt.Tx, max uint64) error {
+	var keys, vals [][]byte
+	cursor := tx.Bucket(logBucket).Cursor()
+	for k, v := cursor.Seek(uint64ToBytes(max + 1)); k != nil; k, v = cursor.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+		vals = append(vals, append([]byte(nil), v...))
+	}
+
+	err := truncateAll(tx)
+	if err != nil {
+		return err
+	}
+
+	bucket := tx.Bucket(logBucket)
+	bucket.FillPercent = 1.0
+	for i := range keys {
+		err := bucket.Put(keys[i], vals[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
 // -------------Implement raft.StableStore interface-----------------------//
 
 func (b *BoltStore) Set(k, v []byte) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	tx, err := b.db.Begin(true)
 	if err != nil {
 		return err
@@ -228,6 +422,9 @@ func (b *BoltStore) Set(k, v []byte) error {
 }
 
 func (b *BoltStore) Get(k []byte) ([]byte, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
 	tx, err := b.db.Begin(false)
 	if err != nil {
 		return nil, err
@@ -262,17 +459,152 @@ func (b *BoltStore) GetUint64(key []byte) (uint64, error) {
 // This is not necessary under normal operation, however, if you use NoSync
 // then it allows you to force the database file to sync against the disk.
 func (b *BoltStore) Sync() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	return b.db.Sync()
 }
 
+// Compact rewrites the db into a new file holding only the pages in use and replaces the
+// original file with it, returning the space freed by deleted logs to the filesystem.
+// Reads and writes block until compaction completes.
+func (b *BoltStore) Compact() error {
+	if b.options.readOnly() {
+		return bbolt.ErrDatabaseReadOnly
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tmpPath := b.path + ".compact"
+	os.Remove(tmpPath)
+
+	dst, err := bbolt.Open(tmpPath, fileMode, b.options.BoltOptions)
+	if err != nil {
+		return err
+	}
+
+	err = bbolt.Compact(dst, b.db, compactTxMaxSize)
+	if err == nil {
+		err = dst.Sync()
+	}
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("compact %s: %w", b.path, err)
+	}
+
+	// Keep a link to the original file so it can be reopened if the compacted one can't
+	backupPath := b.path + ".orig"
+	os.Remove(backupPath)
+	linkErr := os.Link(b.path, backupPath)
+
+	err = b.db.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		os.Remove(backupPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, b.path)
+	if err == nil {
+		err = syncDir(filepath.Dir(b.path))
+	}
+	if err == nil {
+		err = b.open()
+	}
+	if err == nil {
+		os.Remove(backupPath)
+		return nil
+	}
+	os.Remove(tmpPath)
+	err = fmt.Errorf("compact %s: %w", b.path, err)
+
+	// The original file is still in place unless the rename succeeded
+	if _, statErr := os.Stat(backupPath); statErr == nil {
+		restoreErr := os.Rename(backupPath, b.path)
+		if restoreErr == nil {
+			restoreErr = syncDir(filepath.Dir(b.path))
+		}
+		if restoreErr != nil {
+			return fmt.Errorf("%w: %w, restore original: %w", ErrStoreClosed, err, restoreErr)
+		}
+	} else if linkErr != nil {
+		err = fmt.Errorf("%w, link original: %w", err, linkErr)
+	}
+	openErr := b.open()
+	if openErr != nil {
+		return fmt.Errorf("%w: %w, reopen original: %w", ErrStoreClosed, err, openErr)
+	}
+	return err
+}
+
+// open opens the db at b.path. It must be called with b.mu held.
+func (b *BoltStore) open() error {
+	db, err := bbolt.Open(b.path, fileMode, b.options.BoltOptions)
+	if err != nil {
+		return err
+	}
+	db.NoSync = b.options.NoSync
+	b.db = db
+	return nil
+}
+
+// syncDir fsyncs the directory dir so that renames of the files it holds are durable.
+// Directories can't be synced on Windows, where it does nothing.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Verify scans every record in logBucket and checks it can be decoded and that its checksum matches.
+// It returns the indexes of the corrupt records in ascending order and the total number of records scanned.
+func (b *BoltStore) Verify() (corrupt []uint64, scanned int, err error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	tx, err := b.db.Begin(false)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	var log raft.Log
+	cursor := tx.Bucket(logBucket).Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		scanned++
+		idx := bytesToUint64(k)
+		if decodeLog(v, &log, b.legacy) != nil || log.Index != idx {
+			metrics.IncrCounter([]string{"kvdb", "boltstore", "corrupt"}, 1)
+			corrupt = append(corrupt, idx)
+		}
+	}
+
+	return corrupt, scanned, nil
+}
+
 // convertLogToBytes converts raft.Log to bytes as follows
 // first 8 bytes - log.Index
 // next 8 bytes - log.Term
 // next 1 byte - log.Type
 // next 8 bytes - len(log.Data)
 // next len(log.Data) bytes - log.Data
+// last 4 bytes - CRC32C (Castagnoli) checksum of all the preceding bytes
 func convertLogToBytes(log *raft.Log) []byte {
-	buf := make([]byte, 0)
+	buf := make([]byte, 0, logHeaderLen+len(log.Data)+checksumLen)
 	var num [8]byte
 
 	binary.BigEndian.PutUint64(num[:], log.Index)
@@ -287,26 +619,46 @@ func convertLogToBytes(log *raft.Log) []byte {
 	buf = append(buf, num[:]...)
 
 	buf = append(buf, log.Data...)
+
+	binary.BigEndian.PutUint32(num[:checksumLen], crc32.Checksum(buf, crcTable))
+	buf = append(buf, num[:checksumLen]...)
 	return buf
 }
 
 // convertBytesToLog converts the given bytes to raft.Log
-// see convertLogToBytes doc to check how the raft.Log fields map to bytes
+// see convertLogToBytes doc to check how the raft.Log fields map to bytes.
 func convertBytesToLog(buf []byte, log *raft.Log) error {
-	if len(buf) < 25 {
+	return decodeLog(buf, log, false)
+}
+
+// decodeLog converts the given bytes to raft.Log like convertBytesToLog. With legacy set, it also accepts
+// records written before checksums were introduced, which have no trailer and are accepted without verification.
+func decodeLog(buf []byte, log *raft.Log, legacy bool) error {
+	if len(buf) < logHeaderLen {
+		return ErrCorrupt
+	}
+
+	dataLen := binary.BigEndian.Uint64(buf[17:25])
+	switch uint64(len(buf) - logHeaderLen) {
+	case dataLen + checksumLen:
+		body := buf[:len(buf)-checksumLen]
+		if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(buf[len(body):]) {
+			return ErrCorrupt
+		}
+	case dataLen:
+		if !legacy {
+			return ErrCorrupt
+		}
+	default:
 		return ErrCorrupt
 	}
 
 	log.Index = binary.BigEndian.Uint64(buf[0:8])
 	log.Term = binary.BigEndian.Uint64(buf[8:16])
 	log.Type = raft.LogType(buf[16])
-	dataLen := binary.BigEndian.Uint64(buf[17:25])
 
 	log.Data = make([]byte, dataLen)
-	if len(buf[25:]) < len(log.Data) {
-		return ErrCorrupt
-	}
-	copy(log.Data, buf[25:])
+	copy(log.Data, buf[logHeaderLen:])
 
 	return nil
 }
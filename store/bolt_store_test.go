@@ -1,6 +1,9 @@
 package store
 
 import (
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/assert"
 	"go.etcd.io/bbolt"
@@ -36,6 +39,10 @@ func TestBoltStore_Implements(t *testing.T) {
 
 	_, ok = store.(raft.LogStore)
 	assert.True(t, ok, "BoltStore does not implement raft.LogStore")
+
+	monotonic, ok := store.(raft.MonotonicLogStore)
+	assert.True(t, ok, "BoltStore does not implement raft.MonotonicLogStore")
+	assert.True(t, monotonic.IsMonotonic())
 }
 
 func TestBoltOpenSameDBTwice(t *testing.T) {
@@ -135,6 +142,8 @@ func TestNewBoltStore(t *testing.T) {
 	assert.ErrorIs(t, err, bbolt.ErrBucketExists)
 	_, err = tx.CreateBucket(storeBucket)
 	assert.ErrorIs(t, err, bbolt.ErrBucketExists)
+	_, err = tx.CreateBucket(metaBucket)
+	assert.ErrorIs(t, err, bbolt.ErrBucketExists)
 }
 
 func TestBoltStoreIndex(t *testing.T) {
@@ -308,3 +317,282 @@ func TestBoltStore_SetUint64_GetUint64(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, v, val)
 }
+
+func TestBoltStoreChecksumCorruption(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+		testRaftLog(3, "log3"),
+	}
+	err := store.StoreLogs(logs)
+	assert.NoError(t, err)
+
+	// Flip a bit in the data of log 2
+	err = store.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(logBucket)
+		val := append([]byte(nil), bucket.Get(uint64ToBytes(2))...)
+		val[logHeaderLen] ^= 0x01
+		return bucket.Put(uint64ToBytes(2), val)
+	})
+	assert.NoError(t, err)
+
+	// GetLog should report the corrupt entry with its index
+	err = store.GetLog(2, new(raft.Log))
+	assert.ErrorIs(t, err, ErrCorrupt)
+	assert.ErrorContains(t, err, "index 2")
+
+	// Other entries are unaffected
+	log := new(raft.Log)
+	err = store.GetLog(3, log)
+	assert.NoError(t, err)
+	assert.Equal(t, logs[2], log)
+
+	corrupt, scanned, err := store.Verify()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, scanned)
+	assert.Equal(t, []uint64{2}, corrupt)
+}
+
+func TestBoltStoreLegacyRecord(t *testing.T) {
+	store := testBoltStore(t)
+	defer os.Remove(store.path)
+
+	// Records written before checksums were added have no trailer, in dbs without log format
+	log := testRaftLog(1, "log1")
+	err := store.db.Update(func(tx *bbolt.Tx) error {
+		val := convertLogToBytes(log)
+		err := tx.Bucket(logBucket).Put(uint64ToBytes(1), val[:len(val)-checksumLen])
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Delete(logFormatKey)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, store.Close())
+
+	// Read-only stores accept them as they are
+	readOnly, err := New(Options{Path: store.path, BoltOptions: &bbolt.Options{ReadOnly: true}})
+	assert.NoError(t, err)
+	result := new(raft.Log)
+	assert.NoError(t, readOnly.GetLog(1, result))
+	assert.Equal(t, log, result)
+	assert.NoError(t, readOnly.Close())
+
+	// Opening the store checksums them
+	store, err = NewBoltStore(store.path)
+	assert.NoError(t, err)
+	defer store.Close()
+	result = new(raft.Log)
+	assert.NoError(t, store.GetLog(1, result))
+	assert.Equal(t, log, result)
+	corrupt, scanned, err := store.Verify()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, scanned)
+	assert.Empty(t, corrupt)
+
+	// A record whose data length grew by the length of the checksum is no longer taken for a legacy record
+	err = store.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(logBucket)
+		val := append([]byte(nil), bucket.Get(uint64ToBytes(1))...)
+		binary.BigEndian.PutUint64(val[17:25], uint64(len(log.Data)+checksumLen))
+		return bucket.Put(uint64ToBytes(1), val)
+	})
+	assert.NoError(t, err)
+	assert.ErrorIs(t, store.GetLog(1, new(raft.Log)), ErrCorrupt)
+}
+
+func testRaftLogs(min, max uint64) []*raft.Log {
+	logs := make([]*raft.Log, 0, max-min+1)
+	for i := min; i <= max; i++ {
+		logs = append(logs, testRaftLog(i, fmt.Sprintf("log%d", i)))
+	}
+	return logs
+}
+
+func TestBoltStoreDeleteRangePrefix(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	logs := testRaftLogs(1, 100)
+	err := store.StoreLogs(logs)
+	assert.NoError(t, err)
+
+	// Deleting most of the log from the front takes the bulk truncation path
+	err = store.DeleteRange(1, 90)
+	assert.NoError(t, err)
+
+	count, err := store.logCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 10, count)
+
+	idx, err := store.FirstIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(91), idx)
+
+	for _, want := range logs[90:] {
+		log := new(raft.Log)
+		err = store.GetLog(want.Index, log)
+		assert.NoError(t, err)
+		assert.Equal(t, want, log)
+	}
+
+	// Logs are appended after the truncation as usual
+	err = store.StoreLog(testRaftLog(101, "log101"))
+	assert.NoError(t, err)
+
+	idx, err = store.LastIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(101), idx)
+}
+
+func TestBoltStoreDeleteRangeAll(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	err := store.StoreLogs(testRaftLogs(5, 10))
+	assert.NoError(t, err)
+
+	err = store.DeleteRange(0, 100)
+	assert.NoError(t, err)
+
+	count, err := store.logCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Deleting from an empty log is a no-op
+	err = store.DeleteRange(0, 100)
+	assert.NoError(t, err)
+}
+
+func TestBoltStoreDeleteRangeSuffix(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	logs := testRaftLogs(1, 10)
+	err := store.StoreLogs(logs)
+	assert.NoError(t, err)
+
+	err = store.DeleteRange(4, 10)
+	assert.NoError(t, err)
+
+	idx, err := store.LastIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), idx)
+
+	count, err := store.logCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+}
+
+func TestBoltStoreCompact(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	logs := make([]*raft.Log, 0, 1000)
+	for i := uint64(1); i <= 1000; i++ {
+		logs = append(logs, testRaftLog(i, string(make([]byte, 1024))))
+	}
+	err := store.StoreLogs(logs)
+	assert.NoError(t, err)
+	err = store.Set([]byte("hello"), []byte("world"))
+	assert.NoError(t, err)
+
+	err = store.DeleteRange(1, 990)
+	assert.NoError(t, err)
+
+	before, err := os.Stat(store.path)
+	assert.NoError(t, err)
+
+	err = store.Compact()
+	assert.NoError(t, err)
+
+	after, err := os.Stat(store.path)
+	assert.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+
+	// Data is intact and the store stays usable
+	log := new(raft.Log)
+	err = store.GetLog(1000, log)
+	assert.NoError(t, err)
+	assert.Equal(t, logs[999], log)
+
+	val, err := store.Get([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("world"), val)
+
+	err = store.StoreLog(testRaftLog(1001, "log1001"))
+	assert.NoError(t, err)
+}
+
+// TestBoltStoreCompactReopenFails tests that the original db is reopened when the compacted one can't be opened.
+func TestBoltStoreCompactReopenFails(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	err := store.StoreLogs(testRaftLogs(1, 100))
+	assert.NoError(t, err)
+
+	failOpen := true
+	store.options.BoltOptions = &bbolt.Options{
+		OpenFile: func(name string, flag int, perm os.FileMode) (*os.File, error) {
+			if name == store.path && failOpen {
+				failOpen = false
+				return nil, errors.New("open failed")
+			}
+			return os.OpenFile(name, flag, perm)
+		},
+	}
+
+	err = store.Compact()
+	assert.ErrorContains(t, err, "open failed")
+	assert.NotErrorIs(t, err, ErrStoreClosed)
+	_, err = os.Stat(store.path + ".orig")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// The store keeps serving the original db
+	log := new(raft.Log)
+	err = store.GetLog(100, log)
+	assert.NoError(t, err)
+	err = store.StoreLog(testRaftLog(101, "log101"))
+	assert.NoError(t, err)
+}
+
+// benchmarkTruncatePrefix measures deleting the first 9000 of 10000 logs, as raft does after a snapshot
+func benchmarkTruncatePrefix(b *testing.B, truncate func(tx *bbolt.Tx) error) {
+	logs := testRaftLogs(1, 10000)
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		store := testBoltStore(b)
+		err := store.StoreLogs(logs)
+		assert.NoError(b, err)
+		b.StartTimer()
+
+		err = store.db.Update(truncate)
+		assert.NoError(b, err)
+
+		b.StopTimer()
+		store.Close()
+		os.Remove(store.path)
+	}
+}
+
+func BenchmarkBoltStoreTruncatePrefix_Cursor(b *testing.B) {
+	benchmarkTruncatePrefix(b, func(tx *bbolt.Tx) error {
+		return deleteRangeCursor(tx, 1, 9000)
+	})
+}
+
+func BenchmarkBoltStoreTruncatePrefix_Bulk(b *testing.B) {
+	benchmarkTruncatePrefix(b, func(tx *bbolt.Tx) error {
+		return truncatePrefix(tx, 9000)
+	})
+}
@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// lockFileName is the file in a data directory locked by the process using it
+	lockFileName = "LOCK"
+	// nodeIDFileName is the file in a data directory holding the ID of the node owning it
+	nodeIDFileName = "node-id"
+)
+
+var ErrDataDirLocked = errors.New("data directory is in use by another process")
+
+// DataDirLock is an exclusive lock on a node's data directory, held until Release is called or the process exits
+type DataDirLock struct {
+	file *os.File
+}
+
+// LockDataDir creates the data directory at path if needed, checks it is a writable directory
+// and locks it so that no other kvdb process can use it.
+func LockDataDir(path string) (*DataDirLock, error) {
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("data directory %s: %w", path, err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("data directory %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("data directory %s is not a directory", path)
+	}
+
+	file, err := os.OpenFile(filepath.Join(path, lockFileName), os.O_RDWR|os.O_CREATE, fileMode)
+	if err != nil {
+		return nil, fmt.Errorf("data directory %s is not writable: %w", path, err)
+	}
+
+	err = lockFile(file)
+	if err != nil {
+		file.Close()
+		if errors.Is(err, ErrDataDirLocked) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return nil, fmt.Errorf("failed to lock data directory %s: %w", path, err)
+	}
+
+	return &DataDirLock{file: file}, nil
+}
+
+// Release unlocks the data directory
+func (l *DataDirLock) Release() error {
+	return errors.Join(unlockFile(l.file), l.file.Close())
+}
+
+// ResolveNodeID returns the ID of the node owning the data directory at path.
+//
+// The first time a node starts with the directory, requestedID is recorded as its ID, or defaultID if
+// requestedID is empty. On later starts the recorded ID is returned, and requesting a different ID is
+// an error since the raft data in the directory belongs to the recorded node.
+func ResolveNodeID(path, requestedID, defaultID string) (string, error) {
+	nodeID, recorded, err := ReadNodeID(path, requestedID, defaultID)
+	if err != nil || recorded {
+		return nodeID, err
+	}
+
+	err = writeFileAtomic(filepath.Join(path, nodeIDFileName), []byte(nodeID+"\n"), true)
+	if err != nil {
+		return "", err
+	}
+	return nodeID, nil
+}
+
+// ReadNodeID returns the ID ResolveNodeID would return and whether it is recorded in the data directory
+// at path, without recording it.
+func ReadNodeID(path, requestedID, defaultID string) (nodeID string, recorded bool, err error) {
+	idPath := filepath.Join(path, nodeIDFileName)
+
+	buf, err := os.ReadFile(idPath)
+	storedID := strings.TrimSpace(string(buf))
+	// An empty file, as created by provisioning tools touching the file, records no ID
+	if err == nil && storedID != "" {
+		if requestedID != "" && requestedID != storedID {
+			return "", false, fmt.Errorf("node ID %s conflicts with ID %s recorded in %s", requestedID, storedID, idPath)
+		}
+		return storedID, true, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", false, err
+	}
+
+	nodeID = requestedID
+	if nodeID == "" {
+		nodeID = defaultID
+	}
+	return nodeID, false, nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLockDataDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "node1")
+
+	// The directory is created if missing
+	lock, err := LockDataDir(dir)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, lockFileName))
+	assert.NoError(t, err)
+
+	// A second user of the directory is refused
+	_, err = LockDataDir(dir)
+	assert.ErrorIs(t, err, ErrDataDirLocked)
+
+	// And allowed once the lock is released
+	err = lock.Release()
+	assert.NoError(t, err)
+	lock, err = LockDataDir(dir)
+	assert.NoError(t, err)
+	lock.Release()
+}
+
+// TestLockFileError tests that failures to lock are not reported as the directory being in use
+func TestLockFileError(t *testing.T) {
+	file, err := os.Create(filepath.Join(t.TempDir(), lockFileName))
+	assert.NoError(t, err)
+	file.Close()
+
+	err = lockFile(file)
+	assert.NotErrorIs(t, err, ErrDataDirLocked)
+}
+
+func TestLockDataDirNotADirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	err := os.WriteFile(path, nil, fileMode)
+	assert.NoError(t, err)
+
+	_, err = LockDataDir(path)
+	assert.Error(t, err)
+}
+
+func TestResolveNodeID(t *testing.T) {
+	dir := t.TempDir()
+
+	// Defaults to defaultID on first start
+	nodeID, err := ResolveNodeID(dir, "", "localhost:12001")
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:12001", nodeID)
+
+	// Restarts keep the recorded ID
+	nodeID, err = ResolveNodeID(dir, "", "localhost:12002")
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:12001", nodeID)
+
+	nodeID, err = ResolveNodeID(dir, "localhost:12001", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost:12001", nodeID)
+
+	// A conflicting ID is refused
+	_, err = ResolveNodeID(dir, "node2", "")
+	assert.ErrorContains(t, err, "conflicts")
+
+	// An empty ID file records no ID
+	dir = t.TempDir()
+	err = os.WriteFile(filepath.Join(dir, nodeIDFileName), nil, fileMode)
+	assert.NoError(t, err)
+	nodeID, err = ResolveNodeID(dir, "node1", "localhost:12001")
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", nodeID)
+	nodeID, err = ResolveNodeID(dir, "", "localhost:12001")
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", nodeID)
+
+	// ReadNodeID doesn't record the ID
+	dir = t.TempDir()
+	nodeID, recorded, err := ReadNodeID(dir, "node1", "localhost:12001")
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", nodeID)
+	assert.False(t, recorded)
+	_, err = os.Stat(filepath.Join(dir, nodeIDFileName))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// An explicit ID is recorded on first start
+	nodeID, err = ResolveNodeID(dir, "node1", "localhost:12001")
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", nodeID)
+
+	nodeID, err = ResolveNodeID(dir, "", "localhost:12001")
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", nodeID)
+}
@@ -0,0 +1,25 @@
+//go:build windows
+
+package store
+
+import (
+	"errors"
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// lockFile returns ErrDataDirLocked if another process holds the lock, and other errors unchanged
+func lockFile(file *os.File) error {
+	ol := new(windows.Overlapped)
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	err := windows.LockFileEx(windows.Handle(file.Fd()), flags, 0, 1, 0, ol)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return ErrDataDirLocked
+	}
+	return err
+}
+
+func unlockFile(file *os.File) error {
+	ol := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(file.Fd()), 0, 1, 0, ol)
+}
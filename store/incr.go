@@ -0,0 +1,78 @@
+package store
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNotInteger is returned by Incr for keys whose value is not a signed 64-bit integer
+	ErrNotInteger = errors.New("value is not an integer")
+	// ErrOutOfRange is returned by Incr for increments overflowing or taking the value out of its bounds
+	ErrOutOfRange = errors.New("increment out of range")
+)
+
+// Incr adds Delta to the value of Key, a signed 64-bit integer. Keys that are not set count as 0, set keys
+// keep their expiry time. If Min or Max are set, increments taking the value below Min or above Max fail.
+type Incr struct {
+	Key   string `json:"key"`
+	Delta int64  `json:"delta,omitempty"`
+	Min   *int64 `json:"min,omitempty"`
+	Max   *int64 `json:"max,omitempty"`
+
+	// Now is the time of the leader proposing the increment in unix nanoseconds, it decides whether the key
+	// has expired on every node. It is set by Store.Incr.
+	Now int64 `json:"now,omitempty"`
+}
+
+// Incr applies incr, via distributed consensus, and returns the new value of the key
+func (s *Store) Incr(incr Incr) (int64, error) {
+	err := s.Limits.CheckKey(incr.Key)
+	if err != nil {
+		return 0, err
+	}
+	incr.Now = time.Now().UnixNano()
+	resp, err := s.propose(command{Op: CmdIncr, Incr: &incr})
+	if err != nil {
+		return 0, err
+	}
+	return resp.(int64), nil
+}
+
+// applyIncr applies incr and returns the new value of the key, or the error leaving it unchanged
+func (f *fsm) applyIncr(index uint64, incr *Incr) interface{} {
+	err := f.checkLocks([]TxnOp{{Op: CmdSet, Key: incr.Key}})
+	if err != nil {
+		return err
+	}
+
+	var n int64
+	if value, ok := f.kv[incr.Key]; ok && !f.expired(incr.Key, incr.Now) {
+		var err error
+		n, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return ErrNotInteger
+		}
+	} else {
+		delete(f.expires, incr.Key)
+	}
+
+	if (incr.Delta > 0 && n > math.MaxInt64-incr.Delta) || (incr.Delta < 0 && n < math.MinInt64-incr.Delta) {
+		return ErrOutOfRange
+	}
+	n += incr.Delta
+	if (incr.Min != nil && n < *incr.Min) || (incr.Max != nil && n > *incr.Max) {
+		return ErrOutOfRange
+	}
+
+	value := strconv.FormatInt(n, 10)
+	err = f.checkQuotas([]TxnOp{{Op: CmdSet, Key: incr.Key, Value: value}})
+	if err != nil {
+		return err
+	}
+	f.put(index, incr.Key, value)
+	f.notify(Event{Type: CmdSet, Key: incr.Key, Value: value, Index: index})
+	return n
+}
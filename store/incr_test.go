@@ -0,0 +1,45 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+// Test_Incr tests that increments add to integer values within their bounds and leave the value unchanged otherwise.
+func Test_Incr(t *testing.T) {
+	s := NewStore()
+	now := time.Now().UnixNano()
+	bound := func(n int64) *int64 { return &n }
+
+	// Keys that are not set count as 0
+	assert.Equal(t, int64(5), applyCommand(t, s, 1, command{Op: CmdIncr, Incr: &Incr{Key: "n", Delta: 5, Now: now}}))
+	assert.Equal(t, int64(2), applyCommand(t, s, 2, command{Op: CmdIncr, Incr: &Incr{Key: "n", Delta: -3, Now: now}}))
+	item, _ := s.Item("n")
+	assert.Equal(t, Item{Value: "2", Revision: 2}, item)
+
+	incr := &Incr{Key: "n", Delta: -3, Min: bound(0), Now: now}
+	assert.Equal(t, ErrOutOfRange, applyCommand(t, s, 3, command{Op: CmdIncr, Incr: incr}))
+	incr = &Incr{Key: "n", Delta: 8, Max: bound(10), Now: now}
+	assert.Equal(t, int64(10), applyCommand(t, s, 4, command{Op: CmdIncr, Incr: incr}))
+	assert.Equal(t, ErrOutOfRange, applyCommand(t, s, 5, command{Op: CmdIncr, Incr: incr}))
+	item, _ = s.Item("n")
+	assert.Equal(t, Item{Value: "10", Revision: 4}, item)
+
+	s.kv["max"] = "9223372036854775807"
+	assert.Equal(t, ErrOutOfRange, applyCommand(t, s, 6, command{Op: CmdIncr, Incr: &Incr{Key: "max", Delta: 1, Now: now}}))
+	s.kv["min"] = "-9223372036854775808"
+	assert.Equal(t, ErrOutOfRange, applyCommand(t, s, 7, command{Op: CmdIncr, Incr: &Incr{Key: "min", Delta: -1, Now: now}}))
+	assert.Equal(t, int64(math.MinInt64+1), applyCommand(t, s, 8, command{Op: CmdIncr, Incr: &Incr{Key: "min", Delta: 1, Now: now}}))
+
+	s.kv["text"] = "v1"
+	assert.Equal(t, ErrNotInteger, applyCommand(t, s, 9, command{Op: CmdIncr, Incr: &Incr{Key: "text", Delta: 1, Now: now}}))
+
+	// Live keys keep their expiry time, expired keys start again from 0 without one
+	s.kv["live"], s.expires["live"] = "1", now+int64(time.Hour)
+	s.kv["expired"], s.expires["expired"] = "1", now-int64(time.Second)
+	assert.Equal(t, int64(2), applyCommand(t, s, 10, command{Op: CmdIncr, Incr: &Incr{Key: "live", Delta: 1, Now: now}}))
+	assert.Equal(t, int64(1), applyCommand(t, s, 11, command{Op: CmdIncr, Incr: &Incr{Key: "expired", Delta: 1, Now: now}}))
+	assert.Equal(t, map[string]int64{"live": now + int64(time.Hour)}, s.expires)
+}
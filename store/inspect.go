@@ -0,0 +1,100 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+)
+
+var (
+	// Keys raft persists in the stable store
+	KeyCurrentTerm  = []byte("CurrentTerm")
+	KeyLastVoteTerm = []byte("LastVoteTerm")
+	KeyLastVoteCand = []byte("LastVoteCand")
+)
+
+// StableState is the raft state persisted in the stable store
+type StableState struct {
+	CurrentTerm  uint64 `json:"currentTerm"`
+	LastVoteTerm uint64 `json:"lastVoteTerm"`
+	LastVoteCand string `json:"lastVoteCand"`
+}
+
+// LogEntry is the decoded, human readable form of a raft log entry
+type LogEntry struct {
+	Index uint64 `json:"index"`
+	Term  uint64 `json:"term"`
+	Type  string `json:"type"`
+
+	// Command is set for LogCommand entries holding a kvdb command
+	Command *command `json:"command,omitempty"`
+	// Configuration is set for LogConfiguration entries
+	Configuration []raft.Server `json:"configuration,omitempty"`
+	// Data holds the raw data of entries which could not be decoded
+	Data []byte `json:"data,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// ReadStableState reads the raft state persisted in stable. Keys not written yet are left zero.
+func ReadStableState(stable raft.StableStore) (StableState, error) {
+	var state StableState
+	var err error
+
+	state.CurrentTerm, err = stable.GetUint64(KeyCurrentTerm)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return state, err
+	}
+
+	state.LastVoteTerm, err = stable.GetUint64(KeyLastVoteTerm)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return state, err
+	}
+
+	cand, err := stable.Get(KeyLastVoteCand)
+	if err != nil && !errors.Is(err, ErrKeyNotFound) {
+		return state, err
+	}
+	state.LastVoteCand = string(cand)
+
+	return state, nil
+}
+
+// DescribeLog decodes the payload of l according to its type
+func DescribeLog(l *raft.Log) LogEntry {
+	entry := LogEntry{
+		Index: l.Index,
+		Term:  l.Term,
+		Type:  l.Type.String(),
+	}
+
+	var err error
+	switch l.Type {
+	case raft.LogCommand:
+		entry.Command = new(command)
+		err = json.Unmarshal(l.Data, entry.Command)
+	case raft.LogConfiguration:
+		entry.Configuration, err = decodeConfiguration(l.Data)
+	default:
+		entry.Data = l.Data
+	}
+
+	if err != nil {
+		entry.Command = nil
+		entry.Data = l.Data
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// decodeConfiguration decodes a raft configuration, returning an error instead of panicking on bad data
+func decodeConfiguration(data []byte) (servers []raft.Server, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to decode configuration: %v", r)
+		}
+	}()
+
+	return raft.DecodeConfiguration(data).Servers, nil
+}
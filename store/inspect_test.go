@@ -0,0 +1,54 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestDescribeLog(t *testing.T) {
+	data, err := json.Marshal(command{Op: CmdSet, Key: "k1", Value: "v1"})
+	assert.NoError(t, err)
+
+	entry := DescribeLog(&raft.Log{Index: 1, Term: 2, Type: raft.LogCommand, Data: data})
+	assert.Equal(t, uint64(1), entry.Index)
+	assert.Equal(t, uint64(2), entry.Term)
+	assert.Equal(t, "LogCommand", entry.Type)
+	assert.Equal(t, &command{Op: CmdSet, Key: "k1", Value: "v1"}, entry.Command)
+	assert.Empty(t, entry.Error)
+
+	servers := []raft.Server{{Suffrage: raft.Voter, ID: "node1", Address: "localhost:12001"}}
+	data = raft.EncodeConfiguration(raft.Configuration{Servers: servers})
+	entry = DescribeLog(&raft.Log{Index: 2, Type: raft.LogConfiguration, Data: data})
+	assert.Equal(t, servers, entry.Configuration)
+
+	// Undecodable payloads are returned raw with the error
+	entry = DescribeLog(&raft.Log{Index: 3, Type: raft.LogCommand, Data: []byte("{bad")})
+	assert.Nil(t, entry.Command)
+	assert.Equal(t, []byte("{bad"), entry.Data)
+	assert.NotEmpty(t, entry.Error)
+
+	entry = DescribeLog(&raft.Log{Index: 4, Type: raft.LogConfiguration, Data: []byte("bad")})
+	assert.NotEmpty(t, entry.Error)
+}
+
+func TestReadStableState(t *testing.T) {
+	store := testBoltStore(t)
+	defer store.Close()
+	defer os.Remove(store.path)
+
+	// Nothing written yet
+	state, err := ReadStableState(store)
+	assert.NoError(t, err)
+	assert.Equal(t, StableState{}, state)
+
+	assert.NoError(t, store.SetUint64(KeyCurrentTerm, 3))
+	assert.NoError(t, store.SetUint64(KeyLastVoteTerm, 2))
+	assert.NoError(t, store.Set(KeyLastVoteCand, []byte("localhost:12001")))
+
+	state, err = ReadStableState(store)
+	assert.NoError(t, err)
+	assert.Equal(t, StableState{CurrentTerm: 3, LastVoteTerm: 2, LastVoteCand: "localhost:12001"}, state)
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrTooLarge is returned for writes over the Limits of the store
+var ErrTooLarge = errors.New("too large")
+
+// DefaultLimits are the limits of a new Store
+var DefaultLimits = Limits{
+	MaxKeyLength: 1024,
+	MaxValueSize: 1 << 20,
+	MaxBatchSize: 1000,
+}
+
+// Limits bound the size of the writes accepted by the store, so that no write turns into a raft log entry too
+// large to replicate and snapshot. Writes over a limit are rejected before they are proposed, whichever API they
+// come from. Zero values don't limit.
+type Limits struct {
+	// MaxKeyLength is the maximum length of a key in bytes, in its namespace
+	MaxKeyLength int
+	// MaxValueSize is the maximum length of a value in bytes
+	MaxValueSize int
+	// MaxBatchSize is the maximum number of keys set by one request, the operations of a transaction
+	MaxBatchSize int
+}
+
+// LimitError is the error of a write over one of the Limits, it matches ErrTooLarge
+type LimitError struct {
+	// Limit names the limit the write is over: key_length, value_size or batch_size
+	Limit   string
+	Message string
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is ErrTooLarge
+func (e *LimitError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// CheckKey returns a *LimitError if key, as stored, is longer than l.MaxKeyLength in its namespace
+func (l Limits) CheckKey(key string) error {
+	_, local := SplitKey(key)
+	if l.MaxKeyLength > 0 && len(local) > l.MaxKeyLength {
+		return &LimitError{Limit: "key_length", Message: fmt.Sprintf("key is longer than %d bytes", l.MaxKeyLength)}
+	}
+	return nil
+}
+
+// CheckKeyValue returns a *LimitError if key is longer than l.MaxKeyLength or value longer than l.MaxValueSize
+func (l Limits) CheckKeyValue(key, value string) error {
+	err := l.CheckKey(key)
+	if err != nil {
+		return err
+	}
+	if l.MaxValueSize > 0 && len(value) > l.MaxValueSize {
+		_, local := SplitKey(key)
+		return &LimitError{
+			Limit:   "value_size",
+			Message: fmt.Sprintf("value of key %s is larger than %d bytes", local, l.MaxValueSize),
+		}
+	}
+	return nil
+}
+
+// CheckBatch returns a *LimitError if size, the number of keys set by a request, is over l.MaxBatchSize
+func (l Limits) CheckBatch(size int) error {
+	if l.MaxBatchSize > 0 && size > l.MaxBatchSize {
+		return &LimitError{Limit: "batch_size", Message: fmt.Sprintf("request sets %d keys, more than %d", size, l.MaxBatchSize)}
+	}
+	return nil
+}
+
+// CheckTxn returns a *LimitError if txn has more operations than l.MaxBatchSize or one of its keys or values
+// is over the limits
+func (l Limits) CheckTxn(txn Txn) error {
+	err := l.CheckBatch(len(txn.Success) + len(txn.Failure))
+	if err != nil {
+		return err
+	}
+	for _, c := range txn.Compares {
+		err := l.CheckKeyValue(c.Key, c.Value)
+		if err != nil {
+			return err
+		}
+	}
+	for _, ops := range [][]TxnOp{txn.Success, txn.Failure} {
+		for _, op := range ops {
+			err := l.CheckKeyValue(op.Key, op.Value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// TestLimits tests that writes over the limits are rejected before they are proposed.
+func TestLimits(t *testing.T) {
+	s := NewStore()
+	s.Limits = Limits{MaxKeyLength: 4, MaxValueSize: 8, MaxBatchSize: 2}
+
+	err := s.Set("key12", "v")
+	assert.ErrorIs(t, err, ErrTooLarge)
+	assert.EqualError(t, err, "key is longer than 4 bytes")
+	err = s.Set("k", "123456789")
+	var limitErr *LimitError
+	assert.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, "value_size", limitErr.Limit)
+	_, err = s.Incr(Incr{Key: "key12", Delta: 1})
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = s.Lock("k", "123456789", "session")
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, err = s.Txn(Txn{Success: []TxnOp{{Op: CmdSet, Key: "a"}, {Op: CmdSet, Key: "b"}}, Failure: []TxnOp{{Op: CmdDelete, Key: "c"}}})
+	assert.EqualError(t, err, "request sets 3 keys, more than 2")
+	_, err = s.Txn(Txn{Compares: []Compare{{Key: "key12"}}})
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	// Keys are measured in their namespace
+	assert.NoError(t, s.Limits.CheckKey(NamespacedKey("tenant1", "key1")))
+	assert.ErrorIs(t, s.Limits.CheckKeyValue(NamespacedKey("tenant1", "k"), strings.Repeat("v", 9)), ErrTooLarge)
+	assert.NoError(t, Limits{}.CheckTxn(Txn{Success: make([]TxnOp, 2000)}))
+}
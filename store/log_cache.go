@@ -0,0 +1,175 @@
+package store
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/armon/go-metrics"
+	"github.com/hashicorp/raft"
+	"sync"
+)
+
+// logEntryOverhead approximates the memory used by a cached raft.Log besides its Data and Extensions
+const logEntryOverhead = 128
+
+// LogCache wraps a raft.LogStore and keeps the most recently written or read log entries in memory.
+// Unlike raft.LogCache, which holds a fixed number of entries, LogCache is bounded by the total size
+// of the cached entries and evicts the least recently used ones once maxBytes is exceeded.
+type LogCache struct {
+	store raft.LogStore
+
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	lru      *list.List
+	entries  map[uint64]*list.Element
+	// generation changes around every DeleteRange, so that entries read from the store before a range was
+	// deleted are not cached after it
+	generation uint64
+}
+
+// NewLogCache returns a LogCache holding at most maxBytes of log entries in front of store
+func NewLogCache(maxBytes int64, store raft.LogStore) (*LogCache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("log cache size must be positive")
+	}
+
+	return &LogCache{
+		store:    store,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[uint64]*list.Element),
+	}, nil
+}
+
+// -------------Implement raft.LogStore interface-----------------------//
+
+// FirstIndex returns the first index of the underlying store.
+func (c *LogCache) FirstIndex() (uint64, error) {
+	return c.store.FirstIndex()
+}
+
+// LastIndex returns the last index of the underlying store.
+func (c *LogCache) LastIndex() (uint64, error) {
+	return c.store.LastIndex()
+}
+
+// GetLog gets a log entry at a given index, from the cache if present, otherwise from the underlying store.
+func (c *LogCache) GetLog(idx uint64, log *raft.Log) error {
+	c.mu.Lock()
+	if elem, ok := c.entries[idx]; ok {
+		c.lru.MoveToFront(elem)
+		*log = *elem.Value.(*raft.Log)
+		c.mu.Unlock()
+		metrics.IncrCounter([]string{"kvdb", "logcache", "hit"}, 1)
+		return nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	metrics.IncrCounter([]string{"kvdb", "logcache", "miss"}, 1)
+	err := c.store.GetLog(idx, log)
+	if err != nil {
+		return err
+	}
+
+	cached := *log
+	c.mu.Lock()
+	if c.generation == generation {
+		c.add(&cached)
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// StoreLog stores a log entry.
+func (c *LogCache) StoreLog(log *raft.Log) error {
+	return c.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries in the underlying store and caches them on success.
+func (c *LogCache) StoreLogs(logs []*raft.Log) error {
+	err := c.store.StoreLogs(logs)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, log := range logs {
+		c.add(log)
+	}
+	return nil
+}
+
+// DeleteRange deletes a range of log entries from the cache and the underlying store. The range is inclusive.
+func (c *LogCache) DeleteRange(min, max uint64) error {
+	c.mu.Lock()
+	c.generation++
+	if max-min < uint64(len(c.entries)) {
+		for idx := min; ; idx++ {
+			if elem, ok := c.entries[idx]; ok {
+				c.remove(elem)
+			}
+			// idx would wrap around to 0 after math.MaxUint64
+			if idx == max {
+				break
+			}
+		}
+	} else {
+		// The range is larger than the cache, as when logs are compacted after a snapshot
+		for idx, elem := range c.entries {
+			if idx >= min && idx <= max {
+				c.remove(elem)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	err := c.store.DeleteRange(min, max)
+
+	c.mu.Lock()
+	c.generation++
+	c.mu.Unlock()
+	return err
+}
+
+// -------------End raft.LogStore interface implementation-----------------------//
+
+// IsMonotonic implements the raft.MonotonicLogStore interface by forwarding to the underlying store.
+func (c *LogCache) IsMonotonic() bool {
+	if store, ok := c.store.(raft.MonotonicLogStore); ok {
+		return store.IsMonotonic()
+	}
+	return false
+}
+
+// add inserts or replaces the cached entry for log.Index and evicts the least recently used
+// entries until the cache fits in maxBytes. Entries larger than maxBytes are not cached.
+// Callers must hold c.mu.
+func (c *LogCache) add(log *raft.Log) {
+	if elem, ok := c.entries[log.Index]; ok {
+		c.remove(elem)
+	}
+
+	size := logSize(log)
+	if size > c.maxBytes {
+		return
+	}
+
+	c.entries[log.Index] = c.lru.PushFront(log)
+	c.size += size
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops elem from the cache. Callers must hold c.mu.
+func (c *LogCache) remove(elem *list.Element) {
+	log := c.lru.Remove(elem).(*raft.Log)
+	delete(c.entries, log.Index)
+	c.size -= logSize(log)
+}
+
+func logSize(log *raft.Log) int64 {
+	return int64(len(log.Data) + len(log.Extensions) + logEntryOverhead)
+}
@@ -0,0 +1,225 @@
+package store
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+func testLogCache(t testing.TB, maxBytes int64) (*LogCache, *BoltStore) {
+	boltStore := testBoltStore(t)
+	cache, err := NewLogCache(maxBytes, boltStore)
+	assert.NoError(t, err)
+	return cache, boltStore
+}
+
+func TestLogCache_Implements(t *testing.T) {
+	var store any = &LogCache{}
+
+	_, ok := store.(raft.LogStore)
+	assert.True(t, ok, "LogCache does not implement raft.LogStore")
+
+	_, ok = store.(raft.MonotonicLogStore)
+	assert.True(t, ok, "LogCache does not implement raft.MonotonicLogStore")
+}
+
+func TestNewLogCacheInvalidSize(t *testing.T) {
+	_, err := NewLogCache(0, raft.NewInmemStore())
+	assert.Error(t, err)
+}
+
+func TestLogCacheGetLog(t *testing.T) {
+	cache, boltStore := testLogCache(t, 1<<20)
+	defer boltStore.Close()
+	defer os.Remove(boltStore.path)
+
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+		testRaftLog(3, "log3"),
+	}
+	err := cache.StoreLogs(logs)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, cache.lru.Len())
+
+	// Remove the logs from the backing store, the cache should still serve them
+	err = boltStore.DeleteRange(1, 3)
+	assert.NoError(t, err)
+
+	log := new(raft.Log)
+	err = cache.GetLog(2, log)
+	assert.NoError(t, err)
+	assert.Equal(t, logs[1], log)
+
+	// Cache misses fall through to the backing store
+	err = cache.GetLog(4, log)
+	assert.ErrorIs(t, err, raft.ErrLogNotFound)
+}
+
+func TestLogCacheEviction(t *testing.T) {
+	entrySize := logSize(testRaftLog(1, "log1"))
+	cache, boltStore := testLogCache(t, 2*entrySize)
+	defer boltStore.Close()
+	defer os.Remove(boltStore.path)
+
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+	}
+	err := cache.StoreLogs(logs)
+	assert.NoError(t, err)
+
+	// Touch log 1 so that log 2 becomes the least recently used entry
+	err = cache.GetLog(1, new(raft.Log))
+	assert.NoError(t, err)
+
+	err = cache.StoreLog(testRaftLog(3, "log3"))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, cache.lru.Len())
+	assert.LessOrEqual(t, cache.size, cache.maxBytes)
+	assert.Contains(t, cache.entries, uint64(1))
+	assert.NotContains(t, cache.entries, uint64(2))
+	assert.Contains(t, cache.entries, uint64(3))
+
+	// Entries larger than the cache are never cached
+	err = cache.StoreLog(testRaftLog(4, string(make([]byte, 2*entrySize))))
+	assert.NoError(t, err)
+	assert.NotContains(t, cache.entries, uint64(4))
+
+	// Evicted entries are read back from the store and cached again
+	log := new(raft.Log)
+	err = cache.GetLog(2, log)
+	assert.NoError(t, err)
+	assert.Equal(t, logs[1], log)
+	assert.Contains(t, cache.entries, uint64(2))
+}
+
+func TestLogCacheDeleteRange(t *testing.T) {
+	cache, boltStore := testLogCache(t, 1<<20)
+	defer boltStore.Close()
+	defer os.Remove(boltStore.path)
+
+	logs := []*raft.Log{
+		testRaftLog(1, "log1"),
+		testRaftLog(2, "log2"),
+		testRaftLog(3, "log3"),
+	}
+	err := cache.StoreLogs(logs)
+	assert.NoError(t, err)
+
+	err = cache.DeleteRange(1, 2)
+	assert.NoError(t, err)
+
+	err = cache.GetLog(1, new(raft.Log))
+	assert.ErrorIs(t, err, raft.ErrLogNotFound)
+	err = cache.GetLog(2, new(raft.Log))
+	assert.ErrorIs(t, err, raft.ErrLogNotFound)
+
+	assert.Equal(t, logSize(logs[2]), cache.size)
+
+	idx, err := cache.FirstIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), idx)
+}
+
+// TestLogCacheDeleteRangeMaxIndex tests that deleting a range ending at the largest index terminates.
+func TestLogCacheDeleteRangeMaxIndex(t *testing.T) {
+	cache, boltStore := testLogCache(t, 1<<20)
+	defer boltStore.Close()
+	defer os.Remove(boltStore.path)
+
+	logs := []*raft.Log{
+		testRaftLog(math.MaxUint64-2, "log1"),
+		testRaftLog(math.MaxUint64-1, "log2"),
+		testRaftLog(math.MaxUint64, "log3"),
+	}
+	err := cache.StoreLogs(logs)
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() { done <- cache.DeleteRange(math.MaxUint64-1, math.MaxUint64) }()
+	select {
+	case err = <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("DeleteRange did not return")
+	}
+
+	assert.Len(t, cache.entries, 1)
+	err = cache.GetLog(math.MaxUint64, new(raft.Log))
+	assert.ErrorIs(t, err, raft.ErrLogNotFound)
+}
+
+// TestLogCacheDeleteRangeDuringMiss tests that entries deleted while a cache miss reads them are not cached.
+func TestLogCacheDeleteRangeDuringMiss(t *testing.T) {
+	store := &deletingLogStore{InmemStore: raft.NewInmemStore()}
+	cache, err := NewLogCache(1<<20, store)
+	assert.NoError(t, err)
+	err = store.StoreLogs([]*raft.Log{testRaftLog(1, "log1"), testRaftLog(2, "log2")})
+	assert.NoError(t, err)
+
+	// The range is deleted after the store returned log 1 to the miss, before the miss caches it
+	store.onGetLog = func() { assert.NoError(t, cache.DeleteRange(1, 2)) }
+	err = cache.GetLog(1, new(raft.Log))
+	assert.NoError(t, err)
+	assert.Empty(t, cache.entries)
+
+	store.onGetLog = nil
+	err = cache.GetLog(1, new(raft.Log))
+	assert.ErrorIs(t, err, raft.ErrLogNotFound)
+}
+
+// deletingLogStore is a raft.InmemStore calling onGetLog once it read a log
+type deletingLogStore struct {
+	*raft.InmemStore
+	onGetLog func()
+}
+
+func (s *deletingLogStore) GetLog(index uint64, log *raft.Log) error {
+	err := s.InmemStore.GetLog(index, log)
+	if s.onGetLog != nil {
+		onGetLog := s.onGetLog
+		s.onGetLog = nil
+		onGetLog()
+	}
+	return err
+}
+
+func benchmarkGetLog(b *testing.B, store raft.LogStore) {
+	const n = 1000
+	logs := make([]*raft.Log, n)
+	for i := range logs {
+		logs[i] = testRaftLog(uint64(i+1), "benchmark log data")
+	}
+	err := store.StoreLogs(logs)
+	assert.NoError(b, err)
+
+	log := new(raft.Log)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := store.GetLog(uint64(i%n+1), log)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBoltStoreGetLog(b *testing.B) {
+	boltStore := testBoltStore(b)
+	defer boltStore.Close()
+	defer os.Remove(boltStore.path)
+
+	benchmarkGetLog(b, boltStore)
+}
+
+func BenchmarkLogCacheGetLog(b *testing.B) {
+	cache, boltStore := testLogCache(b, logCacheSize)
+	defer boltStore.Close()
+	defer os.Remove(boltStore.path)
+
+	benchmarkGetLog(b, cache)
+}
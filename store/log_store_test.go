@@ -0,0 +1,178 @@
+package store
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// testLogStoreConformance runs the behaviour raft expects from every raft.LogStore backend
+// against stores created by newStore
+func testLogStoreConformance(t *testing.T, newStore func(t *testing.T) raft.LogStore) {
+	t.Run("Index", func(t *testing.T) {
+		store := newStore(t)
+
+		// Should get 0 index on empty log
+		idx, err := store.FirstIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), idx)
+
+		idx, err = store.LastIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), idx)
+
+		err = store.StoreLogs(testRaftLogs(1, 3))
+		assert.NoError(t, err)
+
+		idx, err = store.FirstIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), idx)
+
+		idx, err = store.LastIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), idx)
+	})
+
+	t.Run("SetAndGetLogs", func(t *testing.T) {
+		store := newStore(t)
+
+		// Should return an error on non-existent log
+		err := store.GetLog(1, new(raft.Log))
+		assert.ErrorIs(t, err, raft.ErrLogNotFound)
+
+		logs := testRaftLogs(1, 3)
+		err = store.StoreLogs(logs)
+		assert.NoError(t, err)
+
+		err = store.StoreLog(testRaftLog(4, "log4"))
+		assert.NoError(t, err)
+		logs = append(logs, testRaftLog(4, "log4"))
+
+		for _, want := range logs {
+			log := new(raft.Log)
+			err = store.GetLog(want.Index, log)
+			assert.NoError(t, err)
+			assert.Equal(t, want, log)
+		}
+
+		err = store.GetLog(5, new(raft.Log))
+		assert.ErrorIs(t, err, raft.ErrLogNotFound)
+	})
+
+	t.Run("DeleteRangePrefix", func(t *testing.T) {
+		store := newStore(t)
+
+		logs := testRaftLogs(1, 3)
+		err := store.StoreLogs(logs)
+		assert.NoError(t, err)
+
+		err = store.DeleteRange(1, 2)
+		assert.NoError(t, err)
+
+		err = store.GetLog(1, new(raft.Log))
+		assert.ErrorIs(t, err, raft.ErrLogNotFound)
+		err = store.GetLog(2, new(raft.Log))
+		assert.ErrorIs(t, err, raft.ErrLogNotFound)
+
+		log := new(raft.Log)
+		err = store.GetLog(3, log)
+		assert.NoError(t, err)
+		assert.Equal(t, logs[2], log)
+
+		idx, err := store.FirstIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(3), idx)
+	})
+
+	t.Run("DeleteRangeSuffix", func(t *testing.T) {
+		store := newStore(t)
+
+		err := store.StoreLogs(testRaftLogs(1, 10))
+		assert.NoError(t, err)
+
+		// Raft deletes conflicting logs and then stores the leader's logs in their place
+		err = store.DeleteRange(6, 10)
+		assert.NoError(t, err)
+
+		idx, err := store.LastIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(5), idx)
+
+		err = store.GetLog(6, new(raft.Log))
+		assert.ErrorIs(t, err, raft.ErrLogNotFound)
+
+		replacement := testRaftLog(6, "replacement")
+		err = store.StoreLog(replacement)
+		assert.NoError(t, err)
+
+		log := new(raft.Log)
+		err = store.GetLog(6, log)
+		assert.NoError(t, err)
+		assert.Equal(t, replacement, log)
+	})
+
+	t.Run("DeleteRangeAll", func(t *testing.T) {
+		store := newStore(t)
+
+		err := store.StoreLogs(testRaftLogs(1, 10))
+		assert.NoError(t, err)
+
+		err = store.DeleteRange(1, 10)
+		assert.NoError(t, err)
+
+		idx, err := store.FirstIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), idx)
+
+		idx, err = store.LastIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(0), idx)
+
+		// After a snapshot restore raft continues the log past the snapshot index
+		err = store.StoreLogs(testRaftLogs(100, 101))
+		assert.NoError(t, err)
+
+		idx, err = store.FirstIndex()
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(100), idx)
+	})
+
+	t.Run("Monotonic", func(t *testing.T) {
+		store := newStore(t)
+
+		monotonic, ok := store.(raft.MonotonicLogStore)
+		assert.True(t, ok)
+		assert.True(t, monotonic.IsMonotonic())
+	})
+}
+
+func TestBoltStore_LogStoreConformance(t *testing.T) {
+	testLogStoreConformance(t, func(t *testing.T) raft.LogStore {
+		store := testBoltStore(t)
+		t.Cleanup(func() {
+			store.Close()
+			os.Remove(store.path)
+		})
+		return store
+	})
+}
+
+func TestWAL_LogStoreConformance(t *testing.T) {
+	testLogStoreConformance(t, func(t *testing.T) raft.LogStore {
+		wal := testWAL(t, 0)
+		t.Cleanup(func() { wal.Close() })
+		return wal
+	})
+}
+
+func TestLogCache_LogStoreConformance(t *testing.T) {
+	testLogStoreConformance(t, func(t *testing.T) raft.LogStore {
+		cache, store := testLogCache(t, 1<<20)
+		t.Cleanup(func() {
+			store.Close()
+			os.Remove(store.path)
+		})
+		return cache
+	})
+}
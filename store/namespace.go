@@ -0,0 +1,275 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultNamespace holds the keys written without namespace. It always exists.
+const DefaultNamespace = "default"
+
+var (
+	// ErrNamespaceNotFound is returned for writes to namespaces that don't exist
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	// ErrQuotaExceeded is returned for writes taking a namespace over its quota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrInvalidNamespace is returned for invalid namespace names and quotas
+	ErrInvalidNamespace = errors.New("invalid namespace")
+	// ErrInvalidKey is returned by CheckKey for keys containing a NUL byte
+	ErrInvalidKey = errors.New("invalid key")
+
+	namespaceName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// Quota limits the number of keys of a namespace and their size, the length of their keys and values.
+// Zero values don't limit.
+type Quota struct {
+	MaxKeys  int64 `json:"max_keys,omitempty"`
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+}
+
+// NamespaceStats describes a namespace and its usage. Expired keys count until the leader deletes them.
+type NamespaceStats struct {
+	Name  string
+	Quota Quota
+	Keys  int64
+	Bytes int64
+}
+
+// namespace is the quota of a namespace with its usage, derived from the keys
+type namespace struct {
+	quota Quota
+	keys  int64
+	bytes int64
+}
+
+// NamespacedKey returns the key under which key of namespace ns is stored. Keys of DefaultNamespace are stored
+// as is, the keys of other namespaces prefixed with the namespace name and a NUL byte.
+func NamespacedKey(ns, key string) string {
+	if ns == "" || ns == DefaultNamespace {
+		return key
+	}
+	return ns + "\x00" + key
+}
+
+// CheckKey returns an error matching ErrInvalidKey if key contains a NUL byte. The store can't tell such a key
+// of the default namespace from the key NamespacedKey returns for another namespace, so the listeners check every
+// key they receive with CheckKey before reading or writing it.
+func CheckKey(key string) error {
+	if strings.Contains(key, "\x00") {
+		return fmt.Errorf("%w %q: keys must not contain NUL bytes", ErrInvalidKey, key)
+	}
+	return nil
+}
+
+// SplitKey returns the namespace of a key as stored and the key within the namespace, reversing NamespacedKey
+func SplitKey(key string) (string, string) {
+	ns, local, ok := strings.Cut(key, "\x00")
+	if !ok {
+		return DefaultNamespace, key
+	}
+	return ns, local
+}
+
+// SetNamespace creates the namespace name, or updates its quota if it exists, via distributed consensus.
+// Lowering a quota below the usage of the namespace only rejects the writes adding to it.
+func (s *Store) SetNamespace(name string, quota Quota) error {
+	if !namespaceName.MatchString(name) {
+		return fmt.Errorf("%w name %q", ErrInvalidNamespace, name)
+	}
+	if quota.MaxKeys < 0 || quota.MaxBytes < 0 {
+		return fmt.Errorf("%w: quota must not be negative", ErrInvalidNamespace)
+	}
+	_, err := s.propose(command{Op: CmdNamespace, Key: name, Quota: &quota})
+	return err
+}
+
+// DeleteNamespace deletes the namespace name and its keys, via distributed consensus. DefaultNamespace
+// can't be deleted.
+func (s *Store) DeleteNamespace(name string) error {
+	if name == DefaultNamespace {
+		return fmt.Errorf("%w: the default namespace can't be deleted", ErrInvalidNamespace)
+	}
+	_, err := s.propose(command{Op: CmdNamespaceDelete, Key: name})
+	return err
+}
+
+// Namespace returns the namespace name and whether it exists
+func (s *Store) Namespace(name string) (NamespaceStats, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, ok := s.namespaces[name]
+	if !ok {
+		return NamespaceStats{}, false
+	}
+	return NamespaceStats{Name: name, Quota: n.quota, Keys: n.keys, Bytes: n.bytes}, true
+}
+
+// Namespaces returns the namespaces sorted by name
+func (s *Store) Namespaces() []NamespaceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	namespaces := make([]NamespaceStats, 0, len(s.namespaces))
+	for name, n := range s.namespaces {
+		namespaces = append(namespaces, NamespaceStats{Name: name, Quota: n.quota, Keys: n.keys, Bytes: n.bytes})
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces
+}
+
+// KeysIn returns the keys of namespace ns, without the namespace prefix
+func (s *Store) KeysIn(ns string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	keys := make([]string, 0)
+	for key := range s.kv {
+		keyNS, local := SplitKey(key)
+		if keyNS == ns && !(*fsm)(s).expired(key, now) {
+			keys = append(keys, local)
+		}
+	}
+	return keys
+}
+
+func (f *fsm) applyNamespace(name string, quota *Quota) interface{} {
+	n, ok := f.namespaces[name]
+	if !ok {
+		n = &namespace{}
+		f.namespaces[name] = n
+	}
+	n.quota = *quota
+	return nil
+}
+
+// applyNamespaceDelete deletes the namespace and its keys
+func (f *fsm) applyNamespaceDelete(index uint64, name string) interface{} {
+	if _, ok := f.namespaces[name]; !ok || name == DefaultNamespace {
+		return ErrNamespaceNotFound
+	}
+	var keys []string
+	for key := range f.kv {
+		if ns, _ := SplitKey(key); ns == name {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f.remove(key)
+		f.notify(Event{Type: CmdDelete, Key: key, Index: index})
+	}
+	delete(f.namespaces, name)
+	return nil
+}
+
+// checkQuotas returns ErrNamespaceNotFound or ErrQuotaExceeded if applying ops, CmdSet and CmdDelete operations,
+// would set a key of a namespace that doesn't exist or take a namespace over its quota. Only namespaces
+// whose usage grows are checked. It must be called with f.mu held.
+func (f *fsm) checkQuotas(ops []TxnOp) error {
+	type usage struct{ keys, bytes int64 }
+	deltas := make(map[string]*usage)
+	// written holds the values set by the previous ops, nil for deleted keys
+	written := make(map[string]*string)
+
+	for _, op := range ops {
+		ns, local := SplitKey(op.Key)
+		if _, ok := f.namespaces[ns]; !ok {
+			if op.Op == CmdSet {
+				return fmt.Errorf("%w: %s", ErrNamespaceNotFound, ns)
+			}
+			continue
+		}
+		delta, ok := deltas[ns]
+		if !ok {
+			delta = &usage{}
+			deltas[ns] = delta
+		}
+
+		old, exists := f.kv[op.Key]
+		if value, ok := written[op.Key]; ok {
+			exists = value != nil
+			if exists {
+				old = *value
+			}
+		}
+		if exists {
+			delta.keys--
+			delta.bytes -= int64(len(local) + len(old))
+		}
+		if op.Op == CmdSet {
+			value := op.Value
+			written[op.Key] = &value
+			delta.keys++
+			delta.bytes += int64(len(local) + len(value))
+		} else {
+			written[op.Key] = nil
+		}
+	}
+
+	for ns, delta := range deltas {
+		n := f.namespaces[ns]
+		if (n.quota.MaxKeys > 0 && delta.keys > 0 && n.keys+delta.keys > n.quota.MaxKeys) ||
+			(n.quota.MaxBytes > 0 && delta.bytes > 0 && n.bytes+delta.bytes > n.quota.MaxBytes) {
+			return fmt.Errorf("%w for namespace %s", ErrQuotaExceeded, ns)
+		}
+	}
+	return nil
+}
+
+// put sets key to value, recording the change at index and the usage of its namespace.
+// It must be called with f.mu held.
+func (f *fsm) put(index uint64, key, value string) {
+	old, exists := f.kv[key]
+	ns, local := SplitKey(key)
+	if n, ok := f.namespaces[ns]; ok {
+		if exists {
+			n.keys--
+			n.bytes -= int64(len(local) + len(old))
+		}
+		n.keys++
+		n.bytes += int64(len(local) + len(value))
+	}
+	f.kv[key] = value
+	f.revisions[key] = index
+}
+
+// remove deletes key with its expiry time, revision and lock, updating the usage of its namespace.
+// It must be called with f.mu held.
+func (f *fsm) remove(key string) {
+	old, exists := f.kv[key]
+	if !exists {
+		return
+	}
+	ns, local := SplitKey(key)
+	if n, ok := f.namespaces[ns]; ok {
+		n.keys--
+		n.bytes -= int64(len(local) + len(old))
+	}
+	delete(f.kv, key)
+	delete(f.expires, key)
+	delete(f.revisions, key)
+	delete(f.locks, key)
+}
+
+// countUsage sets the usage of the namespaces from the keys, creating DefaultNamespace if needed.
+// It must be called with f.mu held.
+func (f *fsm) countUsage() {
+	if _, ok := f.namespaces[DefaultNamespace]; !ok {
+		f.namespaces[DefaultNamespace] = &namespace{}
+	}
+	for _, n := range f.namespaces {
+		n.keys, n.bytes = 0, 0
+	}
+	for key, value := range f.kv {
+		ns, local := SplitKey(key)
+		if n, ok := f.namespaces[ns]; ok {
+			n.keys++
+			n.bytes += int64(len(local) + len(value))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"bytes"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+// Test_Namespaces tests that namespaces keep their keys apart and that quotas are enforced when writes are applied.
+func Test_Namespaces(t *testing.T) {
+	s := NewStore()
+	key := NamespacedKey("tenant1", "k1")
+
+	assert.ErrorIs(t, applyCommand(t, s, 1, command{Op: CmdSet, Key: key, Value: "v1"}).(error), ErrNamespaceNotFound)
+
+	applyCommand(t, s, 2, command{Op: CmdNamespace, Key: "tenant1", Quota: &Quota{MaxKeys: 2, MaxBytes: 10}})
+	assert.Nil(t, applyCommand(t, s, 3, command{Op: CmdSet, Key: key, Value: "v1"}))
+	assert.Nil(t, applyCommand(t, s, 4, command{Op: CmdSet, Key: "k1", Value: "default"}))
+	assert.Equal(t, []string{"k1"}, s.KeysIn("tenant1"))
+	assert.Equal(t, []string{"k1"}, s.Keys())
+	value, _ := s.Get(key)
+	assert.Equal(t, "v1", value)
+
+	stats, ok := s.Namespace("tenant1")
+	assert.True(t, ok)
+	assert.Equal(t, NamespaceStats{Name: "tenant1", Quota: Quota{MaxKeys: 2, MaxBytes: 10}, Keys: 1, Bytes: 4}, stats)
+
+	// Replacing a value only counts the difference
+	assert.Nil(t, applyCommand(t, s, 5, command{Op: CmdSet, Key: key, Value: "v1234567"}))
+	resp := applyCommand(t, s, 6, command{Op: CmdSet, Key: key, Value: "v12345678"})
+	assert.EqualError(t, resp.(error), "quota exceeded for namespace tenant1")
+
+	// Transactions are checked as a whole and applied entirely or not at all
+	txn := &Txn{Success: []TxnOp{
+		{Op: CmdDelete, Key: key},
+		{Op: CmdSet, Key: NamespacedKey("tenant1", "k2"), Value: "v2"},
+		{Op: CmdSet, Key: NamespacedKey("tenant1", "k3"), Value: "v3"},
+	}}
+	assert.Equal(t, true, applyCommand(t, s, 7, command{Op: CmdTxn, Txn: txn}))
+	assert.ElementsMatch(t, []string{"k2", "k3"}, s.KeysIn("tenant1"))
+
+	txn = &Txn{Success: []TxnOp{
+		{Op: CmdSet, Key: "k2", Value: "default"},
+		{Op: CmdSet, Key: NamespacedKey("tenant1", "k4"), Value: "v4"},
+	}}
+	assert.ErrorIs(t, applyCommand(t, s, 8, command{Op: CmdTxn, Txn: txn}).(error), ErrQuotaExceeded)
+	assert.Equal(t, []string{"k1"}, s.Keys())
+
+	assert.ErrorIs(t, applyCommand(t, s, 9, command{Op: CmdIncr, Incr: &Incr{Key: NamespacedKey("tenant1", "n"), Delta: 1}}).(error), ErrQuotaExceeded)
+	stats, _ = s.Namespace("tenant1")
+	assert.Equal(t, int64(2), stats.Keys)
+	assert.Equal(t, int64(8), stats.Bytes)
+
+	// Deletes don't need quota
+	applyCommand(t, s, 10, command{Op: CmdNamespace, Key: "tenant1", Quota: &Quota{MaxKeys: 1}})
+	assert.Nil(t, applyCommand(t, s, 11, command{Op: CmdDelete, Key: NamespacedKey("tenant1", "k2")}))
+
+	// A restored store counts the usage again
+	snapshot, err := (*fsm)(s).Snapshot()
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	err = snapshot.Persist(&testSnapshotSink{DiscardSnapshotSink: &raft.DiscardSnapshotSink{}, buf: buf})
+	assert.NoError(t, err)
+	restored := NewStore()
+	err = (*fsm)(restored).Restore(io.NopCloser(buf))
+	assert.NoError(t, err)
+	assert.Equal(t, s.Namespaces(), restored.Namespaces())
+
+	assert.Nil(t, applyCommand(t, s, 12, command{Op: CmdNamespaceDelete, Key: "tenant1"}))
+	assert.Equal(t, map[string]string{"k1": "default"}, s.kv)
+	assert.Equal(t, []NamespaceStats{{Name: DefaultNamespace, Keys: 1, Bytes: 9}}, s.Namespaces())
+	assert.Equal(t, ErrNamespaceNotFound, applyCommand(t, s, 13, command{Op: CmdNamespaceDelete, Key: DefaultNamespace}))
+
+	assert.Error(t, s.SetNamespace("tenant/1", Quota{}))
+	assert.Error(t, s.DeleteNamespace(DefaultNamespace))
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"github.com/armon/go-metrics"
+	"slices"
+)
+
+// maxQuarantined is the number of quarantined entries kept, the oldest are dropped first
+const maxQuarantined = 100
+
+// maxQuarantinedData is the number of bytes of an undecodable entry kept in its quarantine record
+const maxQuarantinedData = 256
+
+var (
+	// ErrMalformedCommand is the apply response of log entries that don't decode to a valid command
+	ErrMalformedCommand = errors.New("malformed command")
+	// ErrUnknownCommand is the apply response of log entries with an op unknown to this node
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+// QuarantinedEntry records a committed log entry the FSM could not apply, because it is malformed or its op is
+// unknown to the node, leaving the store unchanged instead of crashing. Ops are only proposed once every node of
+// the cluster applies them, see Store.checkVersion, so that no node quarantines an entry the others apply.
+//
+// The quarantined entries are node-local. They are neither replicated nor kept in snapshots, so a node restored
+// from a snapshot taken after an entry doesn't list it.
+type QuarantinedEntry struct {
+	Index uint64 `json:"index"`
+	Op    string `json:"op,omitempty"`
+	Error string `json:"error"`
+	// Data is the beginning of the entry for entries that could not be decoded
+	Data string `json:"data,omitempty"`
+}
+
+// Quarantined returns the last log entries the FSM of this node could not apply, oldest first
+func (s *Store) Quarantined() []QuarantinedEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.quarantined)
+}
+
+// check returns an error matching ErrMalformedCommand if c lacks the arguments of its op, or ErrUnknownCommand
+// if c is a transaction with an operation unknown to this node
+func (c command) check() error {
+	if c.Op == CmdTxn && c.Txn != nil {
+		for _, op := range append(slices.Clone(c.Txn.Success), c.Txn.Failure...) {
+			if op.Op != CmdSet && op.Op != CmdDelete {
+				return fmt.Errorf("%w: transaction op %s", ErrUnknownCommand, op.Op)
+			}
+		}
+	}
+
+	var missing string
+	switch {
+	case c.Op == CmdTxn && c.Txn == nil:
+		missing = "txn"
+	case c.Op == CmdIncr && c.Incr == nil:
+		missing = "incr"
+	case c.Op == CmdSessionCreate && c.Session == nil:
+		missing = "session"
+	case c.Op == CmdNamespace && c.Quota == nil:
+		missing = "quota"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%w: %s command without %s", ErrMalformedCommand, c.Op, missing)
+}
+
+// quarantine records the entry at index, which failed to apply with err, and returns err as its apply response.
+// data is set for entries that could not be decoded. It must be called with f.mu held.
+func (f *fsm) quarantine(index uint64, op string, data []byte, err error) error {
+	f.logger.Printf("quarantined log entry %d: %s", index, err)
+	metrics.IncrCounter([]string{"kvdb", "fsm", "quarantined"}, 1)
+
+	entry := QuarantinedEntry{Index: index, Op: op, Error: err.Error()}
+	if len(data) > maxQuarantinedData {
+		data = data[:maxQuarantinedData]
+	}
+	entry.Data = string(data)
+	if len(f.quarantined) == maxQuarantined {
+		f.quarantined = slices.Delete(f.quarantined, 0, 1)
+	}
+	f.quarantined = append(f.quarantined, entry)
+	return err
+}
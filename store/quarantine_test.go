@@ -0,0 +1,85 @@
+package store
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// Test_Quarantine tests that malformed entries respond with an error and are quarantined instead of stopping
+// the node, and that the entries after them are applied.
+func Test_Quarantine(t *testing.T) {
+	s := NewStore()
+	f := (*fsm)(s)
+
+	resp := f.Apply(&raft.Log{Index: 1, Type: raft.LogCommand, Data: []byte(`{"op":`)})
+	assert.ErrorIs(t, resp.(error), ErrMalformedCommand)
+	assert.ErrorIs(t, applyCommand(t, s, 2, command{Op: CmdIncr}).(error), ErrMalformedCommand)
+	assert.ErrorIs(t, applyCommand(t, s, 3, command{Op: CmdTxn}).(error), ErrMalformedCommand)
+	assert.Nil(t, applyCommand(t, s, 4, command{Op: CmdSet, Key: "k1", Value: "v1"}))
+
+	// Bad writes of a batch fail alone
+	resps := applyCommand(t, s, 5, command{Op: CmdBatch, Batch: []command{
+		{Op: CmdSet, Key: "k2", Value: "v2"},
+		{Op: CmdIncr},
+	}}).([]interface{})
+	assert.Nil(t, resps[0])
+	assert.ErrorIs(t, resps[1].(error), ErrMalformedCommand)
+
+	resps = f.ApplyBatch([]*raft.Log{
+		{Index: 6, Type: raft.LogCommand, Data: []byte(strings.Repeat("x", 1000))},
+		testLog(t, 7, command{Op: CmdSet, Key: "k3", Value: "v3"}),
+	})
+	assert.ErrorIs(t, resps[0].(error), ErrMalformedCommand)
+	assert.Nil(t, resps[1])
+
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v2", "k3": "v3"}, s.kv)
+	quarantined := s.Quarantined()
+	assert.Len(t, quarantined, 5)
+	assert.Equal(t, QuarantinedEntry{Index: 2, Op: CmdIncr, Error: "malformed command: INCR command without incr"}, quarantined[1])
+	assert.Equal(t, QuarantinedEntry{Index: 3, Op: CmdTxn, Error: "malformed command: TXN command without txn"}, quarantined[2])
+	assert.Equal(t, uint64(5), quarantined[3].Index)
+	assert.Equal(t, `{"op":`, quarantined[0].Data)
+	assert.Len(t, quarantined[4].Data, maxQuarantinedData)
+
+	for i := 0; i < maxQuarantined; i++ {
+		applyCommand(t, s, uint64(8+i), command{Op: CmdIncr})
+	}
+	quarantined = s.Quarantined()
+	assert.Len(t, quarantined, maxQuarantined)
+	assert.Equal(t, uint64(8), quarantined[0].Index)
+}
+
+// Test_UnknownCommand tests that entries with an op unknown to the node are quarantined, and that ops the
+// cluster doesn't support yet are not proposed.
+func Test_UnknownCommand(t *testing.T) {
+	s := NewStore()
+
+	assert.ErrorIs(t, applyCommand(t, s, 1, command{Op: "COMPACT"}).(error), ErrUnknownCommand)
+	txn := &Txn{Success: []TxnOp{{Op: CmdSet, Key: "k1", Value: "v1"}, {Op: CmdIncr, Key: "k2"}}}
+	assert.ErrorIs(t, applyCommand(t, s, 2, command{Op: CmdTxn, Txn: txn}).(error), ErrUnknownCommand)
+	resps := applyCommand(t, s, 3, command{Op: CmdBatch, Batch: []command{{Op: "COMPACT"}}}).([]interface{})
+	assert.ErrorIs(t, resps[0].(error), ErrUnknownCommand)
+
+	assert.Empty(t, s.kv)
+	quarantined := s.Quarantined()
+	assert.Len(t, quarantined, 3)
+	assert.Equal(t, QuarantinedEntry{Index: 1, Op: "COMPACT", Error: "unknown command: COMPACT"}, quarantined[0])
+	assert.Equal(t, QuarantinedEntry{Index: 2, Op: CmdTxn, Error: "unknown command: transaction op INCR"}, quarantined[1])
+}
+
+// Test_StoreQuarantine tests that the errors of entries rejected by the FSM reach the writers.
+func Test_StoreQuarantine(t *testing.T) {
+	s := openBatchStore(t, DefaultMaxBatchSize, 0)
+
+	_, err := s.propose(command{Op: CmdTxn})
+	assert.ErrorIs(t, err, ErrMalformedCommand)
+	_, err = s.batch(command{Op: CmdIncr})
+	assert.ErrorIs(t, err, ErrMalformedCommand)
+
+	assert.NoError(t, s.Set("k1", "v1"))
+	value, _ := s.Get("k1")
+	assert.Equal(t, "v1", value)
+	assert.Len(t, s.Quarantined(), 2)
+}
@@ -1,19 +1,41 @@
 package store
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/raft"
+	"slices"
 )
 
 type Node struct {
 	NodeID   string
 	RaftAddr string
+	// HTTPAddr is empty until the node's HTTP address is known to the cluster
+	HTTPAddr string
+	// RESPAddr is the address of the Redis protocol of the node, empty unless the node serves it
+	RESPAddr string
+	// Version is the CommandVersion of the node, 0 until the node advertises it
+	Version int
 }
 
-// AddNode adds a new Node to raft cluster. This should be called from the leader Node
+// NodeMeta is what the cluster records of a node besides its raft address, advertised by the node when it joins
+// the cluster or becomes leader
+type NodeMeta struct {
+	HTTPAddr string `json:"http_addr,omitempty"`
+	RESPAddr string `json:"resp_addr,omitempty"`
+	Version  int    `json:"version,omitempty"`
+}
+
+// AddNode adds a new Node to raft cluster. This should be called from the leader Node,
+// other nodes return ErrNotLeader.
 func (s *Store) AddNode(nodeID, addr string) error {
 	s.logger.Printf("received add request for remote Node %s at %s", nodeID, addr)
 
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
 	nodes, err := s.NodeList()
 	if err != nil {
 		return err
@@ -44,15 +66,83 @@ func (s *Store) AddNode(nodeID, addr string) error {
 	return nil
 }
 
+// SetNodeMeta records meta as the metadata of the node nodeID in the cluster.
+// This should be called from the leader Node, other nodes return ErrNotLeader.
+func (s *Store) SetNodeMeta(nodeID string, meta NodeMeta) error {
+	if s.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	// Value holds the HTTP address for the nodes older than NodeMeta
+	cmd, err := json.Marshal(command{
+		Op:    CmdNodeMeta,
+		Key:   nodeID,
+		Value: meta.HTTPAddr,
+		Meta:  &meta,
+	})
+	if err != nil {
+		return err
+	}
+
+	f := s.raft.Apply(cmd, raftTimeout)
+	return f.Error()
+}
+
+// LocalNode returns this node
+func (s *Store) LocalNode() Node {
+	return Node{
+		NodeID:   s.localID,
+		RaftAddr: string(s.transport.LocalAddr()),
+		HTTPAddr: s.HTTPAddr,
+		RESPAddr: s.RESPAddr,
+		Version:  CommandVersion,
+	}
+}
+
+// IsMember reports whether this node is in the cluster configuration, which is the case once it has
+// bootstrapped a cluster or the leader has replicated the configuration to it after it joined.
+func (s *Store) IsMember() (bool, error) {
+	nodes, err := s.NodeList()
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(nodes, func(node Node) bool { return node.NodeID == s.localID }), nil
+}
+
+// BootstrapCluster forms a new cluster with nodes as voters. All nodes must be started without bootstrapping,
+// exactly one of them calls BootstrapCluster and the others are brought up to date by the resulting leader.
+func (s *Store) BootstrapCluster(nodes []Node) error {
+	var servers []raft.Server
+	for _, node := range nodes {
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(node.NodeID),
+			Address: raft.ServerAddress(node.RaftAddr),
+		})
+	}
+
+	s.logger.Printf("bootstrapping cluster with %v", servers)
+	f := s.raft.BootstrapCluster(raft.Configuration{Servers: servers})
+	return f.Error()
+}
+
 func (s *Store) Leader() Node {
 	nodeAddr, nodeID := s.raft.LeaderWithID()
-	fmt.Println(nodeID, nodeAddr)
+	meta := s.nodeMeta(string(nodeID))
 	return Node{
 		NodeID:   string(nodeID),
 		RaftAddr: string(nodeAddr),
+		HTTPAddr: meta.HTTPAddr,
+		RESPAddr: meta.RESPAddr,
+		Version:  meta.Version,
 	}
 }
 
+func (s *Store) nodeMeta(nodeID string) NodeMeta {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nodes[nodeID]
+}
+
 func (s *Store) NodeList() ([]Node, error) {
 	configFuture := s.raft.GetConfiguration()
 	if err := configFuture.Error(); err != nil {
@@ -63,9 +153,13 @@ func (s *Store) NodeList() ([]Node, error) {
 	servers := configFuture.Configuration().Servers
 	nodes := []Node{}
 	for _, server := range servers {
+		meta := s.nodeMeta(string(server.ID))
 		nodes = append(nodes, Node{
 			NodeID:   string(server.ID),
 			RaftAddr: string(server.Address),
+			HTTPAddr: meta.HTTPAddr,
+			RESPAddr: meta.RESPAddr,
+			Version:  meta.Version,
 		})
 	}
 	return nodes, nil
@@ -75,3 +169,15 @@ func (s *Store) Snapshot() error {
 	f := s.raft.Snapshot()
 	return f.Error()
 }
+
+// Compact rewrites raft.db to reclaim the space left behind by logs deleted after snapshots.
+// Raft blocks on log reads and writes while compaction runs.
+func (s *Store) Compact() error {
+	s.logger.Printf("compacting %s", s.boltStore.path)
+	err := s.boltStore.Compact()
+	if errors.Is(err, ErrStoreClosed) {
+		// Raft can't read or write its log anymore, restarting reopens whichever db file is in place
+		s.logger.Fatalf("compaction left the log store unusable: %s", err)
+	}
+	return err
+}
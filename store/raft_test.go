@@ -1,6 +1,7 @@
 package store
 
 import (
+	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -60,3 +61,91 @@ func Test_RaftCommands(t *testing.T) {
 	assert.Equal(t, "node1", string(leader.NodeID))
 	assert.Equal(t, "127.0.0.1:0", string(leader.RaftAddr))
 }
+
+// Test_RaftNodeHTTPAddr tests that the leader advertises its addresses and version and records those of joining nodes.
+func Test_RaftNodeHTTPAddr(t *testing.T) {
+	s := NewStore()
+	os.Mkdir(testDir, os.ModePerm)
+	defer os.RemoveAll(testDir)
+
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = testDir
+	s.HTTPAddr = "127.0.0.1:11001"
+	s.RESPAddr = "127.0.0.1:6379"
+
+	err := s.Open(true, "node1")
+	assert.NoError(t, err, "failed to open store")
+	defer s.Close(false)
+
+	// Simple way to ensure there is a leader.
+	time.Sleep(2 * time.Second)
+
+	assert.Equal(t, Node{NodeID: "node1", RaftAddr: "127.0.0.1:0", HTTPAddr: "127.0.0.1:11001", RESPAddr: "127.0.0.1:6379",
+		Version: CommandVersion}, s.Leader())
+
+	// node2 never starts, so the cluster can't commit once it has joined
+	err = s.SetNodeMeta("node2", NodeMeta{HTTPAddr: "127.0.0.1:11002", Version: CommandVersion})
+	assert.NoError(t, err, "failed to set Node metadata")
+	err = s.AddNode("node2", "127.0.0.1:1")
+	assert.NoError(t, err, "new Node failed to join")
+
+	servers, err := s.NodeList()
+	assert.NoError(t, err, "failed getting Node list")
+	assert.Equal(t, []Node{
+		{NodeID: "node1", RaftAddr: "127.0.0.1:0", HTTPAddr: "127.0.0.1:11001", RESPAddr: "127.0.0.1:6379", Version: CommandVersion},
+		{NodeID: "node2", RaftAddr: "127.0.0.1:1", HTTPAddr: "127.0.0.1:11002", Version: CommandVersion},
+	}, servers)
+}
+
+// Test_RaftAddNodeNotLeader tests that nodes other than the leader refuse to add nodes.
+func Test_RaftAddNodeNotLeader(t *testing.T) {
+	s := NewStore()
+	os.Mkdir(testDir, os.ModePerm)
+	defer os.RemoveAll(testDir)
+
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = testDir
+
+	err := s.Open(false, "node1")
+	assert.NoError(t, err, "failed to open store")
+	defer s.Close(false)
+
+	err = s.AddNode("node2", "127.0.0.1:1")
+	assert.ErrorIs(t, err, ErrNotLeader)
+	err = s.SetNodeMeta("node2", NodeMeta{HTTPAddr: "127.0.0.1:11002"})
+	assert.ErrorIs(t, err, ErrNotLeader)
+}
+
+// Test_RaftBootstrapCluster tests that a node started without bootstrapping becomes a member once it bootstraps.
+func Test_RaftBootstrapCluster(t *testing.T) {
+	s := NewStore()
+	os.Mkdir(testDir, os.ModePerm)
+	defer os.RemoveAll(testDir)
+
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = testDir
+
+	err := s.Open(false, "node1")
+	assert.NoError(t, err, "failed to open store")
+	defer s.Close(false)
+
+	member, err := s.IsMember()
+	assert.NoError(t, err)
+	assert.False(t, member)
+
+	local := s.LocalNode()
+	assert.Equal(t, "node1", local.NodeID)
+	err = s.BootstrapCluster([]Node{local})
+	assert.NoError(t, err, "failed to bootstrap cluster")
+
+	member, err = s.IsMember()
+	assert.NoError(t, err)
+	assert.True(t, member)
+
+	// Simple way to ensure there is a leader.
+	time.Sleep(2 * time.Second)
+	assert.Equal(t, "node1", s.Leader().NodeID)
+
+	err = s.BootstrapCluster([]Node{local})
+	assert.ErrorIs(t, err, raft.ErrCantBootstrap)
+}
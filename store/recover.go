@@ -0,0 +1,214 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// RecoveryReport describes the state of a node's raft data and the configuration change made by Recover
+type RecoveryReport struct {
+	LastIndex     uint64
+	LastTerm      uint64
+	SnapshotIndex uint64
+
+	// Current is the latest configuration found in the node's snapshots and logs
+	Current []raft.Server
+	// Desired is the configuration the cluster is recovered to
+	Desired []raft.Server
+
+	Added   []raft.Server
+	Removed []raft.Server
+	Changed []raft.Server
+}
+
+// Recover forces the configuration of this node's raft data to configuration, so that a cluster which lost
+// quorum for good can be restarted from its surviving nodes. It must be run on every surviving node, with the
+// node stopped and the same configuration, before restarting the nodes without bootstrapping.
+//
+// With dryRun set, Recover only reports what would change and opens the raft data read-only.
+// The returned report is filled in both cases.
+func (s *Store) Recover(localID string, configuration raft.Configuration, dryRun bool) (*RecoveryReport, error) {
+	if len(configuration.Servers) == 0 {
+		return nil, fmt.Errorf("recovery configuration has no servers")
+	}
+	idx := slices.IndexFunc(configuration.Servers, func(server raft.Server) bool {
+		return server.ID == raft.ServerID(localID)
+	})
+	if idx < 0 {
+		return nil, fmt.Errorf("recovery configuration does not include this node %s", localID)
+	}
+	if configuration.Servers[idx].Suffrage != raft.Voter {
+		return nil, fmt.Errorf("recovery configuration must include this node %s as a voter", localID)
+	}
+
+	_, err := os.Stat(s.RaftDir)
+	if err != nil {
+		return nil, fmt.Errorf("raft dir: %w", err)
+	}
+	// raft.db holds the stable store, which a node that ever ran has written
+	_, err = os.Stat(filepath.Join(s.RaftDir, "raft.db"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no raft state found in %s, nothing to recover", s.RaftDir)
+	}
+
+	logs, snapshots, err := s.openStorage(dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("%w (is the node still running?)", err)
+	}
+	defer s.closeStorage()
+
+	hasState, err := raft.HasExistingState(logs, s.boltStore, snapshots)
+	if err != nil {
+		return nil, err
+	}
+	if !hasState {
+		return nil, fmt.Errorf("no raft state found in %s, nothing to recover", s.RaftDir)
+	}
+
+	report, err := newRecoveryReport(logs, snapshots, configuration)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		return report, nil
+	}
+
+	config := s.raftConfig(localID)
+	_, transport := raft.NewInmemTransport(raft.ServerAddress(s.RaftAddr))
+
+	err = raft.RecoverCluster(config, (*fsm)(s), logs, s.boltStore, snapshots, transport, configuration)
+	if err != nil {
+		return nil, fmt.Errorf("recover cluster: %w", err)
+	}
+
+	s.logger.Printf("recovered cluster configuration to %v", configuration.Servers)
+	return report, nil
+}
+
+func newRecoveryReport(logs raft.LogStore, snapshots raft.SnapshotStore, configuration raft.Configuration) (*RecoveryReport, error) {
+	report := &RecoveryReport{Desired: configuration.Servers}
+
+	snaps, err := snapshots.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(snaps) > 0 {
+		report.SnapshotIndex = snaps[0].Index
+		report.LastIndex = snaps[0].Index
+		report.LastTerm = snaps[0].Term
+		report.Current = snaps[0].Configuration.Servers
+	}
+
+	first, err := logs.FirstIndex()
+	if err != nil {
+		return nil, err
+	}
+	last, err := logs.LastIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := last; idx >= first && idx > 0; idx-- {
+		var entry raft.Log
+		err := logs.GetLog(idx, &entry)
+		if errors.Is(err, raft.ErrLogNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if idx == last && entry.Index > report.LastIndex {
+			report.LastIndex = entry.Index
+			report.LastTerm = entry.Term
+		}
+		if entry.Index <= report.SnapshotIndex {
+			break
+		}
+		if entry.Type == raft.LogConfiguration {
+			report.Current, err = decodeConfiguration(entry.Data)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	for _, desired := range report.Desired {
+		i := slices.IndexFunc(report.Current, func(server raft.Server) bool { return server.ID == desired.ID })
+		switch {
+		case i < 0:
+			report.Added = append(report.Added, desired)
+		case report.Current[i] != desired:
+			report.Changed = append(report.Changed, desired)
+		}
+	}
+	for _, current := range report.Current {
+		if !slices.ContainsFunc(report.Desired, func(server raft.Server) bool { return server.ID == current.ID }) {
+			report.Removed = append(report.Removed, current)
+		}
+	}
+
+	return report, nil
+}
+
+// readOnlySnapshotStore lists the snapshots of a raft.FileSnapshotStore in the directory it names
+// without creating the directory, as raft.NewFileSnapshotStore does. Snapshots can't be created or opened.
+type readOnlySnapshotStore string
+
+func (dir readOnlySnapshotStore) List() ([]*raft.SnapshotMeta, error) {
+	entries, err := os.ReadDir(string(dir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snaps []*raft.SnapshotMeta
+	for _, entry := range entries {
+		// Snapshots still being written end with .tmp
+		if !entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		buf, err := os.ReadFile(filepath.Join(string(dir), entry.Name(), "meta.json"))
+		if err != nil {
+			continue
+		}
+		meta := new(raft.SnapshotMeta)
+		err = json.Unmarshal(buf, meta)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", entry.Name(), err)
+		}
+		snaps = append(snaps, meta)
+	}
+
+	// Newest first, as raft.FileSnapshotStore lists them
+	sort.Slice(snaps, func(i, j int) bool {
+		a, b := snaps[i], snaps[j]
+		if a.Term != b.Term {
+			return a.Term > b.Term
+		}
+		if a.Index != b.Index {
+			return a.Index > b.Index
+		}
+		return a.ID > b.ID
+	})
+	return snaps, nil
+}
+
+func (dir readOnlySnapshotStore) Create(raft.SnapshotVersion, uint64, uint64, raft.Configuration, uint64, raft.Transport) (raft.SnapshotSink, error) {
+	return nil, fmt.Errorf("snapshot store %s is read-only", string(dir))
+}
+
+func (dir readOnlySnapshotStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	return nil, nil, fmt.Errorf("snapshot store %s is read-only", string(dir))
+}
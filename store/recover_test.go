@@ -0,0 +1,93 @@
+package store
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test_StoreRecover tests that a node which lost its peers for good can be recovered to a single node cluster
+func Test_StoreRecover(t *testing.T) {
+	s := NewStore()
+	os.Mkdir(testDir, os.ModePerm)
+	defer os.RemoveAll(testDir)
+
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = testDir
+
+	// Nothing to recover before the node ever ran
+	configuration := raft.Configuration{Servers: []raft.Server{
+		{Suffrage: raft.Voter, ID: "node1", Address: "127.0.0.1:0"},
+	}}
+	_, err := s.Recover("node1", configuration, true)
+	assert.ErrorContains(t, err, "nothing to recover")
+
+	err = s.Open(true, "node1")
+	assert.NoError(t, err, "failed to open store")
+
+	// Simple way to ensure there is a leader.
+	time.Sleep(2 * time.Second)
+
+	err = s.Set("foo", "bar")
+	assert.NoError(t, err, "failed to set key")
+
+	// node2 is lost, leaving node1 without quorum
+	err = s.AddNode("node2", "127.0.0.1:1")
+	assert.NoError(t, err)
+
+	err = s.Close(false)
+	assert.NoError(t, err)
+
+	// The configuration must include this node
+	_, err = s.Recover("node2", configuration, true)
+	assert.ErrorContains(t, err, "does not include this node")
+
+	s = NewStore()
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = testDir
+
+	// A dry run leaves the raft data untouched
+	before, err := os.Stat(filepath.Join(testDir, "raft.db"))
+	assert.NoError(t, err)
+
+	report, err := s.Recover("node1", configuration, true)
+	assert.NoError(t, err)
+	after, err := os.Stat(filepath.Join(testDir, "raft.db"))
+	assert.NoError(t, err)
+	assert.Equal(t, before.ModTime(), after.ModTime())
+	assert.Equal(t, before.Size(), after.Size())
+	assert.Equal(t, configuration.Servers, report.Desired)
+	assert.Len(t, report.Current, 2)
+	assert.Empty(t, report.Added)
+	assert.Equal(t, "node2", string(report.Removed[0].ID))
+	assert.NotZero(t, report.LastIndex)
+
+	_, err = s.Recover("node1", configuration, false)
+	assert.NoError(t, err)
+
+	// Dry run after recovery shows nothing left to change
+	report, err = s.Recover("node1", configuration, true)
+	assert.NoError(t, err)
+	assert.Empty(t, report.Added)
+	assert.Empty(t, report.Removed)
+	assert.Empty(t, report.Changed)
+
+	// The node elects itself leader on restart and keeps its data
+	s = NewStore()
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = testDir
+	err = s.Open(false, "node1")
+	assert.NoError(t, err, "failed to open store")
+	time.Sleep(2 * time.Second)
+
+	assert.Equal(t, raft.Leader, s.raft.State())
+	value, _ := s.Get("foo")
+	assert.Equal(t, "bar", value)
+
+	servers, err := s.NodeList()
+	assert.NoError(t, err)
+	assert.Equal(t, []Node{{NodeID: "node1", RaftAddr: "127.0.0.1:0"}}, servers)
+}
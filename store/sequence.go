@@ -0,0 +1,35 @@
+package store
+
+import (
+	"fmt"
+	"math"
+)
+
+// MaxSequenceCount is the largest range of IDs handed out by NextSequence at once
+const MaxSequenceCount = 1000000
+
+// NextSequence hands out count IDs of the sequence name, via distributed consensus, and returns the first one.
+// The IDs of a sequence start at 1 and increase, the range handed out is [first, first+count-1]. The high-water
+// mark of the sequence is committed to the raft log before the IDs are returned, so that no ID is handed out twice,
+// even across leader failovers. IDs handed out to clients that didn't receive them are skipped.
+func (s *Store) NextSequence(name string, count uint64) (uint64, error) {
+	if count == 0 || count > MaxSequenceCount {
+		return 0, fmt.Errorf("sequence count must be between 1 and %d", MaxSequenceCount)
+	}
+
+	resp, err := s.propose(command{Op: CmdSequence, Key: name, Count: count})
+	if err != nil {
+		return 0, err
+	}
+	return resp.(uint64), nil
+}
+
+// applySequence raises the high-water mark of the sequence by count and returns the first ID of the range
+func (f *fsm) applySequence(name string, count uint64) interface{} {
+	last := f.sequences[name]
+	if count > math.MaxUint64-last {
+		return ErrOutOfRange
+	}
+	f.sequences[name] = last + count
+	return last + 1
+}
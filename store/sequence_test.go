@@ -0,0 +1,38 @@
+package store
+
+import (
+	"bytes"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"testing"
+)
+
+// Test_Sequence tests that sequences hand out consecutive ranges that survive snapshots.
+func Test_Sequence(t *testing.T) {
+	s := NewStore()
+	assert.Equal(t, uint64(1), applyCommand(t, s, 1, command{Op: CmdSequence, Key: "orders", Count: 1000}))
+	assert.Equal(t, uint64(1001), applyCommand(t, s, 2, command{Op: CmdSequence, Key: "orders", Count: 1}))
+	assert.Equal(t, uint64(1), applyCommand(t, s, 3, command{Op: CmdSequence, Key: "users", Count: 10}))
+
+	// A node restored from a snapshot continues after the high-water mark
+	snapshot, err := (*fsm)(s).Snapshot()
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	err = snapshot.Persist(&testSnapshotSink{DiscardSnapshotSink: &raft.DiscardSnapshotSink{}, buf: buf})
+	assert.NoError(t, err)
+	restored := NewStore()
+	err = (*fsm)(restored).Restore(io.NopCloser(buf))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1002), applyCommand(t, restored, 4, command{Op: CmdSequence, Key: "orders", Count: 5}))
+
+	restored.sequences["full"] = math.MaxUint64 - 1
+	assert.Equal(t, ErrOutOfRange, applyCommand(t, restored, 5, command{Op: CmdSequence, Key: "full", Count: 2}))
+	assert.Equal(t, uint64(math.MaxUint64), applyCommand(t, restored, 6, command{Op: CmdSequence, Key: "full", Count: 1}))
+
+	_, err = s.NextSequence("orders", 0)
+	assert.Error(t, err)
+	_, err = s.NextSequence("orders", MaxSequenceCount+1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,270 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"sort"
+	"time"
+)
+
+// Bounds of the time to live of sessions
+const (
+	MinSessionTTL = time.Second
+	MaxSessionTTL = 24 * time.Hour
+)
+
+var (
+	// ErrSessionNotFound is returned for sessions that don't exist or have expired
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrLocked is returned by Lock for keys locked by another session, and by the other writes of locked keys,
+	// which only their holder changes, with Lock and Unlock
+	ErrLocked = errors.New("key is locked by another session")
+	// ErrNotLockHolder is returned by Unlock for keys not locked by the session
+	ErrNotLockHolder = errors.New("key is not locked by the session")
+)
+
+// Session is created by a client and kept alive by its heartbeats, each renewal extending ExpiresAt,
+// in unix nanoseconds, to TTL from the renewal. The leader destroys expired sessions, releasing their locks.
+type Session struct {
+	ID        string        `json:"id"`
+	TTL       time.Duration `json:"ttl"`
+	ExpiresAt int64         `json:"expires_at"`
+}
+
+// Lock binds a key to the session holding it. Token is the raft index of the acquisition of the lock, it increases
+// with every acquisition so that the holders of a key can be told apart, fencing off the writes of former holders.
+type Lock struct {
+	Session string `json:"session"`
+	Token   uint64 `json:"token"`
+}
+
+// CreateSession creates a session expiring after ttl unless it is renewed, via distributed consensus
+func (s *Store) CreateSession(ttl time.Duration) (Session, error) {
+	if ttl < MinSessionTTL || ttl > MaxSessionTTL {
+		return Session{}, fmt.Errorf("session ttl must be between %s and %s", MinSessionTTL, MaxSessionTTL)
+	}
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return Session{}, err
+	}
+
+	session := Session{ID: hex.EncodeToString(id), TTL: ttl, ExpiresAt: time.Now().Add(ttl).UnixNano()}
+	resp, err := s.propose(command{Op: CmdSessionCreate, Session: &session})
+	if err != nil {
+		return Session{}, err
+	}
+	return resp.(Session), nil
+}
+
+// RenewSession extends the session with ID id to its TTL from now, via distributed consensus
+func (s *Store) RenewSession(id string) (Session, error) {
+	resp, err := s.propose(command{Op: CmdSessionRenew, SessionID: id, Now: time.Now().UnixNano()})
+	if err != nil {
+		return Session{}, err
+	}
+	return resp.(Session), nil
+}
+
+// DestroySession destroys the session with ID id and releases its locks, via distributed consensus
+func (s *Store) DestroySession(id string) error {
+	_, err := s.propose(command{Op: CmdSessionDestroy, SessionID: id})
+	return err
+}
+
+// Session returns the session with ID id and whether it exists
+func (s *Store) Session(id string) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[id]
+	if !ok || session.ExpiresAt <= time.Now().UnixNano() {
+		return Session{}, false
+	}
+	return session, true
+}
+
+// Lock locks key for the session with ID sessionID and sets it to value, via distributed consensus. It returns
+// the fencing token of the lock. Locking a key the session already holds sets the value and keeps the token.
+// The key is deleted when it is unlocked or the session is destroyed.
+func (s *Store) Lock(key, value, sessionID string) (uint64, error) {
+	err := s.Limits.CheckKeyValue(key, value)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.propose(command{Op: CmdLock, Key: key, Value: value, SessionID: sessionID, Now: time.Now().UnixNano()})
+	if err != nil {
+		return 0, err
+	}
+	return resp.(uint64), nil
+}
+
+// Unlock releases the lock on key held by the session with ID sessionID and deletes the key, via distributed consensus
+func (s *Store) Unlock(key, sessionID string) error {
+	_, err := s.propose(command{Op: CmdUnlock, Key: key, SessionID: sessionID})
+	return err
+}
+
+// Holder returns the lock on key and whether the key is locked
+func (s *Store) Holder(key string) (Lock, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, ok := s.locks[key]
+	return lock, ok
+}
+
+// propose applies c via distributed consensus and returns the response of the FSM, or the error it responded with
+func (s *Store) propose(c command) (interface{}, error) {
+	if s.raft.State() != raft.Leader {
+		return nil, ErrNotLeader
+	}
+	err := s.checkVersion(c)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.applyCommand(c)
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := resp.(error); ok {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// expireSessions destroys up to expireBatch expired sessions
+func (s *Store) expireSessions() {
+	for id, expiresAt := range s.expiredSessions() {
+		cmd, err := json.Marshal(command{
+			Op:        CmdSessionDestroy,
+			SessionID: id,
+			ExpiresAt: expiresAt,
+		})
+		if err != nil {
+			s.logger.Printf("failed to expire session %s: %s", id, err)
+			continue
+		}
+
+		err = s.raft.Apply(cmd, raftTimeout).Error()
+		if err != nil {
+			s.logger.Printf("failed to expire session %s: %s", id, err)
+			return
+		}
+	}
+}
+
+// expiredSessions returns up to expireBatch expired sessions with their expiry time
+func (s *Store) expiredSessions() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	sessions := make(map[string]int64)
+	for id, session := range s.sessions {
+		if session.ExpiresAt > now {
+			continue
+		}
+		sessions[id] = session.ExpiresAt
+		if len(sessions) == expireBatch {
+			break
+		}
+	}
+	return sessions
+}
+
+func (f *fsm) applySessionCreate(session *Session) interface{} {
+	f.sessions[session.ID] = *session
+	return *session
+}
+
+// applySessionRenew extends the session to its TTL from now, unless it has expired
+func (f *fsm) applySessionRenew(id string, now int64) interface{} {
+	session, ok := f.sessions[id]
+	if !ok || session.ExpiresAt <= now {
+		return ErrSessionNotFound
+	}
+	session.ExpiresAt = now + int64(session.TTL)
+	f.sessions[id] = session
+	return session
+}
+
+// applySessionDestroy destroys the session and releases its locks. If expiresAt is not zero, the session
+// is destroyed only if it was not renewed since the leader found it expired.
+func (f *fsm) applySessionDestroy(index uint64, id string, expiresAt int64) interface{} {
+	session, ok := f.sessions[id]
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if expiresAt != 0 && session.ExpiresAt != expiresAt {
+		return nil
+	}
+
+	delete(f.sessions, id)
+	var keys []string
+	for key, lock := range f.locks {
+		if lock.Session == id {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		f.release(index, key)
+	}
+	return nil
+}
+
+// applyLock locks key for the session and returns the fencing token of the lock
+func (f *fsm) applyLock(index uint64, key, value, sessionID string, now int64) interface{} {
+	session, ok := f.sessions[sessionID]
+	if !ok || session.ExpiresAt <= now {
+		return ErrSessionNotFound
+	}
+	lock, ok := f.locks[key]
+	if ok && lock.Session != sessionID {
+		return ErrLocked
+	}
+
+	err := f.checkQuotas([]TxnOp{{Op: CmdSet, Key: key, Value: value}})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		lock = Lock{Session: sessionID, Token: index}
+		f.locks[key] = lock
+	}
+	f.put(index, key, value)
+	delete(f.expires, key)
+	f.notify(Event{Type: CmdSet, Key: key, Value: value, Index: index})
+	return lock.Token
+}
+
+func (f *fsm) applyUnlock(index uint64, key, sessionID string) interface{} {
+	lock, ok := f.locks[key]
+	if !ok || lock.Session != sessionID {
+		return ErrNotLockHolder
+	}
+	f.release(index, key)
+	return nil
+}
+
+// checkLocks returns an error matching ErrLocked if one of the keys of ops is locked, so that no write other than
+// Lock and Unlock takes a key from the session holding it. It must be called with f.mu held.
+func (f *fsm) checkLocks(ops []TxnOp) error {
+	for _, op := range ops {
+		if _, ok := f.locks[op.Key]; ok {
+			_, local := SplitKey(op.Key)
+			return fmt.Errorf("%w: %s", ErrLocked, local)
+		}
+	}
+	return nil
+}
+
+// release deletes the locked key. It must be called with f.mu held.
+func (f *fsm) release(index uint64, key string) {
+	f.remove(key)
+	f.notify(Event{Type: CmdDelete, Key: key, Index: index})
+}
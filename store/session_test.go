@@ -0,0 +1,107 @@
+package store
+
+import (
+	"bytes"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"time"
+)
+
+// Test_SessionLocks tests that locks are held by one session at a time and released when the session is destroyed.
+func Test_SessionLocks(t *testing.T) {
+	s := NewStore()
+	now := time.Now().UnixNano()
+	ttl := 10 * time.Second
+
+	applyCommand(t, s, 1, command{Op: CmdSessionCreate, Session: &Session{ID: "s1", TTL: ttl, ExpiresAt: now + int64(ttl)}})
+	applyCommand(t, s, 2, command{Op: CmdSessionCreate, Session: &Session{ID: "s2", TTL: ttl, ExpiresAt: now + int64(ttl)}})
+
+	lock := func(index uint64, key, value, session string) interface{} {
+		return applyCommand(t, s, index, command{Op: CmdLock, Key: key, Value: value, SessionID: session, Now: now})
+	}
+	assert.Equal(t, uint64(3), lock(3, "leader", "worker1", "s1"))
+	assert.Equal(t, ErrLocked, lock(4, "leader", "worker2", "s2"))
+	assert.Equal(t, ErrSessionNotFound, lock(5, "leader", "worker3", "s3"))
+
+	// Locking again keeps the token
+	assert.Equal(t, uint64(3), lock(6, "leader", "worker1", "s1"))
+	value, _ := s.Get("leader")
+	assert.Equal(t, "worker1", value)
+
+	assert.Equal(t, ErrNotLockHolder, applyCommand(t, s, 7, command{Op: CmdUnlock, Key: "leader", SessionID: "s2"}))
+	assert.Nil(t, applyCommand(t, s, 8, command{Op: CmdUnlock, Key: "leader", SessionID: "s1"}))
+	_, ok := s.Get("leader")
+	assert.False(t, ok)
+
+	// Tokens increase with every acquisition
+	assert.Equal(t, uint64(9), lock(9, "leader", "worker2", "s2"))
+	assert.Equal(t, uint64(10), lock(10, "other", "worker2", "s2"))
+
+	// Renewed sessions are not destroyed by the leader, which found them expired before
+	renewed := applyCommand(t, s, 11, command{Op: CmdSessionRenew, SessionID: "s2", Now: now + int64(time.Second)})
+	assert.Equal(t, Session{ID: "s2", TTL: ttl, ExpiresAt: now + int64(time.Second+ttl)}, renewed)
+	applyCommand(t, s, 12, command{Op: CmdSessionDestroy, SessionID: "s2", ExpiresAt: now + int64(ttl)})
+	assert.Contains(t, s.locks, "leader")
+
+	assert.Nil(t, applyCommand(t, s, 13, command{Op: CmdSessionDestroy, SessionID: "s2"}))
+	assert.Empty(t, s.locks)
+	assert.Equal(t, []string{}, s.Keys())
+	assert.Equal(t, ErrSessionNotFound, applyCommand(t, s, 14, command{Op: CmdSessionRenew, SessionID: "s2", Now: now}))
+
+	// Expired sessions can't be renewed or lock keys
+	assert.Equal(t, ErrSessionNotFound, applyCommand(t, s, 15, command{Op: CmdSessionRenew, SessionID: "s1", Now: now + int64(ttl)}))
+	assert.Equal(t, ErrSessionNotFound, applyCommand(t, s, 16, command{Op: CmdLock, Key: "leader", SessionID: "s1", Now: now + int64(ttl)}))
+}
+
+// Test_LockedKeyWrites tests that writes other than Lock and Unlock can't change locked keys or release their lock.
+func Test_LockedKeyWrites(t *testing.T) {
+	s := NewStore()
+	now := time.Now().UnixNano()
+	ttl := 10 * time.Second
+	applyCommand(t, s, 1, command{Op: CmdSessionCreate, Session: &Session{ID: "s1", TTL: ttl, ExpiresAt: now + int64(ttl)}})
+	applyCommand(t, s, 2, command{Op: CmdLock, Key: "leader", Value: "worker1", SessionID: "s1", Now: now})
+
+	assert.ErrorIs(t, applyCommand(t, s, 3, command{Op: CmdSet, Key: "leader", Value: "worker2"}).(error), ErrLocked)
+	assert.ErrorIs(t, applyCommand(t, s, 4, command{Op: CmdDelete, Key: "leader"}).(error), ErrLocked)
+	assert.ErrorIs(t, applyCommand(t, s, 5, command{Op: CmdIncr, Incr: &Incr{Key: "leader", Delta: 1}}).(error), ErrLocked)
+	txn := &Txn{Success: []TxnOp{{Op: CmdSet, Key: "k1", Value: "v1"}, {Op: CmdDelete, Key: "leader"}}}
+	assert.ErrorIs(t, applyCommand(t, s, 6, command{Op: CmdTxn, Txn: txn}).(error), ErrLocked)
+	resps := applyCommand(t, s, 7, command{Op: CmdBatch, Batch: []command{
+		{Op: CmdSet, Key: "k2", Value: "v2"},
+		{Op: CmdSet, Key: "leader", Value: "worker2"},
+	}}).([]interface{})
+	assert.Nil(t, resps[0])
+	assert.ErrorIs(t, resps[1].(error), ErrLocked)
+
+	// An expiry found by the leader before the key was locked doesn't delete it
+	s.expires["leader"] = now
+	assert.Nil(t, applyCommand(t, s, 8, command{Op: CmdExpire, Key: "leader", ExpiresAt: now}))
+	assert.NotContains(t, s.expires, "leader")
+
+	value, _ := s.Get("leader")
+	assert.Equal(t, "worker1", value)
+	assert.Equal(t, Lock{Session: "s1", Token: 2}, s.locks["leader"])
+	assert.NotContains(t, s.kv, "k1")
+	assert.Equal(t, "v2", s.kv["k2"])
+}
+
+// Test_SessionSnapshot tests that snapshots restore sessions and locks.
+func Test_SessionSnapshot(t *testing.T) {
+	s := NewStore()
+	s.sessions["s1"] = Session{ID: "s1", TTL: time.Minute, ExpiresAt: 42}
+	s.locks["leader"] = Lock{Session: "s1", Token: 7}
+
+	snapshot, err := (*fsm)(s).Snapshot()
+	assert.NoError(t, err)
+	buf := &bytes.Buffer{}
+	err = snapshot.Persist(&testSnapshotSink{DiscardSnapshotSink: &raft.DiscardSnapshotSink{}, buf: buf})
+	assert.NoError(t, err)
+
+	restored := NewStore()
+	err = (*fsm)(restored).Restore(io.NopCloser(buf))
+	assert.NoError(t, err)
+	assert.Equal(t, s.sessions, restored.sessions)
+	assert.Equal(t, s.locks, restored.locks)
+}
@@ -2,8 +2,10 @@ package store
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hashicorp/raft"
+	"go.etcd.io/bbolt"
 	"golang.org/x/exp/maps"
 	"io"
 	"log"
@@ -16,15 +18,79 @@ import (
 
 const (
 	retainSnapshotCount = 2
+	logCacheSize        = 8 << 20
+	boltOpenTimeout     = time.Second
 	raftTimeout         = 10 * time.Second
 	CmdSet              = "SET"
 	CmdDelete           = "DELETE"
+	CmdTxn              = "TXN"
+	// CmdExpire deletes Key if it still expires at ExpiresAt
+	CmdExpire = "EXPIRE"
+	// CmdIncr adds to the integer value of a key
+	CmdIncr = "INCR"
+	// CmdSessionCreate, CmdSessionRenew and CmdSessionDestroy create, renew and destroy a session,
+	// CmdLock and CmdUnlock lock and unlock Key for the session with ID SessionID
+	CmdSessionCreate  = "SESSION_CREATE"
+	CmdSessionRenew   = "SESSION_RENEW"
+	CmdSessionDestroy = "SESSION_DESTROY"
+	CmdLock           = "LOCK"
+	CmdUnlock         = "UNLOCK"
+	// CmdSequence hands out Count IDs of the sequence named Key
+	CmdSequence = "SEQUENCE"
+	// CmdNamespace creates the namespace named Key or updates its Quota, CmdNamespaceDelete deletes it
+	CmdNamespace       = "NAMESPACE"
+	CmdNamespaceDelete = "NAMESPACE_DELETE"
+	// CmdNodeMeta records the HTTP address (Value) of the node with ID Key
+	CmdNodeMeta = "NODE_META"
+	// CmdBatch applies the writes coalesced by the Store in one log entry
+	CmdBatch = "BATCH"
+
+	// snapshotVersion is the version of the snapshot format written by fsmSnapshot
+	snapshotVersion = 1
 )
 
+var ErrNotLeader = errors.New("not leader")
+
+// LogBackend names the raft.LogStore implementation used to persist raft logs
+type LogBackend string
+
+const (
+	// LogBackendBolt stores raft logs in raft.db alongside the stable store
+	LogBackendBolt LogBackend = "bolt"
+	// LogBackendWAL stores raft logs in a segmented write-ahead log under the wal directory,
+	// favouring sequential write throughput
+	LogBackendWAL LogBackend = "wal"
+)
+
+// RaftOptions tunes raft. Zero values keep the defaults of raft.DefaultConfig and retainSnapshotCount.
+type RaftOptions struct {
+	HeartbeatTimeout  time.Duration
+	ElectionTimeout   time.Duration
+	CommitTimeout     time.Duration
+	SnapshotInterval  time.Duration
+	SnapshotThreshold uint64
+	TrailingLogs      uint64
+
+	// RetainSnapshotCount is the number of snapshots kept on disk
+	RetainSnapshotCount int
+}
+
 type command struct {
-	Op    string `json:"op,omitempty"`
-	Key   string `json:"key,omitempty"`
-	Value string `json:"value,omitempty"`
+	Op        string    `json:"op,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	Value     string    `json:"value,omitempty"`
+	Txn       *Txn      `json:"txn,omitempty"`
+	Incr      *Incr     `json:"incr,omitempty"`
+	ExpiresAt int64     `json:"expires_at,omitempty"`
+	Session   *Session  `json:"session,omitempty"`
+	SessionID string    `json:"session_id,omitempty"`
+	Count     uint64    `json:"count,omitempty"`
+	Quota     *Quota    `json:"quota,omitempty"`
+	Batch     []command `json:"batch,omitempty"`
+	Meta      *NodeMeta `json:"meta,omitempty"`
+	// Now is the time of the leader proposing the command in unix nanoseconds, for commands depending on
+	// whether a session has expired
+	Now int64 `json:"now,omitempty"`
 }
 
 type Store struct {
@@ -33,23 +99,101 @@ type Store struct {
 	// The key-value store for the system.
 	kv map[string]string
 
-	raft   *raft.Raft
-	logger *log.Logger
+	// expires maps the keys with a time to live to their expiry time, in unix nanoseconds
+	expires map[string]int64
+
+	// revisions maps every key to the raft index of its last change
+	revisions map[string]uint64
+
+	// sessions maps the ID of every session to the session
+	sessions map[string]Session
+
+	// locks maps the locked keys to their lock
+	locks map[string]Lock
+
+	// sequences maps the name of every sequence to the last ID it handed out
+	sequences map[string]uint64
+
+	// namespaces maps the name of every namespace to its quota and usage
+	namespaces map[string]*namespace
+
+	// nodes maps the ID of every node that joined the cluster to its metadata
+	nodes map[string]NodeMeta
+
+	// watchers receive the changes applied to kv
+	watchers map[*watcher]struct{}
+
+	// quarantined holds the last log entries the FSM could not apply
+	quarantined []QuarantinedEntry
+
+	raft      *raft.Raft
+	transport *raft.NetworkTransport
+	boltStore *BoltStore
+	wal       *WAL
+	logger    *log.Logger
+
+	// localID is the ID of this node, set by Open
+	localID string
+
+	// shutdownCh is closed by Close to stop the goroutines started by Open
+	shutdownCh chan struct{}
+
+	// batchCh receives the writes to coalesce into batches
+	batchCh chan *batchOp
 
 	RaftDir  string
 	RaftAddr string
+	// HTTPAddr is the address the HTTP API of this node is served at, advertised to the other nodes
+	HTTPAddr string
+	// RESPAddr is the address the Redis protocol of this node is served at, if any, advertised with HTTPAddr
+	RESPAddr string
+
+	// LogBackend selects where raft logs are stored. Defaults to LogBackendBolt.
+	LogBackend LogBackend
+
+	RaftOptions RaftOptions
+
+	// MaxBatchSize is the maximum number of concurrent Set and Delete calls coalesced into one raft log entry,
+	// 1 disables batching. Defaults to DefaultMaxBatchSize.
+	MaxBatchSize int
+	// MaxBatchBytes is the maximum size of the keys and values of the writes coalesced into one raft log entry,
+	// so that batches stay within the limits of a single write. 0 doesn't limit. Defaults to DefaultMaxBatchBytes.
+	MaxBatchBytes int
+	// BatchWindow is how long a write waits for concurrent writes to join its batch. With the default of zero,
+	// batches only take the writes arriving while the previous batch is committed, adding no latency.
+	BatchWindow time.Duration
+
+	// Limits bound the size of the writes. Defaults to DefaultLimits.
+	Limits Limits
 }
 
 func NewStore() *Store {
-	return &Store{
-		kv:     make(map[string]string),
-		logger: log.New(os.Stderr, "store: ", log.LstdFlags),
+	s := &Store{
+		kv:         make(map[string]string),
+		expires:    make(map[string]int64),
+		revisions:  make(map[string]uint64),
+		sessions:   make(map[string]Session),
+		locks:      make(map[string]Lock),
+		sequences:  make(map[string]uint64),
+		namespaces: map[string]*namespace{DefaultNamespace: {}},
+		nodes:      make(map[string]NodeMeta),
+		watchers:   make(map[*watcher]struct{}),
+		logger:     log.New(os.Stderr, "store: ", log.LstdFlags),
+
+		MaxBatchSize:  DefaultMaxBatchSize,
+		MaxBatchBytes: DefaultMaxBatchBytes,
+		Limits:        DefaultLimits,
 	}
+	return s
 }
 
 func (s *Store) Open(bootstrapCluster bool, localID string) error {
-	config := raft.DefaultConfig()
-	config.LocalID = raft.ServerID(localID)
+	s.localID = localID
+	config := s.raftConfig(localID)
+	err := raft.ValidateConfig(config)
+	if err != nil {
+		return fmt.Errorf("raft config: %s", err)
+	}
 
 	tcpAddr, err := net.ResolveTCPAddr("tcp", s.RaftAddr)
 	if err != nil {
@@ -59,19 +203,23 @@ func (s *Store) Open(bootstrapCluster bool, localID string) error {
 	if err != nil {
 		return err
 	}
+	s.transport = transport
 
-	snapshots, err := raft.NewFileSnapshotStore(s.RaftDir, retainSnapshotCount, os.Stderr)
+	backend, snapshots, err := s.openStorage(false)
 	if err != nil {
-		return fmt.Errorf("file snapshot store: %s", err)
+		transport.Close()
+		return err
 	}
 
-	boltStore, err := NewBoltStore(filepath.Join(s.RaftDir, "raft.db"))
+	logStore, err := NewLogCache(logCacheSize, backend)
 	if err != nil {
-		return fmt.Errorf("new bbolt store: %s", err)
+		return fmt.Errorf("new log cache: %s", err)
 	}
 
-	s.raft, err = raft.NewRaft(config, (*fsm)(s), boltStore, boltStore, snapshots, transport)
+	s.raft, err = raft.NewRaft(config, (*fsm)(s), logStore, s.boltStore, snapshots, transport)
 	if err != nil {
+		transport.Close()
+		s.closeStorage()
 		return fmt.Errorf("new raft: %s", err)
 	}
 
@@ -85,54 +233,264 @@ func (s *Store) Open(bootstrapCluster bool, localID string) error {
 		})
 	}
 
+	s.shutdownCh = make(chan struct{})
+	s.batchCh = make(chan *batchOp)
+	go s.advertiseOnLeadership(localID)
+	go s.runBatches()
+	go s.expireKeys()
+
 	return nil
 }
 
-func (s *Store) Get(key string) string {
+// advertiseOnLeadership records the HTTP address of this node in the cluster each time it becomes leader,
+// so that followers can direct clients to it. Nodes joining the cluster are recorded by the leader on join.
+func (s *Store) advertiseOnLeadership(localID string) {
+	for {
+		var isLeader bool
+		select {
+		case isLeader = <-s.raft.LeaderCh():
+		case <-s.shutdownCh:
+			return
+		}
+		if !isLeader || s.HTTPAddr == "" {
+			continue
+		}
+
+		err := s.SetNodeMeta(localID, NodeMeta{HTTPAddr: s.HTTPAddr, RESPAddr: s.RESPAddr, Version: CommandVersion})
+		if err != nil {
+			s.logger.Printf("failed to advertise HTTP address %s: %s", s.HTTPAddr, err)
+		}
+	}
+}
+
+// Close shuts raft down and closes the raft transport and stores. With finalSnapshot set, a snapshot
+// is taken first so that the node restarts from it instead of replaying its whole log.
+func (s *Store) Close(finalSnapshot bool) error {
+	var err error
+	if finalSnapshot {
+		err = s.raft.Snapshot().Error()
+		if errors.Is(err, raft.ErrNothingNewToSnapshot) {
+			err = nil
+		}
+		if err != nil {
+			err = fmt.Errorf("final snapshot: %w", err)
+		}
+	}
+
+	close(s.shutdownCh)
+	err = errors.Join(err, s.raft.Shutdown().Error())
+	err = errors.Join(err, s.transport.Close())
+	return errors.Join(err, s.closeStorage())
+}
+
+// raftConfig returns raft.DefaultConfig tuned with s.RaftOptions
+func (s *Store) raftConfig(localID string) *raft.Config {
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(localID)
+
+	opts := s.RaftOptions
+	if opts.HeartbeatTimeout != 0 {
+		config.HeartbeatTimeout = opts.HeartbeatTimeout
+		// the leader lease can't exceed the heartbeat timeout
+		config.LeaderLeaseTimeout = min(config.LeaderLeaseTimeout, opts.HeartbeatTimeout)
+	}
+	if opts.ElectionTimeout != 0 {
+		config.ElectionTimeout = opts.ElectionTimeout
+	}
+	if opts.CommitTimeout != 0 {
+		config.CommitTimeout = opts.CommitTimeout
+	}
+	if opts.SnapshotInterval != 0 {
+		config.SnapshotInterval = opts.SnapshotInterval
+	}
+	if opts.SnapshotThreshold != 0 {
+		config.SnapshotThreshold = opts.SnapshotThreshold
+	}
+	if opts.TrailingLogs != 0 {
+		config.TrailingLogs = opts.TrailingLogs
+	}
+	return config
+}
+
+// openStorage opens the snapshot store, the stable store and the raft log store kept in s.RaftDir.
+// With readOnly set nothing in s.RaftDir is created or modified, and the stores can only be read.
+func (s *Store) openStorage(readOnly bool) (raft.LogStore, raft.SnapshotStore, error) {
+	retain := s.RaftOptions.RetainSnapshotCount
+	if retain == 0 {
+		retain = retainSnapshotCount
+	}
+
+	var snapshots raft.SnapshotStore
+	var err error
+	if readOnly {
+		snapshots = readOnlySnapshotStore(filepath.Join(s.RaftDir, "snapshots"))
+	} else {
+		snapshots, err = raft.NewFileSnapshotStore(s.RaftDir, retain, os.Stderr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("file snapshot store: %s", err)
+		}
+	}
+
+	s.boltStore, err = New(Options{
+		Path:        filepath.Join(s.RaftDir, "raft.db"),
+		BoltOptions: &bbolt.Options{Timeout: boltOpenTimeout, ReadOnly: readOnly},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("new bbolt store: %w", err)
+	}
+
+	backend, err := s.openLogBackend(readOnly)
+	if err != nil {
+		s.boltStore.Close()
+		return nil, nil, err
+	}
+
+	return backend, snapshots, nil
+}
+
+// closeStorage closes the stores opened by openStorage
+func (s *Store) closeStorage() error {
+	var err error
+	if s.wal != nil {
+		err = s.wal.Close()
+	}
+	return errors.Join(err, s.boltStore.Close())
+}
+
+// openLogBackend opens the raft.LogStore selected by s.LogBackend
+func (s *Store) openLogBackend(readOnly bool) (raft.LogStore, error) {
+	logs, err := OpenLogStore(s.boltStore, s.LogBackend, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	s.wal, _ = logs.(*WAL)
+	return logs, nil
+}
+
+// OpenLogStore opens the raft.LogStore of backend in the raft directory holding boltStore, which is
+// boltStore itself or a WAL the caller must close. With readOnly set, the WAL is opened read-only.
+// Switching the backend of a node holding raft logs would lose them, so it fails if logs are present
+// in the other backend.
+func OpenLogStore(boltStore *BoltStore, backend LogBackend, readOnly bool) (raft.LogStore, error) {
+	walDir := filepath.Join(filepath.Dir(boltStore.path), "wal")
+
+	switch backend {
+	case "", LogBackendBolt:
+		segments, _ := filepath.Glob(filepath.Join(walDir, "*"+segmentExt))
+		if len(segments) > 0 {
+			return nil, fmt.Errorf("log backend %s selected but raft logs are present in %s", LogBackendBolt, walDir)
+		}
+		return boltStore, nil
+
+	case LogBackendWAL:
+		lastIndex, err := boltStore.LastIndex()
+		if err != nil {
+			return nil, err
+		}
+		if lastIndex != 0 {
+			return nil, fmt.Errorf("log backend %s selected but raft logs are present in %s", LogBackendWAL, boltStore.path)
+		}
+
+		wal, err := OpenWAL(WALOptions{Dir: walDir, ReadOnly: readOnly})
+		if err != nil {
+			return nil, fmt.Errorf("new wal: %s", err)
+		}
+		return wal, nil
+
+	default:
+		return nil, fmt.Errorf("unknown log backend %q", backend)
+	}
+}
+
+// Get returns the value of key and whether the key is set, keys can be set to empty values
+func (s *Store) Get(key string) (string, bool) {
+	item, ok := s.Item(key)
+	return item.Value, ok
+}
+
+// Item is a key as stored. Revision is the raft index of its last change, ExpiresAt its expiry time
+// in unix nanoseconds, zero if it does not expire.
+type Item struct {
+	Value     string
+	Revision  uint64
+	ExpiresAt int64
+}
+
+// Item returns key as stored and whether the key is set
+func (s *Store) Item(key string) (Item, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	return s.kv[key]
+	value, ok := s.kv[key]
+	if !ok || (*fsm)(s).expired(key, time.Now().UnixNano()) {
+		return Item{}, false
+	}
+	return Item{Value: value, Revision: s.revisions[key], ExpiresAt: s.expires[key]}, true
 }
 
+// Set sets key to value, via distributed consensus, in a batch with the concurrent writes
 func (s *Store) Set(key string, value string) error {
-	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not leader")
+	err := s.Limits.CheckKeyValue(key, value)
+	if err != nil {
+		return err
 	}
-
-	cmd, err := json.Marshal(command{
+	_, err = s.batch(command{
 		Op:    CmdSet,
 		Key:   key,
 		Value: value,
 	})
-	if err != nil {
-		return err
-	}
-
-	f := s.raft.Apply(cmd, raftTimeout)
-	return f.Error()
+	return err
 }
 
+// Delete deletes key, via distributed consensus, in a batch with the concurrent writes
 func (s *Store) Delete(key string) error {
-	if s.raft.State() != raft.Leader {
-		return fmt.Errorf("not leader")
-	}
-
-	cmd, err := json.Marshal(command{
+	_, err := s.batch(command{
 		Op:  CmdDelete,
 		Key: key,
 	})
+	return err
+}
+
+// Txn applies txn atomically, via distributed consensus, and reports whether its compares succeeded
+func (s *Store) Txn(txn Txn) (bool, error) {
+	err := txn.validate()
 	if err != nil {
-		return err
+		return false, err
+	}
+	err = s.Limits.CheckTxn(txn)
+	if err != nil {
+		return false, err
+	}
+	if s.raft.State() != raft.Leader {
+		return false, ErrNotLeader
+	}
+
+	txn.Now = time.Now().UnixNano()
+	c := command{
+		Op:  CmdTxn,
+		Txn: &txn,
+	}
+	err = s.checkVersion(c)
+	if err != nil {
+		return false, err
+	}
+	cmd, err := json.Marshal(c)
+	if err != nil {
+		return false, err
 	}
 
 	f := s.raft.Apply(cmd, raftTimeout)
-	return f.Error()
+	if f.Error() != nil {
+		return false, f.Error()
+	}
+	if err, ok := f.Response().(error); ok {
+		return false, err
+	}
+	return f.Response().(bool), nil
 }
 
+// Keys returns the keys of DefaultNamespace
 func (s *Store) Keys() []string {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	return maps.Keys(s.kv)
+	return s.KeysIn(DefaultNamespace)
 }
 
 func (s *Store) DataDir(raftAddr string) string {
@@ -143,68 +501,274 @@ func (s *Store) DataDir(raftAddr string) string {
 
 type fsm Store
 
+// Apply applies the command of l. Malformed entries are quarantined and respond with an error matching
+// ErrMalformedCommand, entries with an op unknown to this node stop it.
 func (f *fsm) Apply(l *raft.Log) interface{} {
 	var c command
 	err := json.Unmarshal(l.Data, &c)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		return f.quarantine(l.Index, "", l.Data, fmt.Errorf("%w: %s", ErrMalformedCommand, err))
+	}
+	return f.apply(l.Index, c)
+}
+
+// ApplyBatch applies the committed logs under one acquisition of f.mu, making fsm a raft.BatchingFSM.
+// The commands are unmarshalled before the lock is taken. Logs other than commands respond nil.
+func (f *fsm) ApplyBatch(logs []*raft.Log) []interface{} {
+	commands := make([]*command, len(logs))
+	errs := make([]error, len(logs))
+	for i, l := range logs {
+		if l.Type == raft.LogCommand {
+			commands[i] = new(command)
+			errs[i] = json.Unmarshal(l.Data, commands[i])
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resps := make([]interface{}, len(logs))
+	for i, c := range commands {
+		switch {
+		case errs[i] != nil:
+			resps[i] = f.quarantine(logs[i].Index, "", logs[i].Data, fmt.Errorf("%w: %s", ErrMalformedCommand, errs[i]))
+		case c != nil:
+			resps[i] = f.apply(logs[i].Index, *c)
+		}
+	}
+	return resps
+}
+
+// apply applies c, committed at index, and returns the response of the FSM. It must be called with f.mu held,
+// which the apply functions it dispatches to rely on.
+func (f *fsm) apply(index uint64, c command) interface{} {
+	err := c.check()
 	if err != nil {
-		log.Fatalf("failed to unmarshal command: %s", err.Error())
+		return f.quarantine(index, c.Op, nil, err)
 	}
 
 	switch c.Op {
 	case CmdSet:
-		return f.applySet(c.Key, c.Value)
+		return f.applySet(index, c.Key, c.Value)
 	case CmdDelete:
-		return f.applyDelete(c.Key)
+		return f.applyDelete(index, c.Key)
+	case CmdTxn:
+		return f.applyTxn(index, c.Txn)
+	case CmdIncr:
+		return f.applyIncr(index, c.Incr)
+	case CmdExpire:
+		return f.applyExpire(index, c.Key, c.ExpiresAt)
+	case CmdSessionCreate:
+		return f.applySessionCreate(c.Session)
+	case CmdSessionRenew:
+		return f.applySessionRenew(c.SessionID, c.Now)
+	case CmdSessionDestroy:
+		return f.applySessionDestroy(index, c.SessionID, c.ExpiresAt)
+	case CmdLock:
+		return f.applyLock(index, c.Key, c.Value, c.SessionID, c.Now)
+	case CmdUnlock:
+		return f.applyUnlock(index, c.Key, c.SessionID)
+	case CmdSequence:
+		return f.applySequence(c.Key, c.Count)
+	case CmdNamespace:
+		return f.applyNamespace(c.Key, c.Quota)
+	case CmdNamespaceDelete:
+		return f.applyNamespaceDelete(index, c.Key)
+	case CmdNodeMeta:
+		return f.applyNodeMeta(c.Key, c.Value, c.Meta)
+	case CmdBatch:
+		return f.applyBatchCommand(index, c.Batch)
 	default:
-		log.Fatalf("unrecognized command op: %s", c.Op)
+		return f.quarantine(index, c.Op, nil, fmt.Errorf("%w: %s", ErrUnknownCommand, c.Op))
 	}
-
-	return nil
 }
 
 func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	snapStore := make(map[string]string)
-	for k, v := range f.kv {
-		snapStore[k] = v
-	}
-	return &fsmSnapshot{store: snapStore}, nil
+	return &fsmSnapshot{
+		store:     maps.Clone(f.kv),
+		expires:   maps.Clone(f.expires),
+		revisions: maps.Clone(f.revisions),
+		sessions:  maps.Clone(f.sessions),
+		locks:     maps.Clone(f.locks),
+		sequences: maps.Clone(f.sequences),
+		quotas:    f.quotas(),
+		nodes:     maps.Clone(f.nodes),
+	}, nil
 }
 
 func (f *fsm) Restore(snapshot io.ReadCloser) error {
-	snapStore := make(map[string]string)
-	err := json.NewDecoder(snapshot).Decode(&snapStore)
+	var data snapshotData
+	err := json.NewDecoder(snapshot).Decode(&data)
 	if err != nil {
 		return err
 	}
 
-	f.kv = snapStore
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.kv = data.KV
+	f.expires = data.Expires
+	f.revisions = data.Revisions
+	f.sessions = data.Sessions
+	f.locks = data.Locks
+	f.sequences = data.Sequences
+	f.namespaces = make(map[string]*namespace)
+	for name, quota := range data.Namespaces {
+		f.namespaces[name] = &namespace{quota: quota}
+	}
+	f.countUsage()
+	f.nodes = data.NodeMeta
+	// Changes replaced by the snapshot can't be reported
+	f.closeWatchers()
 	return nil
 }
 
-func (f *fsm) applySet(key, value string) interface{} {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	f.kv[key] = value
+func (f *fsm) applySet(index uint64, key, value string) interface{} {
+	ops := []TxnOp{{Op: CmdSet, Key: key, Value: value}}
+	err := f.checkLocks(ops)
+	if err != nil {
+		return err
+	}
+	err = f.checkQuotas(ops)
+	if err != nil {
+		return err
+	}
+	f.put(index, key, value)
+	delete(f.expires, key)
+	f.notify(Event{Type: CmdSet, Key: key, Value: value, Index: index})
 	return nil
 }
 
-func (f *fsm) applyDelete(key string) interface{} {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-	delete(f.kv, key)
+func (f *fsm) applyDelete(index uint64, key string) interface{} {
+	err := f.checkLocks([]TxnOp{{Op: CmdDelete, Key: key}})
+	if err != nil {
+		return err
+	}
+	f.remove(key)
+	f.notify(Event{Type: CmdDelete, Key: key, Index: index})
+	return nil
+}
+
+// quotas returns the quota of every namespace. It must be called with f.mu held.
+func (f *fsm) quotas() map[string]Quota {
+	quotas := make(map[string]Quota, len(f.namespaces))
+	for name, n := range f.namespaces {
+		quotas[name] = n.quota
+	}
+	return quotas
+}
+
+// applyNodeMeta records the metadata of nodeID. Nodes older than NodeMeta only propose httpAddr.
+func (f *fsm) applyNodeMeta(nodeID, httpAddr string, meta *NodeMeta) interface{} {
+	if meta == nil {
+		meta = &NodeMeta{HTTPAddr: httpAddr}
+	}
+	f.nodes[nodeID] = *meta
+	return nil
+}
+
+// snapshotData is the content of a snapshot
+type snapshotData struct {
+	Version   int                `json:"version"`
+	KV        map[string]string  `json:"kv"`
+	Expires   map[string]int64   `json:"expires,omitempty"`
+	Revisions map[string]uint64  `json:"revisions,omitempty"`
+	Sessions  map[string]Session `json:"sessions,omitempty"`
+	Locks     map[string]Lock    `json:"locks,omitempty"`
+	Sequences map[string]uint64  `json:"sequences,omitempty"`
+	// Namespaces holds the quota of every namespace, their usage is counted on restore
+	Namespaces map[string]Quota `json:"namespaces,omitempty"`
+	// Nodes holds the HTTP address of every node, for the nodes older than NodeMeta
+	Nodes    map[string]string   `json:"nodes"`
+	NodeMeta map[string]NodeMeta `json:"node_meta,omitempty"`
+}
+
+// UnmarshalJSON decodes a snapshot, accepting snapshots written before the format was versioned,
+// which hold only the key-value map. Their values are all strings, while version is a number.
+// Keys from snapshots written before revisions were recorded get revision 1.
+func (d *snapshotData) UnmarshalJSON(buf []byte) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(buf, &fields)
+	if err != nil {
+		return err
+	}
+
+	var version int
+	if json.Unmarshal(fields["version"], &version) != nil || version == 0 {
+		err = json.Unmarshal(buf, &d.KV)
+	} else if version > snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", version)
+	} else {
+		type plain snapshotData
+		err = json.Unmarshal(buf, (*plain)(d))
+	}
+	if err != nil {
+		return err
+	}
+
+	if d.KV == nil {
+		d.KV = make(map[string]string)
+	}
+	if d.Expires == nil {
+		d.Expires = make(map[string]int64)
+	}
+	if d.Revisions == nil {
+		d.Revisions = make(map[string]uint64)
+	}
+	for key := range d.KV {
+		if d.Revisions[key] == 0 {
+			d.Revisions[key] = 1
+		}
+	}
+	if d.Sessions == nil {
+		d.Sessions = make(map[string]Session)
+	}
+	if d.Locks == nil {
+		d.Locks = make(map[string]Lock)
+	}
+	if d.Sequences == nil {
+		d.Sequences = make(map[string]uint64)
+	}
+	if d.NodeMeta == nil {
+		d.NodeMeta = make(map[string]NodeMeta)
+	}
+	for nodeID, httpAddr := range d.Nodes {
+		if _, ok := d.NodeMeta[nodeID]; !ok {
+			d.NodeMeta[nodeID] = NodeMeta{HTTPAddr: httpAddr}
+		}
+	}
 	return nil
 }
 
 type fsmSnapshot struct {
-	store map[string]string
+	store     map[string]string
+	expires   map[string]int64
+	revisions map[string]uint64
+	sessions  map[string]Session
+	locks     map[string]Lock
+	sequences map[string]uint64
+	quotas    map[string]Quota
+	nodes     map[string]NodeMeta
 }
 
 func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 	err := func() error {
-		bytes, err := json.Marshal(s.store)
+		bytes, err := json.Marshal(snapshotData{
+			Version:    snapshotVersion,
+			KV:         s.store,
+			Expires:    s.expires,
+			Revisions:  s.revisions,
+			Sessions:   s.sessions,
+			Locks:      s.locks,
+			Sequences:  s.sequences,
+			Namespaces: s.quotas,
+			Nodes:      httpAddrs(s.nodes),
+			NodeMeta:   s.nodes,
+		})
 		if err != nil {
 			return err
 		}
@@ -225,3 +789,12 @@ func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
 }
 
 func (s *fsmSnapshot) Release() {}
+
+// httpAddrs returns the HTTP address of every node of nodes
+func httpAddrs(nodes map[string]NodeMeta) map[string]string {
+	addrs := make(map[string]string, len(nodes))
+	for nodeID, meta := range nodes {
+		addrs[nodeID] = meta.HTTPAddr
+	}
+	return addrs
+}
@@ -1,9 +1,15 @@
 package store
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hashicorp/raft"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -56,7 +62,8 @@ func Test_StoreOpenSingleNode(t *testing.T) {
 	assert.True(t, slices.Contains(keys, "foo"))
 	assert.True(t, slices.Contains(keys, "far"))
 
-	value := s.Get("foo")
+	value, ok := s.Get("foo")
+	assert.True(t, ok, "key is not set")
 	assert.Equal(t, "bar", value, "key has wrong value")
 
 	err = s.Delete("foo")
@@ -64,6 +71,207 @@ func Test_StoreOpenSingleNode(t *testing.T) {
 
 	// Wait for committed log entry to be applied.
 	time.Sleep(500 * time.Millisecond)
-	value = s.Get("foo")
-	assert.Empty(t, value, "key has wrong value")
+	_, ok = s.Get("foo")
+	assert.False(t, ok, "key is still set")
+}
+
+// Test_StoreOpenWAL tests that commands are applied with raft logs stored in the WAL backend
+func Test_StoreOpenWAL(t *testing.T) {
+	s := NewStore()
+	os.Mkdir(testDir, os.ModePerm)
+	defer os.RemoveAll(testDir)
+
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = testDir
+	s.LogBackend = LogBackendWAL
+
+	err := s.Open(true, "node1")
+	assert.NoError(t, err, "failed to open store")
+
+	// Simple way to ensure there is a leader.
+	time.Sleep(2 * time.Second)
+
+	err = s.Set("foo", "bar")
+	assert.NoError(t, err, "failed to set key")
+
+	// Wait for committed log entry to be applied.
+	time.Sleep(500 * time.Millisecond)
+	value, _ := s.Get("foo")
+	assert.Equal(t, "bar", value)
+
+	lastIndex, err := s.wal.LastIndex()
+	assert.NoError(t, err)
+	assert.NotZero(t, lastIndex)
+
+	boltLastIndex, err := s.boltStore.LastIndex()
+	assert.NoError(t, err)
+	assert.Zero(t, boltLastIndex)
+}
+
+// Test_StoreRaftConfig tests that raft options override the raft defaults
+func Test_StoreRaftConfig(t *testing.T) {
+	s := NewStore()
+	config := s.raftConfig("node1")
+	assert.Equal(t, raft.DefaultConfig().HeartbeatTimeout, config.HeartbeatTimeout)
+	assert.Equal(t, raft.ServerID("node1"), config.LocalID)
+
+	s.RaftOptions = RaftOptions{
+		HeartbeatTimeout:  200 * time.Millisecond,
+		ElectionTimeout:   2 * time.Second,
+		SnapshotThreshold: 100,
+		TrailingLogs:      50,
+	}
+	config = s.raftConfig("node1")
+	assert.Equal(t, 200*time.Millisecond, config.HeartbeatTimeout)
+	assert.Equal(t, 200*time.Millisecond, config.LeaderLeaseTimeout)
+	assert.Equal(t, 2*time.Second, config.ElectionTimeout)
+	assert.Equal(t, uint64(100), config.SnapshotThreshold)
+	assert.Equal(t, uint64(50), config.TrailingLogs)
+	assert.NoError(t, raft.ValidateConfig(config))
+}
+
+// Test_StoreClose tests that a closed store releases its resources and restarts from its final snapshot
+func Test_StoreClose(t *testing.T) {
+	s := NewStore()
+	os.Mkdir(testDir, os.ModePerm)
+	defer os.RemoveAll(testDir)
+
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = testDir
+
+	err := s.Open(true, "node1")
+	assert.NoError(t, err, "failed to open store")
+
+	// Simple way to ensure there is a leader.
+	time.Sleep(2 * time.Second)
+
+	err = s.Set("foo", "bar")
+	assert.NoError(t, err, "failed to set key")
+
+	err = s.Close(true)
+	assert.NoError(t, err, "failed to close store")
+
+	snapshots, err := raft.NewFileSnapshotStore(testDir, retainSnapshotCount, os.Stderr)
+	assert.NoError(t, err)
+	metas, err := snapshots.List()
+	assert.NoError(t, err)
+	assert.Len(t, metas, 1)
+
+	// The raft data can be opened again by a new store
+	s = NewStore()
+	s.RaftAddr = "127.0.0.1:0"
+	s.RaftDir = testDir
+	err = s.Open(false, "node1")
+	assert.NoError(t, err, "failed to reopen store")
+	defer s.Close(false)
+
+	value, _ := s.Get("foo")
+	assert.Equal(t, "bar", value)
+}
+
+// Test_StoreSnapshotRestore tests that snapshots restore the keys, their revisions and node addresses, and that
+// snapshots written before node addresses and revisions were recorded still restore.
+func Test_StoreSnapshotRestore(t *testing.T) {
+	s := NewStore()
+	s.kv["foo"] = "bar"
+	s.revisions["foo"] = 7
+	s.nodes["node1"] = NodeMeta{HTTPAddr: "127.0.0.1:11001", Version: CommandVersion}
+
+	snapshot, err := (*fsm)(s).Snapshot()
+	assert.NoError(t, err)
+	sink := &raft.DiscardSnapshotSink{}
+	buf := &bytes.Buffer{}
+	err = snapshot.Persist(&testSnapshotSink{DiscardSnapshotSink: sink, buf: buf})
+	assert.NoError(t, err)
+
+	restored := NewStore()
+	err = (*fsm)(restored).Restore(io.NopCloser(buf))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": "bar"}, restored.kv)
+	assert.Equal(t, map[string]uint64{"foo": 7}, restored.revisions)
+	assert.Equal(t, map[string]NodeMeta{"node1": {HTTPAddr: "127.0.0.1:11001", Version: CommandVersion}}, restored.nodes)
+
+	// Snapshots written before node metadata hold only the HTTP addresses
+	err = (*fsm)(restored).Restore(io.NopCloser(strings.NewReader(`{"version":1,"kv":{},"nodes":{"node1":"127.0.0.1:11001"}}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]NodeMeta{"node1": {HTTPAddr: "127.0.0.1:11001"}}, restored.nodes)
+
+	// "version" is an ordinary key in the legacy format
+	legacy := NewStore()
+	err = (*fsm)(legacy).Restore(io.NopCloser(strings.NewReader(`{"foo":"bar","version":"2"}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"foo": "bar", "version": "2"}, legacy.kv)
+	assert.Equal(t, map[string]uint64{"foo": 1, "version": 1}, legacy.revisions)
+	assert.Empty(t, legacy.nodes)
+
+	err = (*fsm)(legacy).Restore(io.NopCloser(strings.NewReader(`{"version":2,"kv":{}}`)))
+	assert.Error(t, err)
+}
+
+type testSnapshotSink struct {
+	*raft.DiscardSnapshotSink
+	buf *bytes.Buffer
+}
+
+func (s *testSnapshotSink) Write(p []byte) (int, error) {
+	return s.buf.Write(p)
+}
+
+// Test_FSMApplyBatch tests that batches of logs are applied in order, with a response for every log.
+func Test_FSMApplyBatch(t *testing.T) {
+	s := NewStore()
+	logs := []*raft.Log{
+		testLog(t, 1, command{Op: CmdSet, Key: "k1", Value: "v1"}),
+		{Index: 2, Type: raft.LogConfiguration},
+		testLog(t, 3, command{Op: CmdIncr, Incr: &Incr{Key: "k1", Delta: 1}}),
+		testLog(t, 4, command{Op: CmdSet, Key: "k2", Value: "v2"}),
+		testLog(t, 5, command{Op: CmdDelete, Key: "k1"}),
+	}
+
+	resps := (*fsm)(s).ApplyBatch(logs)
+	assert.Len(t, resps, 5)
+	assert.Nil(t, resps[0])
+	assert.Nil(t, resps[1])
+	assert.Equal(t, ErrNotInteger, resps[2])
+	assert.Equal(t, map[string]string{"k2": "v2"}, s.kv)
+	assert.Equal(t, map[string]uint64{"k2": 4}, s.revisions)
+}
+
+func testLog(tb testing.TB, index uint64, c command) *raft.Log {
+	data, err := json.Marshal(c)
+	assert.NoError(tb, err)
+	return &raft.Log{Index: index, Type: raft.LogCommand, Data: data}
+}
+
+// benchmarkLogs returns n logs setting 10000 keys to values of 1 KiB
+func benchmarkLogs(b *testing.B, n int) []*raft.Log {
+	value := strings.Repeat("v", 1024)
+	logs := make([]*raft.Log, n)
+	for i := range logs {
+		logs[i] = testLog(b, uint64(i+1), command{Op: CmdSet, Key: fmt.Sprintf("key%d", i%10000), Value: value})
+	}
+	return logs
+}
+
+// BenchmarkFSMApply measures applying committed logs one at a time
+func BenchmarkFSMApply(b *testing.B) {
+	logs := benchmarkLogs(b, b.N)
+	f := (*fsm)(NewStore())
+	b.ResetTimer()
+
+	for _, l := range logs {
+		f.Apply(l)
+	}
+}
+
+// BenchmarkFSMApplyBatch measures applying committed logs in batches of MaxAppendEntries, as raft does
+func BenchmarkFSMApplyBatch(b *testing.B) {
+	logs := benchmarkLogs(b, b.N)
+	f := (*fsm)(NewStore())
+	batchSize := raft.DefaultConfig().MaxAppendEntries
+	b.ResetTimer()
+
+	for i := 0; i < len(logs); i += batchSize {
+		f.ApplyBatch(logs[i:min(i+batchSize, len(logs))])
+	}
 }
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/raft"
+	"time"
+)
+
+// The leader deletes expired keys every expireInterval, at most expireBatch keys at a time
+const (
+	expireInterval = time.Second
+	expireBatch    = 1000
+)
+
+// expired reports whether key has a time to live that ended at now, in unix nanoseconds.
+// Expired keys are hidden from reads until the leader deletes them. It must be called with f.mu held.
+func (f *fsm) expired(key string, now int64) bool {
+	expiresAt, ok := f.expires[key]
+	return ok && expiresAt <= now
+}
+
+// expireKeys deletes the expired keys and destroys the expired sessions while this node is leader,
+// until the store is closed
+func (s *Store) expireKeys() {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.shutdownCh:
+			return
+		}
+		if s.raft.State() != raft.Leader {
+			continue
+		}
+
+		for key, expiresAt := range s.expiredKeys() {
+			cmd, err := json.Marshal(command{
+				Op:        CmdExpire,
+				Key:       key,
+				ExpiresAt: expiresAt,
+			})
+			if err != nil {
+				s.logger.Printf("failed to expire %s: %s", key, err)
+				continue
+			}
+
+			err = s.raft.Apply(cmd, raftTimeout).Error()
+			if err != nil {
+				s.logger.Printf("failed to expire %s: %s", key, err)
+				break
+			}
+		}
+		s.expireSessions()
+	}
+}
+
+// expiredKeys returns up to expireBatch expired keys with their expiry time
+func (s *Store) expiredKeys() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UnixNano()
+	keys := make(map[string]int64)
+	for key, expiresAt := range s.expires {
+		if expiresAt > now {
+			continue
+		}
+		keys[key] = expiresAt
+		if len(keys) == expireBatch {
+			break
+		}
+	}
+	return keys
+}
+
+// applyExpire deletes key if its expiry time is still expiresAt, it was not set again since the leader
+// found it expired, and the key is not locked
+func (f *fsm) applyExpire(index uint64, key string, expiresAt int64) interface{} {
+	if current, ok := f.expires[key]; !ok || current != expiresAt {
+		return nil
+	}
+	// Locked keys live as long as the session holding them
+	if _, ok := f.locks[key]; ok {
+		delete(f.expires, key)
+		return nil
+	}
+	f.remove(key)
+	f.notify(Event{Type: CmdDelete, Key: key, Index: index})
+	return nil
+}
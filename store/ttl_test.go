@@ -0,0 +1,52 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// Test_TTL tests that expired keys are hidden and only deleted while their expiry time is unchanged.
+func Test_TTL(t *testing.T) {
+	s := NewStore()
+	now := time.Now().UnixNano()
+	past, future := now-int64(time.Second), now+int64(time.Hour)
+
+	txn := &Txn{Now: now, Success: []TxnOp{
+		{Op: CmdSet, Key: "expired", Value: "v1", ExpiresAt: past},
+		{Op: CmdSet, Key: "live", Value: "v2", ExpiresAt: future},
+	}}
+	applyCommand(t, s, 1, command{Op: CmdTxn, Txn: txn})
+
+	_, ok := s.Get("expired")
+	assert.False(t, ok)
+	value, _ := s.Get("live")
+	assert.Equal(t, "v2", value)
+	assert.Equal(t, []string{"live"}, s.Keys())
+
+	// Expired keys don't exist for compares, KeepTTL doesn't keep an expiry time that has passed
+	txn = &Txn{
+		Now:      now,
+		Compares: []Compare{{Key: "expired"}},
+		Success:  []TxnOp{{Op: CmdSet, Key: "expired", Value: "v3", KeepTTL: true}, {Op: CmdSet, Key: "live", Value: "v4", KeepTTL: true}},
+	}
+	assert.Equal(t, true, applyCommand(t, s, 2, command{Op: CmdTxn, Txn: txn}))
+	value, _ = s.Get("expired")
+	assert.Equal(t, "v3", value)
+	assert.Equal(t, map[string]int64{"live": future}, s.expires)
+
+	// The key was set again after the leader found it expired
+	applyCommand(t, s, 3, command{Op: CmdExpire, Key: "expired", ExpiresAt: past})
+	value, _ = s.Get("expired")
+	assert.Equal(t, "v3", value)
+
+	applyCommand(t, s, 4, command{Op: CmdExpire, Key: "live", ExpiresAt: future})
+	assert.NotContains(t, s.kv, "live")
+	assert.Empty(t, s.expires)
+
+	// Plain sets clear the expiry time
+	s.expires["expired"] = past
+	applyCommand(t, s, 5, command{Op: CmdSet, Key: "expired", Value: "v5"})
+	value, _ = s.Get("expired")
+	assert.Equal(t, "v5", value)
+}
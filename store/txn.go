@@ -0,0 +1,101 @@
+package store
+
+import (
+	"fmt"
+)
+
+// Compare is a condition of a transaction on the value of Key. With Exists set, it holds if the key is set
+// to Value, otherwise it holds if the key is not set. Expired keys are not set.
+// If Revision is not zero, it holds if the key is set and was last changed at that raft index instead.
+type Compare struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Exists   bool   `json:"exists,omitempty"`
+	Revision uint64 `json:"revision,omitempty"`
+}
+
+// TxnOp is an operation of a transaction, Op is CmdSet or CmdDelete. A set key expires at ExpiresAt,
+// in unix nanoseconds, if it is not zero. With KeepTTL, the key keeps its current expiry time instead.
+type TxnOp struct {
+	Op        string `json:"op"`
+	Key       string `json:"key"`
+	Value     string `json:"value,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	KeepTTL   bool   `json:"keep_ttl,omitempty"`
+}
+
+// Txn is a transaction. If all of Compares hold, the Success operations are applied, otherwise
+// the Failure operations are.
+type Txn struct {
+	Compares []Compare `json:"compares,omitempty"`
+	Success  []TxnOp   `json:"success,omitempty"`
+	Failure  []TxnOp   `json:"failure,omitempty"`
+
+	// Now is the time of the leader proposing the transaction in unix nanoseconds, it decides which keys
+	// have expired on every node. It is set by Store.Txn.
+	Now int64 `json:"now,omitempty"`
+}
+
+func (t *Txn) validate() error {
+	for _, op := range append(t.Success, t.Failure...) {
+		if op.Op != CmdSet && op.Op != CmdDelete {
+			return fmt.Errorf("unsupported transaction op %q on key %s", op.Op, op.Key)
+		}
+	}
+	return nil
+}
+
+// applyTxn applies txn and returns whether its compares succeeded, or the error of its operations, none of them
+// applied, if they would write a locked key or exceed the quota of a namespace
+func (f *fsm) applyTxn(index uint64, txn *Txn) interface{} {
+	succeeded := true
+	for _, c := range txn.Compares {
+		value, ok := f.kv[c.Key]
+		if f.expired(c.Key, txn.Now) {
+			value, ok = "", false
+		}
+		if c.Revision != 0 {
+			if !ok || f.revisions[c.Key] != c.Revision {
+				succeeded = false
+				break
+			}
+			continue
+		}
+		if ok != c.Exists || value != c.Value {
+			succeeded = false
+			break
+		}
+	}
+
+	ops := txn.Success
+	if !succeeded {
+		ops = txn.Failure
+	}
+	err := f.checkLocks(ops)
+	if err != nil {
+		return err
+	}
+	err = f.checkQuotas(ops)
+	if err != nil {
+		return err
+	}
+	for _, op := range ops {
+		switch op.Op {
+		case CmdSet:
+			if op.KeepTTL && f.expired(op.Key, txn.Now) {
+				delete(f.expires, op.Key)
+			}
+			f.put(index, op.Key, op.Value)
+			if op.ExpiresAt != 0 {
+				f.expires[op.Key] = op.ExpiresAt
+			} else if !op.KeepTTL {
+				delete(f.expires, op.Key)
+			}
+		case CmdDelete:
+			f.remove(op.Key)
+		}
+		f.notify(Event{Type: op.Op, Key: op.Key, Value: op.Value, Index: index})
+	}
+
+	return succeeded
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"encoding/json"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func applyCommand(t *testing.T, s *Store, index uint64, c command) interface{} {
+	data, err := json.Marshal(c)
+	assert.NoError(t, err)
+	return (*fsm)(s).Apply(&raft.Log{Index: index, Type: raft.LogCommand, Data: data})
+}
+
+// Test_Txn tests that transactions apply their success or failure operations depending on their compares.
+func Test_Txn(t *testing.T) {
+	s := NewStore()
+	s.kv["k1"] = "v1"
+
+	txn := &Txn{
+		Compares: []Compare{{Key: "k1", Value: "v1", Exists: true}, {Key: "k2"}},
+		Success:  []TxnOp{{Op: CmdSet, Key: "k2", Value: "v2"}, {Op: CmdDelete, Key: "k1"}},
+		Failure:  []TxnOp{{Op: CmdSet, Key: "failed", Value: "true"}},
+	}
+	assert.Equal(t, true, applyCommand(t, s, 1, command{Op: CmdTxn, Txn: txn}))
+	assert.Equal(t, map[string]string{"k2": "v2"}, s.kv)
+
+	// k1 no longer exists
+	assert.Equal(t, false, applyCommand(t, s, 2, command{Op: CmdTxn, Txn: txn}))
+	assert.Equal(t, map[string]string{"k2": "v2", "failed": "true"}, s.kv)
+
+	// A compare on an empty value requires the key to exist
+	s.kv["empty"] = ""
+	txn = &Txn{Compares: []Compare{{Key: "empty"}}, Success: []TxnOp{{Op: CmdDelete, Key: "empty"}}}
+	assert.Equal(t, false, applyCommand(t, s, 3, command{Op: CmdTxn, Txn: txn}))
+	assert.Contains(t, s.kv, "empty")
+
+	// Revisions are the index of the last change
+	assert.Equal(t, uint64(1), s.revisions["k2"])
+	txn = &Txn{Compares: []Compare{{Key: "k2", Revision: 2}}, Success: []TxnOp{{Op: CmdSet, Key: "k2", Value: "v3"}}}
+	assert.Equal(t, false, applyCommand(t, s, 4, command{Op: CmdTxn, Txn: txn}))
+	txn.Compares[0].Revision = 1
+	assert.Equal(t, true, applyCommand(t, s, 5, command{Op: CmdTxn, Txn: txn}))
+	item, ok := s.Item("k2")
+	assert.True(t, ok)
+	assert.Equal(t, Item{Value: "v3", Revision: 5}, item)
+}
+
+// Test_TxnInvalidOp tests that transactions with unsupported operations are rejected before being replicated.
+func Test_TxnInvalidOp(t *testing.T) {
+	s := NewStore()
+	_, err := s.Txn(Txn{Success: []TxnOp{{Op: CmdNodeMeta, Key: "node1", Value: "localhost:11001"}}})
+	assert.ErrorContains(t, err, "unsupported transaction op")
+}
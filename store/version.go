@@ -0,0 +1,63 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// CommandVersion is the version of the commands applied by this node. It is raised when an op is added, and the
+// op is recorded in opVersions.
+const CommandVersion = 1
+
+// opVersions maps the ops, and transaction ops, added after the first version to the CommandVersion adding them
+var opVersions = map[string]int{}
+
+// ErrUnsupportedCommand is returned for writes of an op some node of the cluster can't apply yet. The node would
+// quarantine the entry while the others apply it, so the write is rejected until every node is upgraded.
+var ErrUnsupportedCommand = errors.New("command not supported by every node of the cluster")
+
+// checkVersion returns an error matching ErrUnsupportedCommand if the op of c, or of one of its transaction
+// operations, was added after the CommandVersion of a node of the cluster
+func (s *Store) checkVersion(c command) error {
+	ops := []string{c.Op}
+	if c.Txn != nil {
+		for _, op := range append(slices.Clone(c.Txn.Success), c.Txn.Failure...) {
+			ops = append(ops, op.Op)
+		}
+	}
+
+	for _, op := range ops {
+		if opVersions[op] <= 1 {
+			continue
+		}
+		version, err := s.clusterVersion()
+		if err != nil {
+			return err
+		}
+		if opVersions[op] > version {
+			return fmt.Errorf("%w: %s needs version %d, the cluster runs version %d",
+				ErrUnsupportedCommand, op, opVersions[op], version)
+		}
+	}
+	return nil
+}
+
+// clusterVersion returns the lowest CommandVersion of the servers of the raft configuration. Nodes that never
+// advertised their version, such as nodes older than versioning, run version 1.
+func (s *Store) clusterVersion() (int, error) {
+	future := s.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	version := CommandVersion
+	for _, server := range future.Configuration().Servers {
+		if string(server.ID) != s.localID {
+			version = min(version, max(s.nodes[string(server.ID)].Version, 1))
+		}
+	}
+	return version, nil
+}
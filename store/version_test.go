@@ -0,0 +1,26 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Test_CheckVersion tests that ops added after the version of a node of the cluster are not proposed.
+func Test_CheckVersion(t *testing.T) {
+	s := openBatchStore(t, DefaultMaxBatchSize, 0)
+	opVersions["COMPACT"] = CommandVersion + 1
+	defer delete(opVersions, "COMPACT")
+
+	_, err := s.propose(command{Op: "COMPACT"})
+	assert.ErrorIs(t, err, ErrUnsupportedCommand)
+	_, err = s.batch(command{Op: "COMPACT"})
+	assert.ErrorIs(t, err, ErrUnsupportedCommand)
+	err = s.checkVersion(command{Op: CmdTxn, Txn: &Txn{Failure: []TxnOp{{Op: "COMPACT", Key: "k1"}}}})
+	assert.ErrorIs(t, err, ErrUnsupportedCommand)
+	assert.Empty(t, s.Quarantined())
+
+	version, err := s.clusterVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, CommandVersion, version)
+	assert.NoError(t, s.Set("k1", "v1"))
+}
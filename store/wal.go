@@ -0,0 +1,537 @@
+package store
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/raft"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultSegmentSize is the size at which the WAL rolls over to a new segment file
+	DefaultSegmentSize = 64 << 20
+
+	segmentExt = ".wal"
+	// firstIndexFile holds the first index of the WAL once a prefix of the first segment has been deleted
+	firstIndexFile = "first-index"
+	// recordLenSize is the length of the size prefix written before each record in a segment
+	recordLenSize = 4
+)
+
+var (
+	ErrNonContiguous = errors.New("non-contiguous log index")
+	ErrWALReadOnly   = errors.New("wal is read-only")
+)
+
+type WALOptions struct {
+	// Dir is the directory holding the segment files
+	Dir string
+
+	// SegmentSize is the size in bytes at which a segment is sealed and a new one started.
+	// Defaults to DefaultSegmentSize.
+	SegmentSize int64
+
+	// NoSync causes the WAL to skip fsync calls after each write to the log.
+	NoSync bool
+
+	// ReadOnly opens the segments without modifying the directory, a torn record at the end of the log is
+	// skipped instead of truncated. Writes fail with ErrWALReadOnly.
+	ReadOnly bool
+}
+
+// WAL is an append-only write-ahead log implementing raft.LogStore and raft.MonotonicLogStore.
+//
+// Logs are appended to segment files named after the index of their first log. Each record in a
+// segment is a 4 byte length followed by the log encoded as in convertLogToBytes, checksum included.
+// The file offset of every record is kept in an in-memory index per segment, rebuilt by scanning the
+// segments on open. A segment is sealed once it reaches SegmentSize and a new one is started.
+//
+// Raft only ever deletes a prefix of the log after snapshots or a suffix on conflicts. Deleting a prefix
+// removes whole segments and records the new first index, deleting a suffix truncates the segment files.
+type WAL struct {
+	mu       sync.RWMutex
+	options  WALOptions
+	segments []*segment
+
+	// firstIndex is the first index of the log, which may be past the first record of segments[0]
+	firstIndex uint64
+}
+
+// segment is a single segment file with the index of the records it holds
+type segment struct {
+	firstIndex uint64
+	// offsets[i] is the file offset of the record for log firstIndex+i
+	offsets []int64
+	size    int64
+	file    *os.File
+}
+
+func (s *segment) lastIndex() uint64 {
+	return s.firstIndex + uint64(len(s.offsets)) - 1
+}
+
+func NewWAL(dir string) (*WAL, error) {
+	return OpenWAL(WALOptions{Dir: dir})
+}
+
+func OpenWAL(options WALOptions) (*WAL, error) {
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSegmentSize
+	}
+
+	if !options.ReadOnly {
+		err := os.MkdirAll(options.Dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	w := &WAL{options: options}
+	err := w.load()
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// load opens and indexes the existing segment files. A torn or corrupt record at the end of the
+// last segment, left by a crash in the middle of a write, is truncated away.
+func (w *WAL) load() error {
+	entries, err := os.ReadDir(w.options.Dir)
+	if err != nil {
+		return err
+	}
+
+	var firstIndexes []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		idx, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid segment file name %s", name)
+		}
+		firstIndexes = append(firstIndexes, idx)
+	}
+	sort.Slice(firstIndexes, func(i, j int) bool { return firstIndexes[i] < firstIndexes[j] })
+
+	for i, idx := range firstIndexes {
+		seg, err := w.openSegment(idx, i == len(firstIndexes)-1)
+		if err != nil {
+			return err
+		}
+		if len(w.segments) > 0 && w.segments[len(w.segments)-1].lastIndex()+1 != seg.firstIndex {
+			seg.file.Close()
+			return fmt.Errorf("segment %s: %w", w.segmentPath(idx), ErrNonContiguous)
+		}
+		if len(seg.offsets) == 0 {
+			seg.file.Close()
+			if !w.options.ReadOnly {
+				os.Remove(w.segmentPath(idx))
+			}
+			continue
+		}
+		w.segments = append(w.segments, seg)
+	}
+
+	if len(w.segments) == 0 {
+		if w.options.ReadOnly {
+			return nil
+		}
+		return os.RemoveAll(filepath.Join(w.options.Dir, firstIndexFile))
+	}
+
+	w.firstIndex = w.segments[0].firstIndex
+	path := filepath.Join(w.options.Dir, firstIndexFile)
+	buf, err := os.ReadFile(path)
+	if err == nil && len(buf) != 8 {
+		return fmt.Errorf("%s holds %d bytes instead of 8: %w", path, len(buf), ErrCorrupt)
+	} else if err == nil {
+		w.firstIndex = max(w.firstIndex, bytesToUint64(buf))
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if !w.options.ReadOnly {
+		w.removeSegmentsBefore(w.firstIndex)
+	}
+	return nil
+}
+
+// openSegment opens the segment file starting at firstIndex and builds its index
+func (w *WAL) openSegment(firstIndex uint64, last bool) (*segment, error) {
+	path := w.segmentPath(firstIndex)
+	flag := os.O_RDWR | os.O_CREATE
+	if w.options.ReadOnly {
+		flag = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flag, fileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	seg := &segment{firstIndex: firstIndex, file: file}
+	reader := bufio.NewReader(file)
+	var log raft.Log
+	var lenBuf [recordLenSize]byte
+	for {
+		_, err = io.ReadFull(reader, lenBuf[:])
+		if err == io.EOF {
+			break
+		}
+
+		var buf []byte
+		if err == nil {
+			recordLen := int64(binary.BigEndian.Uint32(lenBuf[:]))
+			if seg.size+recordLenSize+recordLen > info.Size() {
+				err = ErrCorrupt
+			} else {
+				buf = make([]byte, recordLen)
+				_, err = io.ReadFull(reader, buf)
+			}
+		}
+		if err == nil {
+			err = convertBytesToLog(buf, &log)
+		}
+		if err == nil && log.Index != seg.firstIndex+uint64(len(seg.offsets)) {
+			err = ErrCorrupt
+		}
+
+		if err != nil {
+			if !last {
+				file.Close()
+				return nil, fmt.Errorf("segment %s at offset %d: %w", path, seg.size, ErrCorrupt)
+			}
+			// torn write at the end of the log
+			if w.options.ReadOnly {
+				break
+			}
+			err = file.Truncate(seg.size)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			break
+		}
+
+		seg.offsets = append(seg.offsets, seg.size)
+		seg.size += int64(recordLenSize + len(buf))
+	}
+
+	return seg, nil
+}
+
+func (w *WAL) segmentPath(firstIndex uint64) string {
+	return filepath.Join(w.options.Dir, fmt.Sprintf("%020d%s", firstIndex, segmentExt))
+}
+
+// Close closes all segment files
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var err error
+	for _, seg := range w.segments {
+		err = errors.Join(err, seg.file.Close())
+	}
+	w.segments = nil
+	return err
+}
+
+// -------------Implement raft.LogStore interface-----------------------//
+
+// FirstIndex returns the first index of raft logs written. 0 for no entries.
+func (w *WAL) FirstIndex() (uint64, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if len(w.segments) == 0 {
+		return 0, nil
+	}
+	return w.firstIndex, nil
+}
+
+// LastIndex returns the last index of raft logs written. 0 for no entries.
+func (w *WAL) LastIndex() (uint64, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.lastIndex(), nil
+}
+
+func (w *WAL) lastIndex() uint64 {
+	if len(w.segments) == 0 {
+		return 0
+	}
+	return w.segments[len(w.segments)-1].lastIndex()
+}
+
+// GetLog gets a log entry at a given index.
+func (w *WAL) GetLog(idx uint64, log *raft.Log) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if len(w.segments) == 0 || idx < w.firstIndex || idx > w.lastIndex() {
+		return raft.ErrLogNotFound
+	}
+
+	i := sort.Search(len(w.segments), func(i int) bool { return w.segments[i].lastIndex() >= idx })
+	seg := w.segments[i]
+	pos := idx - seg.firstIndex
+	start := seg.offsets[pos] + recordLenSize
+	end := seg.size
+	if pos+1 < uint64(len(seg.offsets)) {
+		end = seg.offsets[pos+1]
+	}
+
+	buf := make([]byte, end-start)
+	_, err := seg.file.ReadAt(buf, start)
+	if err != nil {
+		return err
+	}
+
+	err = convertBytesToLog(buf, log)
+	if err == nil && log.Index != idx {
+		err = ErrCorrupt
+	}
+	if err != nil {
+		return fmt.Errorf("log entry at index %d: %w", idx, err)
+	}
+	return nil
+}
+
+// StoreLog stores a log entry.
+func (w *WAL) StoreLog(log *raft.Log) error {
+	return w.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs appends multiple log entries. The logs must directly follow the last index of the WAL.
+func (w *WAL) StoreLogs(logs []*raft.Log) error {
+	if w.options.ReadOnly {
+		return ErrWALReadOnly
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var dirty []*segment
+	var created bool
+	for _, log := range logs {
+		last := w.lastIndex()
+		if len(w.segments) > 0 && log.Index != last+1 {
+			return fmt.Errorf("storing log %d after %d: %w", log.Index, last, ErrNonContiguous)
+		}
+
+		record := convertLogToBytes(log)
+		buf := make([]byte, recordLenSize, recordLenSize+len(record))
+		binary.BigEndian.PutUint32(buf, uint32(len(record)))
+		buf = append(buf, record...)
+
+		var seg *segment
+		if len(w.segments) > 0 && w.segments[len(w.segments)-1].size < w.options.SegmentSize {
+			seg = w.segments[len(w.segments)-1]
+		} else {
+			// seal the last segment and start a new one
+			file, err := os.OpenFile(w.segmentPath(log.Index), os.O_RDWR|os.O_CREATE|os.O_TRUNC, fileMode)
+			if err != nil {
+				return err
+			}
+			seg = &segment{firstIndex: log.Index, file: file}
+			created = true
+		}
+
+		_, err := seg.file.WriteAt(buf, seg.size)
+		if err != nil {
+			if len(seg.offsets) == 0 {
+				seg.file.Close()
+				os.Remove(seg.file.Name())
+				return err
+			}
+			// Drop the partially written record, the segment could not be loaded once sealed otherwise
+			truncErr := seg.file.Truncate(seg.size)
+			if truncErr != nil {
+				return fmt.Errorf("%w, truncate %s: %w", err, seg.file.Name(), truncErr)
+			}
+			return err
+		}
+
+		if len(seg.offsets) == 0 {
+			w.segments = append(w.segments, seg)
+			if len(w.segments) == 1 {
+				w.firstIndex = seg.firstIndex
+			}
+		}
+		seg.offsets = append(seg.offsets, seg.size)
+		seg.size += int64(len(buf))
+
+		if len(dirty) == 0 || dirty[len(dirty)-1] != seg {
+			dirty = append(dirty, seg)
+		}
+	}
+
+	if w.options.NoSync {
+		return nil
+	}
+	for _, seg := range dirty {
+		err := seg.file.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	if created {
+		return w.syncDir()
+	}
+	return nil
+}
+
+// DeleteRange deletes a range of log entries. The range is inclusive.
+// Only a prefix or a suffix of the log can be deleted.
+func (w *WAL) DeleteRange(min, max uint64) error {
+	if w.options.ReadOnly {
+		return ErrWALReadOnly
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.segments) == 0 || max < w.firstIndex || min > w.lastIndex() {
+		return nil
+	}
+
+	switch {
+	case min <= w.firstIndex && max >= w.lastIndex():
+		return w.truncateAll()
+	case min <= w.firstIndex:
+		return w.truncatePrefix(max)
+	case max >= w.lastIndex():
+		return w.truncateSuffix(min)
+	default:
+		return fmt.Errorf("wal: cannot delete logs %d to %d from the middle of the log", min, max)
+	}
+}
+
+// -------------End raft.LogStore interface implementation-----------------------//
+
+// IsMonotonic implements the raft.MonotonicLogStore interface.
+func (w *WAL) IsMonotonic() bool {
+	return true
+}
+
+// truncateAll removes every segment
+func (w *WAL) truncateAll() error {
+	var err error
+	for _, seg := range w.segments {
+		err = errors.Join(err, seg.file.Close(), os.Remove(seg.file.Name()))
+	}
+	w.segments = nil
+	w.firstIndex = 0
+	err = errors.Join(err, os.RemoveAll(filepath.Join(w.options.Dir, firstIndexFile)))
+	if err != nil {
+		return err
+	}
+	return w.syncDir()
+}
+
+// truncatePrefix deletes the logs up to and including max by recording max+1 as the first index
+// and removing the segments holding only deleted logs
+func (w *WAL) truncatePrefix(max uint64) error {
+	path := filepath.Join(w.options.Dir, firstIndexFile)
+	err := writeFileAtomic(path, uint64ToBytes(max+1), !w.options.NoSync)
+	if err != nil {
+		return err
+	}
+
+	w.firstIndex = max + 1
+	return w.removeSegmentsBefore(w.firstIndex)
+}
+
+// removeSegmentsBefore removes the segments whose logs all precede idx
+func (w *WAL) removeSegmentsBefore(idx uint64) error {
+	var err error
+	for len(w.segments) > 0 && w.segments[0].lastIndex() < idx {
+		seg := w.segments[0]
+		err = errors.Join(err, seg.file.Close(), os.Remove(seg.file.Name()))
+		w.segments = w.segments[1:]
+	}
+	if err != nil {
+		return err
+	}
+	return w.syncDir()
+}
+
+// truncateSuffix deletes the logs from min onwards, min being past the first index
+func (w *WAL) truncateSuffix(min uint64) error {
+	for len(w.segments) > 0 {
+		seg := w.segments[len(w.segments)-1]
+		if seg.firstIndex < min {
+			pos := min - seg.firstIndex
+			err := seg.file.Truncate(seg.offsets[pos])
+			if err == nil && !w.options.NoSync {
+				err = seg.file.Sync()
+			}
+			if err != nil {
+				return err
+			}
+			seg.size = seg.offsets[pos]
+			seg.offsets = seg.offsets[:pos]
+			return w.syncDir()
+		}
+
+		err := errors.Join(seg.file.Close(), os.Remove(seg.file.Name()))
+		w.segments = w.segments[:len(w.segments)-1]
+		if err != nil {
+			return err
+		}
+	}
+	return w.syncDir()
+}
+
+// syncDir fsyncs the WAL directory, so that the segments created and removed survive a crash, unless
+// NoSync is set
+func (w *WAL) syncDir() error {
+	if w.options.NoSync {
+		return nil
+	}
+	return syncDir(w.options.Dir)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path. With sync set, the file and then
+// its directory are fsynced, so that the rename survives a crash.
+func writeFileAtomic(path string, data []byte, sync bool) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fileMode)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil && sync {
+		err = file.Sync()
+	}
+	err = errors.Join(err, file.Close())
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil || !sync {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
@@ -0,0 +1,210 @@
+package store
+
+import (
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testWAL(t testing.TB, segmentSize int64) *WAL {
+	wal, err := OpenWAL(WALOptions{Dir: t.TempDir(), SegmentSize: segmentSize})
+	assert.NoError(t, err)
+	return wal
+}
+
+func TestWAL_Implements(t *testing.T) {
+	var store any = &WAL{}
+
+	_, ok := store.(raft.LogStore)
+	assert.True(t, ok, "WAL does not implement raft.LogStore")
+
+	_, ok = store.(raft.MonotonicLogStore)
+	assert.True(t, ok, "WAL does not implement raft.MonotonicLogStore")
+}
+
+func TestWALSegments(t *testing.T) {
+	// Small segments so that every few logs start a new segment
+	wal := testWAL(t, 100)
+	defer wal.Close()
+
+	logs := testRaftLogs(1, 20)
+	err := wal.StoreLogs(logs)
+	assert.NoError(t, err)
+	assert.Greater(t, len(wal.segments), 1)
+
+	// Deleting a prefix removes the segments holding only deleted logs
+	segments := len(wal.segments)
+	err = wal.DeleteRange(1, 10)
+	assert.NoError(t, err)
+	assert.Less(t, len(wal.segments), segments)
+
+	files, err := filepath.Glob(filepath.Join(wal.options.Dir, "*"+segmentExt))
+	assert.NoError(t, err)
+	assert.Equal(t, len(wal.segments), len(files))
+
+	// The WAL is rebuilt from the segment files on open
+	err = wal.Close()
+	assert.NoError(t, err)
+	wal, err = OpenWAL(wal.options)
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	idx, err := wal.FirstIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(11), idx)
+
+	idx, err = wal.LastIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(20), idx)
+
+	err = wal.GetLog(10, new(raft.Log))
+	assert.ErrorIs(t, err, raft.ErrLogNotFound)
+
+	for _, want := range logs[10:] {
+		log := new(raft.Log)
+		err = wal.GetLog(want.Index, log)
+		assert.NoError(t, err)
+		assert.Equal(t, want, log)
+	}
+}
+
+func TestWALTornWrite(t *testing.T) {
+	wal := testWAL(t, 0)
+	err := wal.StoreLogs(testRaftLogs(1, 3))
+	assert.NoError(t, err)
+	path := wal.segments[0].file.Name()
+	size := wal.segments[0].size
+	wal.Close()
+
+	// Simulate a crash in the middle of appending log 4
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, fileMode)
+	assert.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 40, 1, 2, 3})
+	assert.NoError(t, err)
+	file.Close()
+
+	wal, err = OpenWAL(wal.options)
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	idx, err := wal.LastIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), idx)
+	assert.Equal(t, size, wal.segments[0].size)
+
+	err = wal.StoreLog(testRaftLog(4, "log4"))
+	assert.NoError(t, err)
+
+	log := new(raft.Log)
+	err = wal.GetLog(4, log)
+	assert.NoError(t, err)
+	assert.Equal(t, testRaftLog(4, "log4"), log)
+}
+
+// TestWALCorruptFirstIndex tests that a first-index file of the wrong length fails the open instead of
+// bringing back deleted logs
+func TestWALCorruptFirstIndex(t *testing.T) {
+	wal := testWAL(t, 100)
+	err := wal.StoreLogs(testRaftLogs(1, 20))
+	assert.NoError(t, err)
+	err = wal.DeleteRange(1, 5)
+	assert.NoError(t, err)
+	wal.Close()
+
+	path := filepath.Join(wal.options.Dir, firstIndexFile)
+	err = os.WriteFile(path, []byte{0, 0, 6}, fileMode)
+	assert.NoError(t, err)
+	_, err = OpenWAL(wal.options)
+	assert.ErrorIs(t, err, ErrCorrupt)
+}
+
+// TestWALReadOnly tests that a read-only WAL serves the logs without modifying the segment files
+func TestWALReadOnly(t *testing.T) {
+	wal := testWAL(t, 0)
+	err := wal.StoreLogs(testRaftLogs(1, 3))
+	assert.NoError(t, err)
+	path := wal.segments[0].file.Name()
+	wal.Close()
+
+	// A torn record is skipped, not truncated
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, fileMode)
+	assert.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 40, 1, 2, 3})
+	assert.NoError(t, err)
+	file.Close()
+	before, err := os.Stat(path)
+	assert.NoError(t, err)
+
+	options := wal.options
+	options.ReadOnly = true
+	wal, err = OpenWAL(options)
+	assert.NoError(t, err)
+	defer wal.Close()
+
+	idx, err := wal.LastIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), idx)
+	err = wal.GetLog(3, new(raft.Log))
+	assert.NoError(t, err)
+
+	err = wal.StoreLog(testRaftLog(4, "log4"))
+	assert.ErrorIs(t, err, ErrWALReadOnly)
+	err = wal.DeleteRange(1, 3)
+	assert.ErrorIs(t, err, ErrWALReadOnly)
+
+	after, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, before.Size(), after.Size())
+}
+
+func TestWALInvalidOperations(t *testing.T) {
+	wal := testWAL(t, 0)
+	defer wal.Close()
+
+	err := wal.StoreLogs(testRaftLogs(1, 10))
+	assert.NoError(t, err)
+
+	// Logs must be appended without gaps
+	err = wal.StoreLog(testRaftLog(12, "log12"))
+	assert.ErrorIs(t, err, ErrNonContiguous)
+
+	// Logs can't be removed from the middle of the log
+	err = wal.DeleteRange(4, 6)
+	assert.Error(t, err)
+
+	idx, err := wal.LastIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(10), idx)
+}
+
+func BenchmarkBoltStoreStoreLogs(b *testing.B) {
+	boltStore := testBoltStore(b)
+	defer boltStore.Close()
+	defer os.Remove(boltStore.path)
+
+	benchmarkStoreLogs(b, boltStore)
+}
+
+func BenchmarkWALStoreLogs(b *testing.B) {
+	wal := testWAL(b, 0)
+	defer wal.Close()
+
+	benchmarkStoreLogs(b, wal)
+}
+
+func benchmarkStoreLogs(b *testing.B, store raft.LogStore) {
+	const batch = 16
+	data := make([]byte, 256)
+	logs := make([]*raft.Log, batch)
+	for i := 0; i < b.N; i++ {
+		for j := range logs {
+			logs[j] = &raft.Log{Index: uint64(i*batch + j + 1), Data: data}
+		}
+		err := store.StoreLogs(logs)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"strings"
+)
+
+// watchBuffer is the number of events a watcher may fall behind before it is closed
+const watchBuffer = 256
+
+// Event is a change applied to the store, Type is CmdSet or CmdDelete. Index is the raft log index
+// of the change, events of a transaction share the same index.
+type Event struct {
+	Type  string
+	Key   string
+	Value string
+	Index uint64
+}
+
+type watcher struct {
+	prefix string
+	ch     chan Event
+}
+
+// Watch returns a channel receiving the changes applied on this node to the keys starting with prefix,
+// until cancel is called. The channel is closed when the watcher falls behind by more than watchBuffer events
+// or when the store is restored from a snapshot, the caller then has to read the store again.
+func (s *Store) Watch(prefix string) (<-chan Event, func()) {
+	w := &watcher{prefix: prefix, ch: make(chan Event, watchBuffer)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.watchers[w] = struct{}{}
+
+	return w.ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.watchers[w]; ok {
+			delete(s.watchers, w)
+			close(w.ch)
+		}
+	}
+}
+
+// notify sends event to the watchers of its key. It must be called with f.mu held.
+func (f *fsm) notify(event Event) {
+	for w := range f.watchers {
+		if !strings.HasPrefix(event.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- event:
+		default:
+			delete(f.watchers, w)
+			close(w.ch)
+		}
+	}
+}
+
+// closeWatchers closes all watchers. It must be called with f.mu held.
+func (f *fsm) closeWatchers() {
+	for w := range f.watchers {
+		delete(f.watchers, w)
+		close(w.ch)
+	}
+}
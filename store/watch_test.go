@@ -0,0 +1,45 @@
+package store
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// Test_Watch tests that watchers receive the changes to their prefix until they are canceled.
+func Test_Watch(t *testing.T) {
+	s := NewStore()
+	events, cancel := s.Watch("app/")
+
+	applyCommand(t, s, 1, command{Op: CmdSet, Key: "app/k1", Value: "v1"})
+	applyCommand(t, s, 2, command{Op: CmdSet, Key: "other", Value: "v2"})
+	applyCommand(t, s, 3, command{Op: CmdTxn, Txn: &Txn{Success: []TxnOp{
+		{Op: CmdDelete, Key: "app/k1"},
+		{Op: CmdSet, Key: "app/k2", Value: "v2"},
+	}}})
+
+	assert.Equal(t, Event{Type: CmdSet, Key: "app/k1", Value: "v1", Index: 1}, <-events)
+	assert.Equal(t, Event{Type: CmdDelete, Key: "app/k1", Index: 3}, <-events)
+	assert.Equal(t, Event{Type: CmdSet, Key: "app/k2", Value: "v2", Index: 3}, <-events)
+
+	cancel()
+	_, ok := <-events
+	assert.False(t, ok)
+	cancel()
+}
+
+// Test_WatchSlow tests that watchers falling behind are closed.
+func Test_WatchSlow(t *testing.T) {
+	s := NewStore()
+	events, cancel := s.Watch("")
+	defer cancel()
+
+	for i := 0; i <= watchBuffer; i++ {
+		applyCommand(t, s, uint64(i+1), command{Op: CmdSet, Key: "k", Value: "v"})
+	}
+
+	received := 0
+	for range events {
+		received++
+	}
+	assert.Equal(t, watchBuffer, received)
+}